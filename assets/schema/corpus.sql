@@ -17,14 +17,83 @@ CREATE TABLE IF NOT EXISTS raw_files (
     size INTEGER NOT NULL,                  -- taille en bytes
     -- HOROS: No BLOB storage for large files. Use external_path instead.
     external_path TEXT NOT NULL,            -- chemin externe (HOROS: cp/rm compatible)
+    encoding TEXT NOT NULL DEFAULT 'identity' -- identity | gzip (objet compressé, suffixe .gz)
+        CHECK (encoding IN ('identity', 'gzip')),
     checksum TEXT NOT NULL,                 -- hash pour vérification intégrité
     imported_at TEXT NOT NULL DEFAULT (datetime('now')),
     status TEXT NOT NULL DEFAULT 'pending'  -- pending | extracted | chunked | vectorized | failed
-        CHECK (status IN ('pending', 'extracted', 'chunked', 'vectorized', 'failed'))
+        CHECK (status IN ('pending', 'extracted', 'chunked', 'vectorized', 'failed')),
+    superseded_by TEXT,                     -- id du contenu qui remplace ce fichier (même chemin)
+    deleted_at TEXT,                        -- source supprimée : ses chunks sont retirés par le merger
+    collection TEXT,                        -- regroupement choisi à l'ingestion (--collection)
+    tags TEXT,                              -- JSON objet clé/valeur (--tag k=v), recopié sur les chunks
+    attempts INTEGER NOT NULL DEFAULT 0,    -- échecs de traitement (run ou merge)
+    last_error TEXT,                        -- raison du dernier échec
+    last_failed_at TEXT,
+    next_attempt_at TEXT,                   -- backoff : pas de nouvel essai avant cette date
+    priority INTEGER                        -- priorité de traitement (--priority) ; NULL : celle de la collection
 );
 
 CREATE INDEX IF NOT EXISTS idx_raw_files_status ON raw_files(status);
 CREATE INDEX IF NOT EXISTS idx_raw_files_mime ON raw_files(mime_type);
+CREATE INDEX IF NOT EXISTS idx_raw_files_collection ON raw_files(collection);
+CREATE INDEX IF NOT EXISTS idx_raw_files_queue ON raw_files(status, priority, imported_at);
+
+-- Documents : identité stable d'un fichier à travers ses versions.
+-- raw_files.id est le hash du contenu ; un document (chemin source absolu ou
+-- id logique fourni à l'ingestion) enchaîne les contenus successifs.
+CREATE TABLE IF NOT EXISTS documents (
+    id TEXT PRIMARY KEY,                    -- chemin source absolu ou id logique
+    source_path TEXT NOT NULL,              -- dernier chemin ingéré
+    current_version INTEGER,                -- dernière version fusionnée (NULL avant le premier merge)
+    created_at TEXT NOT NULL DEFAULT (datetime('now')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_documents_path ON documents(source_path);
+
+-- Chaîne des versions : une ligne par contenu ingéré pour le document.
+-- Un même contenu peut revenir (retour arrière) sous un nouveau numéro.
+CREATE TABLE IF NOT EXISTS document_versions (
+    document_id TEXT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    file_id TEXT NOT NULL REFERENCES raw_files(id) ON DELETE CASCADE,
+    ingested_at TEXT NOT NULL DEFAULT (datetime('now')),
+    merged_at TEXT,                         -- fusion du run qui a traité cette version
+    retired_at TEXT,                        -- une version plus récente a été fusionnée, ou l'archive parente a disparu
+    PRIMARY KEY (document_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_versions_file ON document_versions(file_id);
+
+-- Membres d'archives (zip, tar, tar.gz) : chaque membre est ingéré comme un
+-- fichier à part entière, avec un chemin source `archive.zip!/docs/a.pdf`.
+-- Quand aucune archive vivante ne contient plus un membre, ses versions sont
+-- retirées par le merger.
+CREATE TABLE IF NOT EXISTS archive_members (
+    archive_id TEXT NOT NULL REFERENCES raw_files(id) ON DELETE CASCADE,
+    member_path TEXT NOT NULL,              -- chemin dans l'archive
+    file_id TEXT NOT NULL REFERENCES raw_files(id) ON DELETE CASCADE,
+    document_id TEXT NOT NULL,              -- document du membre
+    PRIMARY KEY (archive_id, member_path)
+);
+
+CREATE INDEX IF NOT EXISTS idx_archive_members_file ON archive_members(file_id);
+CREATE INDEX IF NOT EXISTS idx_archive_members_document ON archive_members(document_id);
+
+-- État du watch : dernier stat connu de chaque fichier surveillé.
+-- Un fichier dont taille et mtime n'ont pas changé n'est ni relu ni re-hashé.
+CREATE TABLE IF NOT EXISTS watch_state (
+    path TEXT PRIMARY KEY,                  -- chemin absolu du fichier
+    root TEXT NOT NULL,                     -- répertoire surveillé qui le contient
+    size INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,                 -- unix nanosecondes
+    file_id TEXT NOT NULL REFERENCES raw_files(id) ON DELETE CASCADE,
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_watch_root ON watch_state(root);
+CREATE INDEX IF NOT EXISTS idx_watch_file ON watch_state(file_id);
 
 -- ============================================================================
 -- LAYER 1 : Extraction (sortie des extracteurs)
@@ -86,12 +155,16 @@ CREATE TABLE IF NOT EXISTS chunks (
     position INTEGER NOT NULL,              -- ordre dans le fichier source
     parent_id TEXT REFERENCES chunks(id),   -- hiérarchie optionnelle
     created_at TEXT NOT NULL DEFAULT (datetime('now')),
-    created_by_run TEXT                     -- ID du run qui l'a créé
+    created_by_run TEXT,                    -- ID du run qui l'a créé
+    tombstoned_at TEXT,                     -- retiré de la recherche (source supprimée ou version remplacée)
+    collection TEXT,                        -- copie de raw_files.collection (filtre de recherche)
+    tags TEXT                               -- copie de raw_files.tags (filtre --where)
 );
 
 CREATE INDEX IF NOT EXISTS idx_chunks_file ON chunks(file_id);
 CREATE INDEX IF NOT EXISTS idx_chunks_hash ON chunks(hash);
 CREATE INDEX IF NOT EXISTS idx_chunks_type ON chunks(chunk_type);
+CREATE INDEX IF NOT EXISTS idx_chunks_collection ON chunks(collection);
 
 -- FTS5 pour recherche full-text
 CREATE VIRTUAL TABLE IF NOT EXISTS chunks_fts USING fts5(
@@ -114,6 +187,15 @@ CREATE TRIGGER IF NOT EXISTS chunks_au AFTER UPDATE ON chunks BEGIN
     INSERT INTO chunks_fts(rowid, content) VALUES (NEW.rowid, NEW.content);
 END;
 
+-- Lignée des chunks : chaîne [input_id, "step_table#rowid", ...] copiée de _output._source_chain
+CREATE TABLE IF NOT EXISTS chunk_lineage (
+    chunk_id TEXT PRIMARY KEY REFERENCES chunks(id) ON DELETE CASCADE,
+    run_id TEXT NOT NULL,                   -- run qui a produit le chunk (run_{id}.db)
+    source_chain TEXT NOT NULL              -- JSON array, du fichier brut jusqu'à _output
+);
+
+CREATE INDEX IF NOT EXISTS idx_lineage_run ON chunk_lineage(run_id);
+
 -- ============================================================================
 -- LAYER 4 : Features (caractéristiques extraites)
 -- ============================================================================
@@ -204,6 +286,19 @@ CREATE TABLE IF NOT EXISTS run_history (
 CREATE INDEX IF NOT EXISTS idx_runs_workflow ON run_history(workflow_id);
 CREATE INDEX IF NOT EXISTS idx_runs_status ON run_history(status);
 
+-- Exécution de chaque étape (copie de _step_executions au merge)
+CREATE TABLE IF NOT EXISTS step_history (
+    run_id TEXT NOT NULL REFERENCES run_history(run_id) ON DELETE CASCADE,
+    step_order INTEGER NOT NULL,
+    step_name TEXT NOT NULL,
+    duration_ms INTEGER,
+    rows_in INTEGER,
+    rows_out INTEGER,
+    delta_score REAL,
+    notes TEXT,                             -- JSON (stratégie utilisée, avertissements)
+    PRIMARY KEY (run_id, step_order)
+);
+
 -- ============================================================================
 -- Versioning des extracteurs (reproductibilité)
 -- ============================================================================
@@ -276,4 +371,85 @@ INSERT OR IGNORE INTO config (key, value, description) VALUES
     ('vector_dimensions', '256', 'Default vector dimensions'),
     ('blend_weights_structure', '0.45', 'Weight for structure layer'),
     ('blend_weights_lexical', '0.30', 'Weight for lexical layer'),
-    ('blend_weights_contextual', '0.25', 'Weight for contextual layer');
+    ('blend_weights_contextual', '0.25', 'Weight for contextual layer'),
+    ('retry_max_attempts', '5', 'Failures before a file is marked failed'),
+    ('retry_base_delay_seconds', '30', 'Backoff after the first failure, doubled at each attempt'),
+    ('retry_max_delay_seconds', '3600', 'Maximum backoff between attempts'),
+    ('storage_compression', 'none', 'gzip to compress stored files of compressible MIME types, none to store them as is'),
+    ('merge_policy', 'append', 'Merge policy of workflows without their own: append, replace-file or replace-if-newer-version');
+
+-- ============================================================================
+-- Routage MIME → workflow
+-- ============================================================================
+
+-- Un motif est un type MIME exact ou un glob (`text/x-*`). Le motif exact
+-- l'emporte, puis le glob le plus spécifique (le plus de caractères fixes).
+CREATE TABLE IF NOT EXISTS mime_routes (
+    pattern TEXT PRIMARY KEY,               -- 'text/x-go', 'text/x-*'
+    workflow_id TEXT NOT NULL,              -- workflow de workflows.db
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Routes par défaut ; les modifications faites via `raglite route set`
+-- survivent à `init`.
+INSERT OR IGNORE INTO mime_routes (pattern, workflow_id) VALUES
+    ('application/pdf', 'pdf_chunking_v1'),
+    ('application/vnd.openxmlformats-officedocument.wordprocessingml.document', 'docx_chunking_v1'),
+    ('application/msword', 'docx_chunking_v1'),
+    ('application/vnd.openxmlformats-officedocument.spreadsheetml.sheet', 'xlsx_chunking_v1'),
+    ('application/vnd.ms-excel', 'xlsx_chunking_v1'),
+    ('text/markdown', 'markdown_chunking_v1'),
+    ('text/html', 'html_chunking_v1'),
+    ('text/x-go', 'go_chunking_v1'),
+    ('text/x-python', 'python_chunking_v1'),
+    ('text/javascript', 'javascript_chunking_v1'),
+    ('text/typescript', 'typescript_chunking_v1'),
+    ('text/x-sh', 'bash_chunking_v1'),
+    ('text/x-sql', 'sql_chunking_v1'),
+    ('text/plain', 'text_chunking_v1'),
+    ('text/*', 'text_chunking_v1');
+
+-- ============================================================================
+-- File de traitement
+-- ============================================================================
+
+-- Priorité par défaut des fichiers d'une collection, quand l'ingestion n'en
+-- donne pas. Plus grand = plus urgent.
+CREATE TABLE IF NOT EXISTS collection_priorities (
+    collection TEXT PRIMARY KEY,
+    priority INTEGER NOT NULL,
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+-- Fichiers prêts à traiter avec leur priorité effective : celle du fichier,
+-- sinon celle de sa collection, sinon 0. L'orchestrateur sert la priorité la
+-- plus haute, à tour de rôle entre workflows, les plus anciens d'abord.
+CREATE VIEW IF NOT EXISTS processing_queue AS
+SELECT
+    r.id,
+    r.mime_type,
+    r.collection,
+    COALESCE(r.priority, cp.priority, 0) AS priority,
+    r.imported_at
+FROM raw_files r
+LEFT JOIN collection_priorities cp ON cp.collection = r.collection
+WHERE r.status = 'pending' AND r.superseded_by IS NULL AND r.deleted_at IS NULL
+    AND (r.next_attempt_at IS NULL OR r.next_attempt_at <= datetime('now'));
+
+-- ============================================================================
+-- Politiques de merge
+-- ============================================================================
+
+-- Ce que le merger fait de la sortie précédente des fichiers d'un run :
+--   append                   : garde l'existant, n'ajoute que les lignes nouvelles
+--   replace-file             : supprime tout ce que les runs précédents ont
+--                              produit pour ces fichiers, dans la transaction du merge
+--   replace-if-newer-version : idem, sauf si une version plus récente du même
+--                              workflow a produit l'existant (le run est alors ignoré)
+-- Sans ligne pour un workflow, la config merge_policy s'applique.
+CREATE TABLE IF NOT EXISTS merge_policies (
+    workflow_id TEXT PRIMARY KEY,           -- workflow de workflows.db
+    policy TEXT NOT NULL
+        CHECK (policy IN ('append', 'replace-file', 'replace-if-newer-version')),
+    updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
@@ -68,22 +68,13 @@ VALUES (
     'docx_chunking_v1',
     3,
     'parse_markdown_structure',
-    'filter',
+    'parse',
     'step_2_extracted',
-    'length(content) > 5',
+    NULL,
     'step_3_parsed',
     '{
-        "parse_rules": [
-            {"pattern": "^#{1}\\s+", "type": "heading", "level": 1},
-            {"pattern": "^#{2}\\s+", "type": "heading", "level": 2},
-            {"pattern": "^#{3}\\s+", "type": "heading", "level": 3},
-            {"pattern": "^#{4,6}\\s+", "type": "heading", "level": 4},
-            {"pattern": "^\\s*[-*+]\\s+", "type": "list_item"},
-            {"pattern": "^\\s*\\d+\\.\\s+", "type": "list_item"},
-            {"pattern": "^>\\s+", "type": "blockquote"},
-            {"pattern": "^```", "type": "code_block"}
-        ],
-        "preserve_hierarchy": true
+        "description": "Apply active parse_rules (markdown headings, lists, code), build the heading hierarchy",
+        "min_content_length": 5
     }',
     'continue'
 );
@@ -120,10 +111,7 @@ VALUES (
     NULL,
     'step_5_chunks',
     '{
-        "strategy": "semantic",
-        "max_tokens": 512,
-        "min_tokens": 30,
-        "overlap_tokens": 30,
+        "strategy_id": "docx_sections",
         "boundary_markers": ["heading"],
         "group_by": "section_path",
         "keep_section_context": true
@@ -201,11 +189,8 @@ VALUES (
     NULL,
     'step_9_structure_vec',
     '{
-        "layer": "structure",
-        "algorithm": "feature_hash",
-        "dimensions": 256,
-        "features": ["token_count", "heading_depth", "section_length", "list_density", "has_code", "formatting_density"],
-        "model_version": "structure_docx_v1"
+        "config_id": "docx_structure",
+        "features": ["token_count", "heading_depth", "section_length", "list_density", "has_code", "formatting_density"]
     }',
     'continue'
 );
@@ -222,13 +207,7 @@ VALUES (
     NULL,
     'step_10_lexical_vec',
     '{
-        "layer": "lexical",
-        "algorithm": "tfidf",
-        "dimensions": 256,
-        "min_df": 2,
-        "max_df": 0.85,
-        "ngram_range": [1, 2],
-        "model_version": "lexical_tfidf_v1"
+        "config_id": "docx_lexical"
     }',
     'continue'
 );
@@ -245,11 +224,7 @@ VALUES (
     NULL,
     'step_11_contextual_vec',
     '{
-        "layer": "contextual",
-        "algorithm": "graph_embed",
-        "dimensions": 256,
-        "relations": ["parent_of", "follows"],
-        "model_version": "contextual_graph_v1"
+        "config_id": "contextual_default"
     }',
     'continue'
 );
@@ -266,12 +241,9 @@ VALUES (
     NULL,
     'step_12_blend_vec',
     '{
-        "layer": "blend",
-        "algorithm": "blend",
-        "dimensions": 256,
+        "config_id": "docx_blend",
         "sources": ["step_9_structure_vec", "step_10_lexical_vec", "step_11_contextual_vec"],
-        "weights": {"structure": 0.35, "lexical": 0.35, "contextual": 0.30},
-        "model_version": "blend_docx_v1"
+        "weights": {"structure": 0.35, "lexical": 0.35, "contextual": 0.30}
     }',
     'continue'
 );
@@ -285,7 +257,7 @@ VALUES (
     'finalize_output',
     'project',
     'step_7_unique',
-    '*',
+    'id, file_id, unit_ids, content, token_count, chunk_type, overlap_prev, overlap_next, content_hash AS hash, position, parent_id',
     '_output',
     '{"description": "Copy final chunks to output table"}',
     'continue'
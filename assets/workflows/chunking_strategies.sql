@@ -0,0 +1,14 @@
+-- GoRAGlite v2 - Stratégies de chunking par défaut
+-- Référencées par les étapes window via "strategy_id".
+-- INSERT OR IGNORE : les réglages faits avec `raglite strategies set` survivent à un nouvel `init`.
+
+INSERT OR IGNORE INTO chunking_strategies
+(id, name, description, chunk_type, max_tokens, min_tokens, overlap_tokens, boundary_pattern, config, updated_at)
+VALUES
+    ('semantic_default', 'Semantic 512',
+     'Chunks sémantiques de 50 à 512 tokens, chevauchement de 50',
+     'semantic', 512, 50, 50, NULL, NULL, datetime('now')),
+
+    ('docx_sections', 'DOCX sections',
+     'Chunks alignés sur les sections DOCX, seuil minimal plus bas pour les sections courtes',
+     'semantic', 512, 30, 30, NULL, NULL, datetime('now'));
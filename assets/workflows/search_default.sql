@@ -1,6 +1,13 @@
 -- GoRAGlite v2 - Default Search Workflow
 -- Workflow: search_v1
 -- Recherche multi-layer avec cascade de filtres
+--
+-- Entrée : _query_input(query_text, fts_query), créée par le moteur à partir du
+-- paramètre query (fts_query : les termes de la query entre guillemets, joints par OR).
+-- Sortie : _results(chunk_id, score, layer_scores, snippet, file_id).
+-- Le score lexical vient de bm25 ; les scores structure et contextual comparent
+-- les vecteurs de chaque candidat à ceux du meilleur candidat lexical
+-- (pseudo-relevance feedback), la query n'ayant pas de vecteurs.
 
 -- ============================================================================
 -- Workflow Definition
@@ -10,130 +17,107 @@ INSERT OR REPLACE INTO workflows (id, name, version, description, input_schema,
 VALUES (
     'search_v1',
     'Multi-Layer Search Pipeline',
-    1,
+    2,
     'Recherche hybride: FTS + vecteurs multi-layer avec reranking par blend',
-    '{"params": {"query": "string", "top_k": "integer", "layers": "array"}}',
-    '{"tables": ["_output"], "columns": ["chunk_id", "score", "layer_scores", "snippet", "file_id"]}',
+    '{"tables": ["_query_input"], "params": {"query": "string", "profile": "string", "top_k": "integer", "min_score": "number", "layers": "array", "rerank": "boolean", "all_versions": "boolean", "collection": "string", "where": "object", "w_structure": "number", "w_lexical": "number", "w_contextual": "number"}}',
+    '{"tables": ["_results"], "columns": ["chunk_id", "score", "layer_scores", "snippet", "file_id"]}',
     'active'
 );
 
+-- Les étapes de la version 1 (tokenize, expand, enrich) ne doivent pas survivre
+DELETE FROM workflow_steps WHERE workflow_id = 'search_v1';
+
 -- ============================================================================
 -- Workflow Steps
 -- ============================================================================
 
--- Step 1: Tokenize - Extraire les tokens de la query
+-- Step 1: Query - Lire la query préparée par le moteur
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     1,
-    'tokenize_query',
+    'read_query',
     'project',
     '_query_input',
-    'query_text, tokenize(query_text) as tokens',
-    'step_1_tokens',
-    '{
-        "description": "Tokenize query into words",
-        "tokenizer": "simple",
-        "lowercase": true,
-        "remove_stopwords": true
-    }',
+    'query_text, fts_query',
+    'step_1_query',
+    '{"description": "Query text and its FTS5 expression"}',
     'fail'
 );
 
--- Step 2: Expand - Synonymes et stemming
+-- Step 2: FTS Filter - Premier filtre large via FTS
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     2,
-    'expand_query',
-    'project',
-    'step_1_tokens',
-    'query_text, tokens, expand_tokens(tokens) as expanded_tokens',
-    'step_2_expanded',
-    '{
-        "description": "Expand with synonyms and stems",
-        "enable_synonyms": true,
-        "enable_stemming": true,
-        "max_expansion": 3
-    }',
+    'fts_filter',
+    'filter',
+    'corpus.chunks',
+    '(tombstoned_at IS NULL OR :all_versions) AND (:collection = '''' OR collection = :collection) AND NOT EXISTS (SELECT 1 FROM json_each(:where) w WHERE json_extract(chunks.tags, ''$."'' || w.key || ''"'') IS NOT w.value) AND rowid IN (SELECT rowid FROM corpus.chunks_fts WHERE chunks_fts MATCH (SELECT fts_query FROM step_1_query))',
+    'step_2_fts_candidates',
+    '{"description": "Full-text search filter on the collection and where tags, tombstoned chunks excluded unless all_versions"}',
     'continue'
 );
 
--- Step 3: FTS Filter - Premier filtre large via FTS
+-- Step 3: BM25 - Score FTS brut de chaque candidat
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     3,
-    'fts_filter',
-    'filter',
-    'corpus.chunks',
-    'id IN (SELECT rowid FROM corpus.chunks_fts WHERE corpus.chunks_fts MATCH :expanded_tokens)',
-    'step_3_fts_candidates',
-    '{
-        "description": "Full-text search filter",
-        "max_candidates": 1000,
-        "bm25_weights": {"content": 1.0}
-    }',
+    'score_bm25',
+    'project',
+    'step_2_fts_candidates',
+    '*, -(SELECT bm25(chunks_fts) FROM corpus.chunks_fts WHERE chunks_fts MATCH (SELECT fts_query FROM step_1_query) AND chunks_fts.rowid = (SELECT c.rowid FROM corpus.chunks c WHERE c.id = step_2_fts_candidates.id)) AS bm25_score',
+    'step_3_bm25',
+    '{"description": "BM25 score of each candidate, higher is better"}',
     'continue'
 );
 
--- Step 4: Structure Score - Score basé sur features structurelles
+-- Step 4: Lexical Score - BM25 rapporté au meilleur candidat, sur [0, 1]
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     4,
-    'score_structure',
-    'join',
-    'step_3_fts_candidates',
-    'LEFT JOIN corpus.chunk_vectors cv ON step_3_fts_candidates.id = cv.chunk_id AND cv.layer = ''structure''',
-    'step_4_with_structure',
-    '{
-        "description": "Add structure layer scores",
-        "score_function": "cosine_similarity",
-        "query_vector_source": "query_structure_vec"
-    }',
+    'score_lexical',
+    'project',
+    'step_3_bm25',
+    '*, bm25_score / NULLIF(MAX(bm25_score) OVER (), 0) AS lexical_score',
+    'step_4_lexical',
+    '{"description": "BM25 relative to the best candidate"}',
     'continue'
 );
 
--- Step 5: Lexical Score - Score TF-IDF
+-- Step 5: Structure Score - Similarité des vecteurs structure au meilleur candidat lexical
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     5,
-    'score_lexical',
-    'join',
-    'step_4_with_structure',
-    'LEFT JOIN corpus.chunk_vectors cv2 ON step_4_with_structure.id = cv2.chunk_id AND cv2.layer = ''lexical''',
-    'step_5_with_lexical',
-    '{
-        "description": "Add lexical layer scores",
-        "score_function": "cosine_similarity",
-        "query_vector_source": "query_lexical_vec"
-    }',
+    'score_structure',
+    'project',
+    'step_4_lexical',
+    '*, (SELECT MAX(cosine_similarity(v.vector, ref.vector), 0) FROM corpus.chunk_vectors v JOIN corpus.chunk_vectors ref ON ref.layer = v.layer WHERE v.chunk_id = step_4_lexical.id AND v.layer = ''structure'' AND ref.chunk_id = (SELECT id FROM step_4_lexical ORDER BY lexical_score DESC, id LIMIT 1)) AS structure_score',
+    'step_5_structure',
+    '{"description": "Cosine similarity of structure vectors to the best lexical candidate"}',
     'continue'
 );
 
--- Step 6: Contextual Score - Score basé sur graphe
+-- Step 6: Contextual Score - Similarité des vecteurs contextual au meilleur candidat lexical
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     6,
     'score_contextual',
-    'join',
-    'step_5_with_lexical',
-    'LEFT JOIN corpus.chunk_vectors cv3 ON step_5_with_lexical.id = cv3.chunk_id AND cv3.layer = ''contextual''',
-    'step_6_with_contextual',
-    '{
-        "description": "Add contextual layer scores",
-        "score_function": "cosine_similarity",
-        "query_vector_source": "query_contextual_vec"
-    }',
+    'project',
+    'step_5_structure',
+    '*, (SELECT MAX(cosine_similarity(v.vector, ref.vector), 0) FROM corpus.chunk_vectors v JOIN corpus.chunk_vectors ref ON ref.layer = v.layer WHERE v.chunk_id = step_5_structure.id AND v.layer = ''contextual'' AND ref.chunk_id = (SELECT id FROM step_5_structure ORDER BY lexical_score DESC, id LIMIT 1)) AS contextual_score',
+    'step_6_contextual',
+    '{"description": "Cosine similarity of contextual vectors to the best lexical candidate"}',
     'continue'
 );
 
@@ -145,7 +129,7 @@ VALUES (
     7,
     'blend_scores',
     'project',
-    'step_6_with_contextual',
+    'step_6_contextual',
     '*, (COALESCE(structure_score, 0) * :w_structure + COALESCE(lexical_score, 0) * :w_lexical + COALESCE(contextual_score, 0) * :w_contextual) as blend_score',
     'step_7_blended',
     '{
@@ -154,8 +138,7 @@ VALUES (
             "structure": 0.45,
             "lexical": 0.30,
             "contextual": 0.25
-        },
-        "dynamic_weights": true
+        }
     }',
     'continue'
 );
@@ -169,7 +152,7 @@ VALUES (
     'top_k_filter',
     'filter',
     'step_7_blended',
-    'blend_score >= :min_score ORDER BY blend_score DESC LIMIT :top_k',
+    'blend_score >= :min_score ORDER BY blend_score DESC, id LIMIT :top_k',
     'step_8_top_k',
     '{
         "description": "Keep top K results",
@@ -179,45 +162,29 @@ VALUES (
     'continue'
 );
 
--- Step 9: Enrich - Ajouter contexte (fichier source, snippet)
+-- Step 9: Finalize - Format output
 INSERT OR REPLACE INTO workflow_steps
 (workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
 VALUES (
     'search_v1',
     9,
-    'enrich_results',
-    'join',
-    'step_8_top_k',
-    'JOIN corpus.chunks c ON step_8_top_k.id = c.id JOIN corpus.raw_files rf ON c.file_id = rf.id',
-    'step_9_enriched',
-    '{
-        "description": "Add file metadata and snippets",
-        "snippet_length": 200,
-        "highlight_matches": true
-    }',
-    'continue'
-);
-
--- Step 10: Finalize - Format output
-INSERT OR REPLACE INTO workflow_steps
-(workflow_id, step_order, step_name, operation, source, predicate, output, config, on_empty)
-VALUES (
-    'search_v1',
-    10,
     'finalize_output',
     'project',
-    'step_9_enriched',
-    'id as chunk_id, blend_score as score, json_object(''structure'', structure_score, ''lexical'', lexical_score, ''contextual'', contextual_score) as layer_scores, substr(content, 1, 200) as snippet, file_id, source_path',
-    '_output',
+    'step_8_top_k',
+    'id as chunk_id, blend_score as score, json_object(''structure'', structure_score, ''lexical'', lexical_score, ''contextual'', contextual_score) as layer_scores, substr(content, 1, 200) as snippet, file_id',
+    '_results',
     '{"description": "Format final output"}',
     'continue'
 );
 
 -- ============================================================================
--- Search Configs
+-- Search Configs (profils sélectionnés par `raglite search --profile`)
+-- Les layers et poids deviennent les paramètres :w_structure, :w_lexical,
+-- :w_contextual de l'étape 7 ; top_k et min_score ceux de l'étape 8.
+-- INSERT OR IGNORE : les réglages faits avec `raglite profiles set` survivent à un nouvel `init`.
 -- ============================================================================
 
-INSERT OR REPLACE INTO search_configs (id, name, description, layers, layer_weights, top_k, min_score, rerank_enabled)
+INSERT OR IGNORE INTO search_configs (id, name, description, layers, layer_weights, top_k, min_score, rerank_enabled)
 VALUES
     ('default', 'Default Search', 'Balanced multi-layer search',
      '["structure", "lexical", "contextual"]',
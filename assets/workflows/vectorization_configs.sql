@@ -0,0 +1,59 @@
+-- GoRAGlite v2 - Configurations de vectorisation par défaut
+-- Référencées par les étapes vectorize via "config_id".
+-- Les valeurs de la table priment sur celles de l'étape, sauf features et
+-- weights laissés à NULL : chaque workflow garde alors les siens.
+-- INSERT OR IGNORE : les réglages faits avec `raglite vectors configure` survivent à un nouvel `init`.
+
+INSERT OR IGNORE INTO vectorization_configs
+(id, name, description, layer, dimensions, algorithm, features, weights, config, model_version, updated_at)
+VALUES
+    ('structure_default', 'Structure 256',
+     'Hachage des features structurelles (longueurs, ratios, marqueurs de code)',
+     'structure', 256, 'feature_hash', NULL, NULL, NULL, 'structure_v1', datetime('now')),
+
+    ('lexical_default', 'Lexical TF-IDF 256',
+     'TF-IDF sur unigrammes et bigrammes',
+     'lexical', 256, 'tfidf', NULL, NULL,
+     '{"min_df": 2, "max_df": 0.8, "ngram_range": [1, 2]}', 'lexical_tfidf_v1', datetime('now')),
+
+    ('contextual_default', 'Contextual graph 256',
+     'Embedding du graphe de relations entre chunks',
+     'contextual', 256, 'graph_embed', NULL, NULL,
+     '{"relations": ["parent_of", "follows"]}', 'contextual_graph_v1', datetime('now')),
+
+    ('blend_default', 'Blend 256',
+     'Combinaison pondérée des layers sources',
+     'blend', 256, 'blend', NULL, NULL, NULL, 'blend_v1', datetime('now'));
+
+-- Une configuration par jeu de features : chaque workflow garde sa propre
+-- model_version (celle de ses vecteurs avant les configurations partagées).
+INSERT OR IGNORE INTO vectorization_configs
+(id, name, description, layer, dimensions, algorithm, features, weights, config, model_version, updated_at)
+VALUES
+    ('go_structure', 'Go structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'go_structure_v1', datetime('now')),
+    ('go_lexical', 'Go lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, '{"ngram_range": [1, 2]}', 'go_lexical_v1', datetime('now')),
+    ('go_blend', 'Go blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'go_blend_v1', datetime('now')),
+    ('py_structure', 'Python structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'py_structure_v1', datetime('now')),
+    ('py_lexical', 'Python lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'py_lexical_v1', datetime('now')),
+    ('py_blend', 'Python blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'py_blend_v1', datetime('now')),
+    ('js_structure', 'JavaScript structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'js_structure_v1', datetime('now')),
+    ('js_lexical', 'JavaScript lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'js_lexical_v1', datetime('now')),
+    ('js_blend', 'JavaScript blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'js_blend_v1', datetime('now')),
+    ('ts_structure', 'TypeScript structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'ts_structure_v1', datetime('now')),
+    ('ts_lexical', 'TypeScript lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'ts_lexical_v1', datetime('now')),
+    ('ts_blend', 'TypeScript blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'ts_blend_v1', datetime('now')),
+    ('bash_structure', 'Bash structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'bash_structure_v1', datetime('now')),
+    ('bash_lexical', 'Bash lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'bash_lexical_v1', datetime('now')),
+    ('sql_structure', 'SQL structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'sql_structure_v1', datetime('now')),
+    ('sql_lexical', 'SQL lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'sql_lexical_v1', datetime('now')),
+    ('html_structure', 'HTML structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'html_structure_v1', datetime('now')),
+    ('html_lexical', 'HTML lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'html_lexical_v1', datetime('now')),
+    ('md_structure', 'Markdown structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'md_structure_v1', datetime('now')),
+    ('md_lexical', 'Markdown lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'md_lexical_v1', datetime('now')),
+    ('md_blend', 'Markdown blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'md_blend_v1', datetime('now')),
+    ('text_lexical', 'Text lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL, NULL, 'text_lexical_v1', datetime('now')),
+
+    ('docx_structure', 'DOCX structure 256', NULL, 'structure', 256, 'feature_hash', NULL, NULL, NULL, 'structure_docx_v1', datetime('now')),
+    ('docx_lexical', 'DOCX lexical TF-IDF 256', NULL, 'lexical', 256, 'tfidf', NULL, NULL,
+     '{"min_df": 2, "max_df": 0.85, "ngram_range": [1, 2]}', 'lexical_tfidf_v1', datetime('now')),
+    ('docx_blend', 'DOCX blend 256', NULL, 'blend', 256, 'blend', NULL, NULL, NULL, 'blend_docx_v1', datetime('now'));
@@ -4,12 +4,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -54,6 +59,12 @@ func main() {
 		err = cmdIngest(ctx, *dataDir, args)
 	case "process":
 		err = cmdProcess(ctx, *dataDir)
+	case "merge":
+		err = cmdMerge(ctx, *dataDir, args)
+	case "daemon":
+		err = cmdDaemon(ctx, *dataDir, args)
+	case "watch":
+		err = cmdWatch(ctx, *dataDir, args)
 	case "search":
 		err = cmdSearch(ctx, *dataDir, args)
 	case "status":
@@ -67,7 +78,33 @@ func main() {
 	case "export":
 		err = cmdExport(ctx, *dataDir, args)
 	case "workflows":
-		err = cmdWorkflows(ctx, *dataDir)
+		err = cmdWorkflows(ctx, *dataDir, args)
+	case "trace":
+		err = cmdTrace(ctx, *dataDir, args)
+	case "history":
+		err = cmdHistory(ctx, *dataDir, args)
+	case "files":
+		err = cmdFiles(ctx, *dataDir, args)
+	case "retry":
+		err = cmdRetry(ctx, *dataDir, args)
+	case "rechunk":
+		err = cmdRechunk(ctx, *dataDir, args)
+	case "rm":
+		err = cmdRm(ctx, *dataDir, args)
+	case "fsck":
+		err = cmdFsck(ctx, *dataDir, args)
+	case "storage":
+		err = cmdStorage(ctx, *dataDir, args)
+	case "strategies":
+		err = cmdStrategies(ctx, *dataDir, args)
+	case "vectors":
+		err = cmdVectors(ctx, *dataDir, args)
+	case "profiles":
+		err = cmdProfiles(ctx, *dataDir, args)
+	case "route":
+		err = cmdRoute(ctx, *dataDir, args)
+	case "priority":
+		err = cmdPriority(ctx, *dataDir, args)
 	case "version":
 		fmt.Printf("GoRAGlite v%s\n", version)
 	case "help", "--help", "-h":
@@ -85,22 +122,38 @@ func main() {
 }
 
 func printUsage() {
-	fmt.Println(`GoRAGlite v` + version + ` - SQLite-powered RAG system
+	fmt.Print(`GoRAGlite v` + version + ` - SQLite-powered RAG system
 
 Usage:
   raglite <command> [options] [arguments]
 
 Commands:
   init                Initialize data directory
-  ingest <path>       Import files into corpus
-  process             Process pending files
+  ingest <path>       Import files and archives (zip, tar, tar.gz) into corpus
+  process             Process pending files and merge the queued runs
+  merge               Merge queued runs into the corpus, or set merge policies
+  daemon              Process and merge continuously until SIGTERM
+  watch <dir>...      Ingest new and changed files, tombstone deleted ones
   search <query>      Search the corpus
   status              Show system status
   run <workflow>      Run a specific workflow
   inspect <run_id>    Inspect a run
   gc                  Garbage collect old runs
   export <format>     Export corpus data
-  workflows           List available workflows
+  workflows           List available workflows (--metrics for run statistics)
+  trace <chunk_id>    Show how a chunk was derived
+  history <path>      Show the versions of a document
+  files               List ingested files (--failed for failures and reasons)
+  retry <file_id>...  Retry failed files
+  rechunk <file>...   Chunk files again from their stored segments
+  rm <file|path>...   Purge files and everything derived from them
+  fsck                Check storage and derived data (--repair to fix)
+  storage             Show storage usage, set or migrate to gzip compression
+  strategies          List, add or tune chunking strategies
+  vectors             List or configure vectorization configs
+  profiles            List, add or tune search profiles
+  route               List or set the mime type to workflow routes
+  priority            Show the processing queue, set collection or file priorities
   version             Show version
   help                Show this help
 
@@ -111,10 +164,17 @@ Examples:
   raglite init
   raglite ingest ./documents/
   raglite ingest ./code.go
+  raglite ingest --exclude "*.min.js,dist" --max-size 20MB ./project/
+  raglite watch ./documents/
   raglite process
   raglite search "how to handle errors"
+  raglite search --profile code "open file"
+  raglite ingest --collection legal --tag year=2025 ./contracts/
+  raglite search --collection legal --where year=2025 "termination clause"
+  raglite history ./documents/report.pdf
   raglite status
   raglite workflows
+  raglite route set "text/x-*" text_chunking_v1
   raglite run pdf_chunking_v1
 `)
 }
@@ -173,10 +233,41 @@ func cmdInit(ctx context.Context, dataDir string) error {
 }
 
 func cmdIngest(ctx context.Context, dataDir string, args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: raglite ingest <path> [path...]")
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	include := fs.String("include", "", "Only ingest files matching these globs (comma list)")
+	exclude := fs.String("exclude", "", "Skip files and directories matching these globs (comma list)")
+	maxSize := fs.String("max-size", "", "Skip files larger than this (e.g. 512KB, 20MB)")
+	collection := fs.String("collection", "", "Collection of the ingested files")
+	priority := fs.Int("priority", 0, "Processing priority, higher first (default: the collection's)")
+	tags := tagFlag{}
+	fs.Var(tags, "tag", "Metadata key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: raglite ingest [--collection <name>] [--tag k=v]... [--priority <n>] [--include <globs>] [--exclude <globs>] [--max-size <size>] <path> [path...]")
+	}
+
+	opts := orchestrator.DirOptions{
+		Recursive: true,
+		Include:   splitList(*include),
+		Exclude:   splitList(*exclude),
+	}
+	if *maxSize != "" {
+		size, err := parseSize(*maxSize)
+		if err != nil {
+			return err
+		}
+		opts.MaxSize = size
 	}
 
+	labels := orchestrator.IngestOptions{Collection: *collection, Tags: tags}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "priority" {
+			labels.Priority = priority
+		}
+	})
+
 	corpusDB, err := db.OpenCorpus(dataDir)
 	if err != nil {
 		return err
@@ -189,19 +280,12 @@ func cmdIngest(ctx context.Context, dataDir string, args []string) error {
 	}
 	defer workflowsDB.Close()
 
-	runsDir := filepath.Join(dataDir, "runs")
-	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
 	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
 
-	// Register extractors
-	registry := extract.NewRegistry()
-	registry.Register(extract.NewPDFExtractor())
-	registry.Register(extract.NewDOCXExtractor())
-	registry.Register(extract.NewXLSXExtractor())
-	registry.Register(extract.NewCodeExtractor())
-
 	var totalIngested int
-	for _, path := range args {
+	skipped := make(map[string]int)
+	for _, path := range fs.Args() {
 		info, err := os.Stat(path)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Warning: cannot access %s: %v\n", path, err)
@@ -209,14 +293,24 @@ func cmdIngest(ctx context.Context, dataDir string, args []string) error {
 		}
 
 		if info.IsDir() {
-			ids, err := orch.IngestDir(ctx, path, true)
+			report, err := orch.IngestTree(ctx, path, opts, labels)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: error ingesting %s: %v\n", path, err)
 			}
-			totalIngested += len(ids)
-			fmt.Printf("Ingested %d files from %s\n", len(ids), path)
+			totalIngested += len(report.IDs)
+			for reason, n := range report.Skipped {
+				skipped[reason] += n
+			}
+			fmt.Printf("Ingested %d files from %s\n", len(report.IDs), path)
 		} else {
-			id, err := orch.Ingest(ctx, path)
+			// Files named explicitly bypass ignore files and globs
+			if opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+				skipped[orchestrator.SkipTooLarge]++
+				continue
+			}
+			fileOpts := labels
+			fileOpts.Filter, fileOpts.Skipped = opts, skipped
+			id, err := orch.IngestFile(ctx, path, fileOpts)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Warning: error ingesting %s: %v\n", path, err)
 				continue
@@ -227,10 +321,50 @@ func cmdIngest(ctx context.Context, dataDir string, args []string) error {
 	}
 
 	fmt.Printf("Total: %d files ingested\n", totalIngested)
+	if len(skipped) > 0 {
+		reasons := make([]string, 0, len(skipped))
+		for reason := range skipped {
+			reasons = append(reasons, reason)
+		}
+		slices.Sort(reasons)
+		fmt.Println("Skipped:")
+		for _, reason := range reasons {
+			fmt.Printf("  %-14s %d\n", reason, skipped[reason])
+		}
+	}
 	return nil
 }
 
+// parseSize parses a byte size with an optional B, KB, MB or GB suffix.
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	s := strings.ToUpper(strings.TrimSpace(value))
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * float64(factor)), nil
+}
+
 func cmdProcess(ctx context.Context, dataDir string) error {
+	// The daemon processes and merges pending files itself while it is up
+	unlock, err := orchestrator.LockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	corpusDB, err := db.OpenCorpus(dataDir)
 	if err != nil {
 		return err
@@ -243,47 +377,450 @@ func cmdProcess(ctx context.Context, dataDir string) error {
 	}
 	defer workflowsDB.Close()
 
-	runsDir := filepath.Join(dataDir, "runs")
-	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
 	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
 
+	orch.SetEventHandler(printEvent)
+
 	fmt.Println("Processing pending files...")
 
 	if err := orch.ProcessPending(ctx); err != nil {
 		return err
 	}
 
-	// Start merger to integrate results
-	mergerCfg := merger.DefaultConfig(dataDir)
-	m, err := merger.New(corpusDB, mergerCfg)
+	// Merge the queued runs, those of earlier invocations included
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+	results, err := m.Drain(ctx, 0)
+	if len(results) > 0 {
+		merged, failed := countMerged(results)
+		fmt.Printf("Merged %d queued runs, %d failed:\n", merged, failed)
+		printMergeResults(results)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Processing complete!")
+	return nil
+}
+
+func cmdMerge(ctx context.Context, dataDir string, args []string) error {
+	if len(args) > 0 && args[0] == "policy" {
+		return cmdMergePolicy(ctx, dataDir, args[1:])
+	}
+
+	cfg := merger.DefaultConfig(dataDir)
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	once := fs.Bool("once", false, "Merge the queued runs and exit (default)")
+	follow := fs.Bool("follow", false, "Keep merging runs as they are queued until interrupted")
+	fs.DurationVar(&cfg.Interval, "interval", cfg.Interval, "Interval between queue scans with --follow")
+	asJSON := fs.Bool("json", false, "Output one JSON object per run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || *once && *follow {
+		return fmt.Errorf("usage: raglite merge [--once | --follow [--interval 1s]] [--json] | policy ...")
+	}
+
+	// Runs are merged by the daemon while it is up
+	unlock, err := orchestrator.LockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	m, err := merger.New(corpusDB, cfg)
 	if err != nil {
 		return err
 	}
 
-	// Process any pending runs
-	status, _ := m.Status()
-	if status.PendingCount > 0 {
-		fmt.Printf("Merging %d pending runs...\n", status.PendingCount)
-		// Process all pending
-		for i := 0; i < status.PendingCount; i++ {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
+	enc := json.NewEncoder(os.Stdout)
+	var merged, failed int
+	report := func(results []*merger.MergeResult) error {
+		m, f := countMerged(results)
+		merged, failed = merged+m, failed+f
+		if *asJSON {
+			for _, r := range results {
+				if err := enc.Encode(r); err != nil {
+					return err
+				}
 			}
+			return nil
 		}
+		printMergeResults(results)
+		return nil
 	}
 
-	fmt.Println("Processing complete!")
+	if !*follow {
+		results, err := m.Drain(ctx, 0)
+		if err := report(results); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if !*asJSON {
+			fmt.Printf("Merged %d runs, %d failed\n", merged, failed)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d runs failed to merge (see queue/failed and queue/quarantine)", failed)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		results, err := m.Drain(ctx, 0)
+		if err := report(results); err != nil {
+			return err
+		}
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			if !*asJSON {
+				fmt.Printf("Merged %d runs, %d failed\n", merged, failed)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func cmdMergePolicy(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite merge policy list | set <workflow_id|default> <append|replace-file|replace-if-newer-version> | rm <workflow_id>")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		policies, err := m.ListPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-32s %s\n", "WORKFLOW", "POLICY")
+		fmt.Printf("%-32s %s\n", "(default)", m.DefaultPolicy(ctx))
+		for _, p := range policies {
+			fmt.Printf("%-32s %s\n", p.WorkflowID, p.Policy)
+		}
+		return nil
+
+	case "set":
+		if len(args) != 3 {
+			return usage
+		}
+		if args[1] == "default" {
+			if err := m.SetDefaultPolicy(ctx, args[2]); err != nil {
+				return err
+			}
+			fmt.Printf("Default merge policy is %s\n", args[2])
+			return nil
+		}
+
+		workflowsDB, err := db.OpenWorkflows(dataDir)
+		if err != nil {
+			return err
+		}
+		defer workflowsDB.Close()
+		workflows, err := workflow.NewLoader(workflowsDB).ListWorkflows(ctx)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(workflows, func(wf workflow.Workflow) bool { return wf.ID == args[1] }) {
+			return fmt.Errorf("workflow %s %w", args[1], workflow.ErrNotFound)
+		}
+
+		if err := m.SetPolicy(ctx, args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Runs of %s are merged with policy %s\n", args[1], args[2])
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := m.DeletePolicy(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Runs of %s are merged with the default policy\n", args[1])
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// countMerged returns the number of runs merged and failed.
+func countMerged(results []*merger.MergeResult) (merged, failed int) {
+	for _, r := range results {
+		switch r.Status {
+		case merger.MergeFailed, merger.MergeQuarantined:
+			failed++
+		case merger.MergeRunFailed:
+			// Recorded, the worker reported the failure of the run
+		default:
+			merged++
+		}
+	}
+	return merged, failed
+}
+
+// printMergeResults prints one line per merged run.
+func printMergeResults(results []*merger.MergeResult) {
+	for _, r := range results {
+		runID := r.Run
+		if r.RunID != "" {
+			runID = r.RunID[:min(8, len(r.RunID))]
+		}
+		fmt.Printf("  %-9s %-8s %-24s %6d chunks %8v", r.Status, runID, r.WorkflowID, r.ChunksInserted,
+			r.Duration.Round(time.Millisecond))
+		if r.ChunksReplaced > 0 {
+			fmt.Printf("  (%d replaced)", r.ChunksReplaced)
+		}
+		if r.Error != "" {
+			fmt.Printf("  %s", r.Error)
+		}
+		if r.Report != "" {
+			fmt.Printf("\n            report: %s", r.Report)
+		}
+		fmt.Println()
+	}
+}
+
+func cmdDaemon(ctx context.Context, dataDir string, args []string) error {
+	cfg := orchestrator.DefaultConfig(dataDir)
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	fs.IntVar(&cfg.MaxWorkers, "workers", cfg.MaxWorkers, "Concurrent workflow runs")
+	fs.DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "Interval between scans for pending files")
+	watchDirs := fs.String("watch", "", "Directories to watch (comma list)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	unlock, err := orchestrator.LockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, cfg)
+	orch.SetEventHandler(logEvent)
+
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("daemon started (pid %d, %d workers, poll every %v)", os.Getpid(), cfg.MaxWorkers, cfg.PollInterval)
+
+	// Runs queued at shutdown are merged by the next start
+	mergerDone := make(chan error, 1)
+	go func() { mergerDone <- m.Start(ctx) }()
+
+	if roots := splitList(*watchDirs); len(roots) > 0 {
+		log.Printf("watching %s", strings.Join(roots, ", "))
+		go orch.Watch(ctx, orchestrator.DefaultWatchConfig(roots...), func(r *orchestrator.WatchReport) {
+			logWatchReport(r)
+			if r.Deleted > 0 || r.Modified > 0 {
+				m.RequestTombstones()
+			}
+		})
+	}
+
+	orch.Start(ctx)
+	log.Printf("shutting down: in-flight runs finished")
+
+	if err := <-mergerDone; err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	log.Printf("daemon stopped")
 	return nil
 }
 
+func cmdWatch(ctx context.Context, dataDir string, args []string) error {
+	cfg := orchestrator.DefaultWatchConfig()
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.DurationVar(&cfg.Interval, "interval", cfg.Interval, "Interval between scans")
+	fs.DurationVar(&cfg.Debounce, "debounce", cfg.Debounce, "Minimum age of a change before it is ingested")
+	once := fs.Bool("once", false, "Scan once and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: raglite watch [-interval 5s] [-debounce 2s] [-once] <dir>...")
+	}
+	cfg.Roots = fs.Args()
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+
+	// Chunks of deleted files are tombstoned right away, not at the next
+	// merge. While the daemon holds the data directory, its merger applies
+	// them with its next merge.
+	onScan := func(r *orchestrator.WatchReport) {
+		logWatchReport(r)
+		if r.Deleted == 0 && r.Modified == 0 {
+			return
+		}
+		unlock, err := orchestrator.LockDataDir(dataDir)
+		if err != nil {
+			log.Printf("tombstones left to the merger: %v", err)
+			return
+		}
+		defer unlock()
+		if _, err := m.ApplyTombstones(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("apply tombstones: %v", err)
+		}
+	}
+
+	if *once {
+		report, err := orch.ScanWatched(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		onScan(report)
+		fmt.Printf("%d added, %d modified, %d deleted, %d unchanged, %d deferred\n",
+			report.Added, report.Modified, report.Deleted, report.Unchanged, report.Deferred)
+		return nil
+	}
+
+	log.Printf("watching %s (every %v)", strings.Join(cfg.Roots, ", "), cfg.Interval)
+	return orch.Watch(ctx, cfg, onScan)
+}
+
+// logWatchReport logs the scans that changed something.
+func logWatchReport(r *orchestrator.WatchReport) {
+	if !r.Changed() && r.Errors == 0 {
+		return
+	}
+	log.Printf("watch: %d added, %d modified, %d deleted, %d deferred, %d errors",
+		r.Added, r.Modified, r.Deleted, r.Deferred, r.Errors)
+}
+
+// logEvent logs run outcomes from the daemon, one line per event.
+// Step and batch progress are left out: concurrent runs would interleave.
+func logEvent(ev workflow.Event) {
+	switch ev.Type {
+	case workflow.EventRunStarted:
+		log.Printf("[%s] run %s started on %s", ev.WorkflowID, ev.RunID[:8], ev.WorkerID)
+	case workflow.EventError:
+		log.Printf("[%s] run %s error in %s: %s", ev.WorkflowID, ev.RunID[:8], ev.StepName, ev.Error)
+	case workflow.EventRunFinished:
+		log.Printf("[%s] run %s %s in %v", ev.WorkflowID, ev.RunID[:8], ev.Status, ev.Duration.Round(time.Millisecond))
+	}
+}
+
+// newEngine creates the workflow engine of a data directory, with the
+// built-in extractors registered for external steps.
+func newEngine(corpusDB, workflowsDB *db.DB, dataDir string) *workflow.Engine {
+	engine := workflow.NewEngine(corpusDB, workflowsDB, filepath.Join(dataDir, "runs"))
+	extract.NewDefaultRegistry().RegisterAll(engine)
+	return engine
+}
+
+// eventMu serializes printEvent: workers of the pool emit events concurrently.
+var eventMu sync.Mutex
+
+// printEvent renders run progress events on stdout.
+// Batch progress rewrites the current line; everything else gets its own line.
+func printEvent(ev workflow.Event) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+
+	switch ev.Type {
+	case workflow.EventRunStarted:
+		fmt.Printf("[%s] run %s started (%d steps)\n", ev.WorkflowID, ev.RunID[:8], ev.TotalSteps)
+	case workflow.EventStepStarted:
+		fmt.Printf("[%s] %2d/%d %s...", ev.WorkflowID, ev.StepOrder, ev.TotalSteps, ev.StepName)
+	case workflow.EventBatchProgress:
+		if ev.Total > 0 {
+			fmt.Printf("\r[%s] %2d/%d %s... %d/%d rows (%d%%)", ev.WorkflowID, ev.StepOrder, ev.TotalSteps, ev.StepName, ev.Done, ev.Total, 100*ev.Done/ev.Total)
+		}
+	case workflow.EventStepFinished:
+		fmt.Printf("\r[%s] %2d/%d %-25s %6d -> %-6d rows %8v\n", ev.WorkflowID, ev.StepOrder, ev.TotalSteps, ev.StepName, ev.RowsIn, ev.RowsOut, ev.Duration.Round(time.Millisecond))
+	case workflow.EventError:
+		if ev.StepName != "" {
+			fmt.Fprintf(os.Stderr, "\n[%s] error in %s: %s\n", ev.WorkflowID, ev.StepName, ev.Error)
+		} else {
+			fmt.Fprintf(os.Stderr, "\n[%s] error: %s\n", ev.WorkflowID, ev.Error)
+		}
+	case workflow.EventRunFinished:
+		fmt.Printf("[%s] run %s %s in %v\n", ev.WorkflowID, ev.RunID[:8], ev.Status, ev.Duration.Round(time.Millisecond))
+	}
+}
+
 func cmdSearch(ctx context.Context, dataDir string, args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: raglite search <query>")
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	var opts orchestrator.SearchOptions
+	fs.StringVar(&opts.Profile, "profile", "default", "Search profile (see 'raglite profiles')")
+	fs.IntVar(&opts.TopK, "top-k", 0, "Number of results (default: profile top_k)")
+	fs.BoolVar(&opts.AllVersions, "all-versions", false, "Also search retired document versions")
+	fs.StringVar(&opts.Collection, "collection", "", "Only search files of this collection")
+	where := tagFlag{}
+	fs.Var(where, "where", "Only search files tagged key=value (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: raglite search [--profile <id>] [--top-k <n>] [--collection <name>] [--where k=v]... [--all-versions] <query>")
+	}
+	opts.Where = where
 
-	query := strings.Join(args, " ")
+	query := strings.Join(fs.Args(), " ")
 
 	corpusDB, err := db.OpenCorpus(dataDir)
 	if err != nil {
@@ -297,13 +834,12 @@ func cmdSearch(ctx context.Context, dataDir string, args []string) error {
 	}
 	defer workflowsDB.Close()
 
-	runsDir := filepath.Join(dataDir, "runs")
-	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
 	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
 
 	fmt.Printf("Searching for: %s\n\n", query)
 
-	results, err := orch.Search(ctx, query, 10)
+	results, err := orch.Search(ctx, query, opts)
 	if err != nil {
 		return err
 	}
@@ -342,8 +878,7 @@ func cmdStatus(ctx context.Context, dataDir string) error {
 	}
 	defer workflowsDB.Close()
 
-	runsDir := filepath.Join(dataDir, "runs")
-	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
 	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
 
 	status, err := orch.Status(ctx)
@@ -356,7 +891,11 @@ func cmdStatus(ctx context.Context, dataDir string) error {
 
 	fmt.Println("GoRAGlite Status")
 	fmt.Println("================")
-	fmt.Printf("Data Directory: %s\n\n", dataDir)
+	fmt.Printf("Data Directory: %s\n", dataDir)
+	if pid, ok := orchestrator.DaemonPID(dataDir); ok {
+		fmt.Printf("Daemon:         running (pid %d)\n", pid)
+	}
+	fmt.Println()
 
 	fmt.Println("Corpus:")
 	fmt.Printf("  Pending files:   %d\n", status.PendingFiles)
@@ -381,9 +920,10 @@ func cmdStatus(ctx context.Context, dataDir string) error {
 		mStatus, _ := m.Status()
 		if mStatus != nil {
 			fmt.Println("Merger Queue:")
-			fmt.Printf("  Pending: %d\n", mStatus.PendingCount)
-			fmt.Printf("  Done:    %d\n", mStatus.DoneCount)
-			fmt.Printf("  Failed:  %d\n", mStatus.FailedCount)
+			fmt.Printf("  Pending:     %d\n", mStatus.PendingCount)
+			fmt.Printf("  Done:        %d\n", mStatus.DoneCount)
+			fmt.Printf("  Failed:      %d\n", mStatus.FailedCount)
+			fmt.Printf("  Quarantined: %d\n", mStatus.QuarantinedCount)
 		}
 	}
 
@@ -409,13 +949,13 @@ func cmdRun(ctx context.Context, dataDir string, args []string) error {
 	}
 	defer workflowsDB.Close()
 
-	runsDir := filepath.Join(dataDir, "runs")
-	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
 
 	fmt.Printf("Running workflow: %s\n", workflowID)
 
 	cfg := workflow.RunConfig{
-		Debug: true,
+		Debug:   true,
+		OnEvent: printEvent,
 	}
 
 	run, err := engine.Run(ctx, workflowID, cfg)
@@ -585,7 +1125,13 @@ func cmdExport(ctx context.Context, dataDir string, args []string) error {
 	}
 }
 
-func cmdWorkflows(ctx context.Context, dataDir string) error {
+func cmdWorkflows(ctx context.Context, dataDir string, args []string) error {
+	fs := flag.NewFlagSet("workflows", flag.ContinueOnError)
+	showMetrics := fs.Bool("metrics", false, "Show run statistics and trends per workflow version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
 	workflowsDB, err := db.OpenWorkflows(dataDir)
 	if err != nil {
 		return err
@@ -593,6 +1139,9 @@ func cmdWorkflows(ctx context.Context, dataDir string) error {
 	defer workflowsDB.Close()
 
 	loader := workflow.NewLoader(workflowsDB)
+	if *showMetrics {
+		return printWorkflowMetrics(ctx, dataDir, loader)
+	}
 	workflows, err := loader.ListWorkflows(ctx)
 	if err != nil {
 		return err
@@ -620,3 +1169,1146 @@ func cmdWorkflows(ctx context.Context, dataDir string) error {
 
 	return nil
 }
+
+// printWorkflowMetrics refreshes and prints workflow metrics, comparing each
+// version with the previous one so regressions stand out.
+func printWorkflowMetrics(ctx context.Context, dataDir string, loader *workflow.Loader) error {
+	// Make sure corpus.db (run_history, step_history) exists before attaching it
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	corpusPath := corpusDB.Path()
+	corpusDB.Close()
+
+	if err := loader.RollupMetrics(ctx, corpusPath); err != nil {
+		return err
+	}
+
+	workflows, err := loader.ListWorkflows(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Workflow Metrics")
+	fmt.Println("================")
+
+	for _, w := range workflows {
+		versions, err := loader.GetMetrics(ctx, w.ID)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+
+		fmt.Printf("\n%s\n", w.ID)
+		for i, v := range versions {
+			fmt.Printf("  v%-3d runs %-5d success %5.1f%%  avg %-10v rows out %-8.0f",
+				v.Version, v.Runs, v.SuccessRate*100, v.AvgDuration.Round(time.Millisecond), v.AvgRowsOut)
+			if i+1 < len(versions) {
+				prev := versions[i+1]
+				fmt.Printf("  vs v%d: duration %s, rows %s", prev.Version,
+					formatRatio(float64(v.AvgDuration), float64(prev.AvgDuration)),
+					formatChange(v.AvgRowsOut, prev.AvgRowsOut))
+			}
+			fmt.Println()
+		}
+
+		// Per-step breakdown of the latest version against the previous one
+		latest := versions[0]
+		steps, err := loader.GetStepMetrics(ctx, w.ID, latest.Version)
+		if err != nil {
+			return err
+		}
+		var prevSteps map[int]workflow.StepMetrics
+		if len(versions) > 1 {
+			prev, err := loader.GetStepMetrics(ctx, w.ID, versions[1].Version)
+			if err != nil {
+				return err
+			}
+			prevSteps = make(map[int]workflow.StepMetrics, len(prev))
+			for _, s := range prev {
+				prevSteps[s.StepOrder] = s
+			}
+		}
+
+		if len(steps) > 0 {
+			fmt.Printf("  steps (v%d):\n", latest.Version)
+		}
+		for _, s := range steps {
+			fmt.Printf("    %2d %-28s %-10v %8.0f -> %-8.0f", s.StepOrder, s.StepName,
+				s.AvgDuration.Round(time.Millisecond), s.AvgRowsIn, s.AvgRowsOut)
+			if p, ok := prevSteps[s.StepOrder]; ok {
+				fmt.Printf("  duration %s, rows %s",
+					formatRatio(float64(s.AvgDuration), float64(p.AvgDuration)),
+					formatChange(s.AvgRowsOut, p.AvgRowsOut))
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// formatRatio renders cur relative to prev as a multiplier ("x3.1").
+func formatRatio(cur, prev float64) string {
+	if prev == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("x%.1f", cur/prev)
+}
+
+// formatChange renders cur relative to prev as a percentage ("-42%").
+func formatChange(cur, prev float64) string {
+	if prev == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.0f%%", (cur-prev)/prev*100)
+}
+
+func cmdTrace(ctx context.Context, dataDir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: raglite trace <chunk_id>")
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	trace, err := orch.Trace(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Chunk: %s\n", trace.Chunk.ID)
+	fmt.Printf("File:  %s (%s)\n", trace.File.SourcePath, trace.File.ID)
+	if pages := trace.Pages(); len(pages) > 0 {
+		list := make([]string, len(pages))
+		for i, p := range pages {
+			list[i] = strconv.Itoa(p)
+		}
+		fmt.Printf("Pages: %s\n", strings.Join(list, ", "))
+	}
+	for _, s := range trace.Segments {
+		fmt.Printf("       segment %s (%s, %s %s)", s.ID[:min(len(s.ID), 12)], s.SegmentType, s.Extractor, s.ExtractorVersion)
+		if s.Page != nil {
+			fmt.Printf(" page %d", *s.Page)
+		}
+		if s.BBox != "" {
+			fmt.Printf(" bbox %s", s.BBox)
+		}
+		fmt.Println()
+	}
+	if trace.RunID == "" {
+		fmt.Println("\nNo lineage recorded for this chunk.")
+		return nil
+	}
+	fmt.Printf("Run:   %s\n", trace.RunID)
+	if trace.RunDB == "" {
+		fmt.Println("       (run database no longer available, showing references only)")
+	}
+	fmt.Println()
+
+	for i, s := range trace.Steps {
+		fmt.Printf("%d. %s\n", i+1, s.Ref)
+		if s.Content != "" {
+			snippet := s.Content
+			if len(snippet) > 120 {
+				snippet = snippet[:120] + "..."
+			}
+			fmt.Printf("   %s\n", strings.ReplaceAll(snippet, "\n", " "))
+		}
+	}
+
+	return nil
+}
+
+func cmdFiles(ctx context.Context, dataDir string, args []string) error {
+	fs := flag.NewFlagSet("files", flag.ContinueOnError)
+	failed := fs.Bool("failed", false, "Only failed files, with their last error")
+	status := fs.String("status", "", "Only files with this status")
+	limit := fs.Int("limit", 50, "Maximum number of files (0 for all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *failed {
+		*status = "failed"
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	files, err := orch.ListFiles(ctx, *status, *limit)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No files.")
+		return nil
+	}
+
+	fmt.Printf("%-14s %-10s %8s  %s\n", "ID", "STATUS", "ATTEMPTS", "PATH")
+	for _, f := range files {
+		fmt.Printf("%-14s %-10s %8d  %s\n", f.ID[:12], f.Status, f.Attempts, f.SourcePath)
+		if f.LastError != "" {
+			fmt.Printf("%-14s %s\n", "", f.LastError)
+			if !f.NextAttemptAt.IsZero() && f.Status == "pending" {
+				fmt.Printf("%-14s next attempt %s\n", "", f.NextAttemptAt.Format("2006-01-02 15:04:05"))
+			}
+		}
+	}
+	return nil
+}
+
+func cmdRetry(ctx context.Context, dataDir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: raglite retry <file_id> [file_id...]")
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	for _, id := range args {
+		f, err := orch.Retry(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Queued %s (%s) for retry\n", f.ID[:12], f.SourcePath)
+	}
+	return nil
+}
+
+func cmdRechunk(ctx context.Context, dataDir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: raglite rechunk <file_id|path|document_id> [...]")
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	var fileIDs []string
+	for _, ref := range args {
+		ids, err := orch.ResolveFiles(ctx, ref)
+		if err != nil {
+			return err
+		}
+		fileIDs = append(fileIDs, ids...)
+	}
+
+	for _, id := range fileIDs {
+		f, segments, err := orch.Rechunk(ctx, id)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Queued %s (%s) for re-chunking from %d segments\n", f.ID[:12], f.SourcePath, segments)
+	}
+	return nil
+}
+
+func cmdRm(ctx context.Context, dataDir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: raglite rm <file_id|path|document_id> [...]")
+	}
+
+	// Runs merged meanwhile would bring back what is purged
+	unlock, err := orchestrator.LockDataDir(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	// Every reference is resolved before anything is deleted
+	var fileIDs []string
+	for _, ref := range args {
+		ids, err := orch.ResolveFiles(ctx, ref)
+		if err != nil {
+			return err
+		}
+		fileIDs = append(fileIDs, ids...)
+	}
+
+	// The merger is the sole writer of derived data
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+	result, err := m.Purge(ctx, fileIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range result.Files {
+		fmt.Printf("Purged %s\n", id[:12])
+	}
+	fmt.Printf("%d files, %d chunks, %d documents and %d storage objects removed\n",
+		len(result.Files), result.Chunks, result.Documents, result.Storage)
+	return nil
+}
+
+func cmdFsck(ctx context.Context, dataDir string, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "Relink or restore objects, quarantine bad ones, drop dangling rows, rebuild FTS")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Repairs rewrite objects and derived rows the daemon works on
+	if *repair {
+		unlock, err := orchestrator.LockDataDir(dataDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	storage, err := orch.CheckStorage(ctx, *repair)
+	if err != nil {
+		return err
+	}
+
+	// Derived rows are repaired by the merger, their sole writer
+	m, err := merger.New(corpusDB, merger.DefaultConfig(dataDir))
+	if err != nil {
+		return err
+	}
+	derived, err := m.CheckIntegrity(ctx, *repair)
+	if err != nil {
+		return err
+	}
+
+	left := int64(storage.Unrepaired())
+	if !derived.Repaired {
+		left += derived.Problems()
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{"storage": storage, "derived": derived}); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("Checked %d files, %d storage objects\n", storage.Files, storage.Objects)
+		for _, issue := range storage.Issues {
+			target := issue.Path
+			if issue.FileID != "" {
+				target = issue.FileID[:12] + " " + issue.Path
+			}
+			fmt.Printf("%-8s %s\n", issue.Kind, target)
+			if issue.Detail != "" {
+				fmt.Printf("%-8s %s\n", "", issue.Detail)
+			}
+			if issue.Repair != "" {
+				fmt.Printf("%-8s -> %s\n", "", issue.Repair)
+			}
+		}
+
+		tables := make([]string, 0, len(derived.Dangling))
+		for table := range derived.Dangling {
+			tables = append(tables, table)
+		}
+		slices.Sort(tables)
+		for _, table := range tables {
+			fmt.Printf("dangling %d rows in %s", derived.Dangling[table], table)
+			if derived.Repaired {
+				fmt.Print(" -> deleted")
+			}
+			fmt.Println()
+		}
+		if derived.FTSDrift {
+			fmt.Print("fts      index out of step with chunks")
+			if derived.Repaired {
+				fmt.Print(" -> rebuilt")
+			}
+			fmt.Println()
+		}
+
+		found := int64(len(storage.Issues)) + derived.Problems()
+		fmt.Printf("%d problems found, %d left\n", found, left)
+	}
+
+	if left > 0 {
+		return fmt.Errorf("fsck: %d problems left", left)
+	}
+	return nil
+}
+
+func cmdStorage(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite storage [usage] | compression <gzip|none> | compress")
+	if len(args) == 0 {
+		args = []string{"usage"}
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	switch args[0] {
+	case "usage":
+		stats, err := orch.StorageUsage(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Compression of new files: %s\n\n", orch.StorageCompression(ctx))
+		fmt.Printf("%-10s %7s %14s %14s\n", "ENCODING", "FILES", "SIZE", "STORED")
+		for _, s := range stats {
+			fmt.Printf("%-10s %7d %14d %14d\n", s.Encoding, s.Files, s.Size, s.Stored)
+		}
+		return nil
+
+	case "compression":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := orch.SetStorageCompression(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("New files are stored with compression %s\n", args[1])
+		return nil
+
+	case "compress":
+		// Objects move under the workers' feet otherwise
+		unlock, err := orchestrator.LockDataDir(dataDir)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := orch.SetStorageCompression(ctx, "gzip"); err != nil {
+			return err
+		}
+		report, err := orch.CompressStorage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range report.Errors {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", e)
+		}
+		fmt.Printf("Compressed %d files (%d -> %d bytes), %d kept as is\n",
+			report.Compressed, report.Before, report.After, report.Kept)
+		if len(report.Errors) > 0 {
+			return fmt.Errorf("%d files could not be compressed", len(report.Errors))
+		}
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+func cmdHistory(ctx context.Context, dataDir string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: raglite history <path|document_id>")
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	doc, err := orch.History(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Document: %s\n", doc.ID)
+	if doc.SourcePath != doc.ID {
+		fmt.Printf("Path:     %s\n", doc.SourcePath)
+	}
+	if doc.CurrentVersion > 0 {
+		fmt.Printf("Current:  v%d\n", doc.CurrentVersion)
+	} else {
+		fmt.Println("Current:  none live")
+	}
+	fmt.Println()
+
+	fmt.Printf("%-5s %-18s %-11s %10s %7s  %-19s  %s\n", "VER", "FILE", "STATUS", "SIZE", "CHUNKS", "INGESTED", "STATE")
+	for _, v := range doc.Versions {
+		state := "pending merge"
+		switch {
+		case !v.RetiredAt.IsZero():
+			state = "retired " + v.RetiredAt.Format("2006-01-02 15:04:05")
+		case v.Version == doc.CurrentVersion:
+			state = "current"
+		case !v.MergedAt.IsZero():
+			state = "merged " + v.MergedAt.Format("2006-01-02 15:04:05")
+		}
+		fileID := v.FileID
+		if len(fileID) > 16 {
+			fileID = fileID[:16]
+		}
+		fmt.Printf("v%-4d %-18s %-11s %10d %7d  %-19s  %s\n",
+			v.Version, fileID, v.Status, v.Size, v.Chunks,
+			v.IngestedAt.Format("2006-01-02 15:04:05"), state)
+	}
+
+	return nil
+}
+
+func cmdStrategies(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite strategies list | add <id> [flags] | set <id> key=value...")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	loader := workflow.NewLoader(workflowsDB)
+
+	switch args[0] {
+	case "list":
+		strategies, err := loader.ListStrategies(ctx)
+		if err != nil {
+			return err
+		}
+		if len(strategies) == 0 {
+			fmt.Println("No chunking strategies. Run 'raglite init' to load the defaults.")
+			return nil
+		}
+		fmt.Printf("%-20s %-4s %-13s %6s %6s %8s  %-6s %s\n", "ID", "VER", "TYPE", "MIN", "MAX", "OVERLAP", "ACTIVE", "NAME")
+		for _, st := range strategies {
+			fmt.Printf("%-20s v%-3d %-13s %6d %6d %8d  %-6t %s\n",
+				st.ID, st.Version, st.ChunkType, st.MinTokens, st.MaxTokens, st.OverlapTokens, st.Active, st.Name)
+		}
+		return nil
+
+	case "add":
+		if len(args) < 2 {
+			return usage
+		}
+		st := workflow.ChunkingStrategy{ID: args[1], Active: true}
+		fs := flag.NewFlagSet("strategies add", flag.ContinueOnError)
+		fs.StringVar(&st.Name, "name", "", "Display name (default: id)")
+		fs.StringVar(&st.Description, "description", "", "Description")
+		fs.StringVar(&st.ChunkType, "type", "semantic", "Chunk type: semantic, fixed_window, sentence, paragraph")
+		fs.IntVar(&st.MaxTokens, "max", 512, "Maximum tokens per chunk")
+		fs.IntVar(&st.MinTokens, "min", 50, "Minimum tokens per chunk")
+		fs.IntVar(&st.OverlapTokens, "overlap", 50, "Overlap tokens between chunks")
+		fs.StringVar(&st.BoundaryPattern, "boundary", "", "Boundary regex")
+		config := fs.String("config", "", "Extra window config (JSON)")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		if *config != "" {
+			if !json.Valid([]byte(*config)) {
+				return fmt.Errorf("config is not valid JSON")
+			}
+			st.Config = json.RawMessage(*config)
+		}
+		if err := loader.AddStrategy(ctx, &st); err != nil {
+			return err
+		}
+		fmt.Printf("Added strategy %s (v%d)\n", st.ID, st.Version)
+		return nil
+
+	case "set":
+		if len(args) < 3 {
+			return usage
+		}
+		st, err := loader.GetStrategy(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		for _, kv := range args[2:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", kv)
+			}
+			if err := setStrategyField(st, key, value); err != nil {
+				return err
+			}
+		}
+		if err := loader.UpdateStrategy(ctx, st); err != nil {
+			return err
+		}
+		fmt.Printf("Updated strategy %s (v%d)\n", st.ID, st.Version)
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// setStrategyField applies a key=value assignment from 'raglite strategies set'.
+func setStrategyField(st *workflow.ChunkingStrategy, key, value string) error {
+	atoi := func() (int, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("%s: expected an integer, got %q", key, value)
+		}
+		return n, nil
+	}
+
+	var err error
+	switch key {
+	case "name":
+		st.Name = value
+	case "description":
+		st.Description = value
+	case "type", "chunk_type":
+		st.ChunkType = value
+	case "max", "max_tokens":
+		st.MaxTokens, err = atoi()
+	case "min", "min_tokens":
+		st.MinTokens, err = atoi()
+	case "overlap", "overlap_tokens":
+		st.OverlapTokens, err = atoi()
+	case "boundary", "boundary_pattern":
+		st.BoundaryPattern = value
+	case "config":
+		if value != "" && !json.Valid([]byte(value)) {
+			return fmt.Errorf("config is not valid JSON")
+		}
+		st.Config = json.RawMessage(value)
+	case "active":
+		st.Active, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown strategy field %q", key)
+	}
+	return err
+}
+
+func cmdRoute(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite route list | set <mime|glob> <workflow_id> | rm <mime|glob>")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	switch args[0] {
+	case "list":
+		routes, err := orch.ListRoutes(ctx)
+		if err != nil {
+			return err
+		}
+		if len(routes) == 0 {
+			fmt.Println("No routes: pending files are not processed.")
+			return nil
+		}
+		fmt.Printf("%-72s %s\n", "MIME", "WORKFLOW")
+		for _, r := range routes {
+			fmt.Printf("%-72s %s\n", r.Pattern, r.WorkflowID)
+		}
+		return nil
+
+	case "set":
+		if len(args) != 3 {
+			return usage
+		}
+		if err := orch.SetRoute(ctx, args[1], args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Routed %s to %s\n", args[1], args[2])
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := orch.DeleteRoute(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Removed route %s\n", args[1])
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+func cmdPriority(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite priority list | set <collection> <n> | rm <collection> | file <file_id> <n>")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	corpusDB, err := db.OpenCorpus(dataDir)
+	if err != nil {
+		return err
+	}
+	defer corpusDB.Close()
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	engine := newEngine(corpusDB, workflowsDB, dataDir)
+	orch := orchestrator.New(corpusDB, workflowsDB, engine, orchestrator.DefaultConfig(dataDir))
+
+	switch args[0] {
+	case "list":
+		priorities, err := orch.ListCollectionPriorities(ctx)
+		if err != nil {
+			return err
+		}
+		if len(priorities) == 0 {
+			fmt.Println("No collection priorities: files default to 0.")
+		} else {
+			fmt.Printf("%-32s %8s\n", "COLLECTION", "PRIORITY")
+			for _, p := range priorities {
+				fmt.Printf("%-32s %8d\n", p.Collection, p.Priority)
+			}
+		}
+
+		queue, err := orch.Queue(ctx, 20)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nProcessing queue (next %d):\n", len(queue))
+		fmt.Printf("%-14s %8s %-20s %-32s %s\n", "FILE", "PRIORITY", "COLLECTION", "MIME", "IMPORTED")
+		for _, f := range queue {
+			fmt.Printf("%-14s %8d %-20s %-32s %s\n", f.ID[:12], f.Priority, f.Collection, f.MimeType,
+				f.ImportedAt.Format("2006-01-02 15:04:05"))
+		}
+		return nil
+
+	case "set":
+		if len(args) != 3 {
+			return usage
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", args[2])
+		}
+		if err := orch.SetCollectionPriority(ctx, args[1], n); err != nil {
+			return err
+		}
+		fmt.Printf("Collection %s has priority %d\n", args[1], n)
+		return nil
+
+	case "rm":
+		if len(args) != 2 {
+			return usage
+		}
+		if err := orch.DeleteCollectionPriority(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Removed priority of %s\n", args[1])
+		return nil
+
+	case "file":
+		if len(args) != 3 {
+			return usage
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid priority %q", args[2])
+		}
+		id, err := orch.SetFilePriority(ctx, args[1], n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("File %s... has priority %d\n", id[:12], n)
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+func cmdVectors(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite vectors list | configure <id> key=value...")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	loader := workflow.NewLoader(workflowsDB)
+
+	switch args[0] {
+	case "list":
+		configs, err := loader.ListVectorConfigs(ctx)
+		if err != nil {
+			return err
+		}
+		if len(configs) == 0 {
+			fmt.Println("No vectorization configs. Run 'raglite init' to load the defaults.")
+			return nil
+		}
+		fmt.Printf("%-20s %-4s %-11s %-13s %5s  %-6s %s\n", "ID", "VER", "LAYER", "ALGORITHM", "DIMS", "ACTIVE", "MODEL")
+		for _, v := range configs {
+			fmt.Printf("%-20s v%-3d %-11s %-13s %5d  %-6t %s\n",
+				v.ID, v.Version, v.Layer, v.Algorithm, v.Dimensions, v.Active, v.ModelVersion)
+		}
+		return nil
+
+	case "configure":
+		if len(args) < 3 {
+			return usage
+		}
+		v, err := loader.GetVectorConfig(ctx, args[1])
+		create := errors.Is(err, workflow.ErrNotFound)
+		if create {
+			v = &workflow.VectorizationConfig{ID: args[1], Dimensions: 256, Active: true}
+		} else if err != nil {
+			return err
+		}
+		for _, kv := range args[2:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", kv)
+			}
+			if err := setVectorField(v, key, value); err != nil {
+				return err
+			}
+		}
+
+		if create {
+			if v.Layer == "" || v.Algorithm == "" || v.ModelVersion == "" {
+				return fmt.Errorf("new config %s needs layer, algorithm and model_version", v.ID)
+			}
+			if err := loader.AddVectorConfig(ctx, v); err != nil {
+				return err
+			}
+			fmt.Printf("Added vectorization config %s (v%d)\n", v.ID, v.Version)
+			return nil
+		}
+		if err := loader.UpdateVectorConfig(ctx, v); err != nil {
+			return err
+		}
+		fmt.Printf("Updated vectorization config %s (v%d)\n", v.ID, v.Version)
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// setVectorField applies a key=value assignment from 'raglite vectors configure'.
+func setVectorField(v *workflow.VectorizationConfig, key, value string) error {
+	var err error
+	switch key {
+	case "name":
+		v.Name = value
+	case "description":
+		v.Description = value
+	case "layer":
+		v.Layer = value
+	case "algorithm":
+		v.Algorithm = value
+	case "dimensions", "dims":
+		if v.Dimensions, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s: expected an integer, got %q", key, value)
+		}
+	case "features":
+		v.Features = splitList(value)
+	case "weights":
+		v.Weights, err = parseWeights(value)
+	case "config":
+		if value != "" && !json.Valid([]byte(value)) {
+			return fmt.Errorf("config is not valid JSON")
+		}
+		v.Config = json.RawMessage(value)
+	case "model_version", "model":
+		v.ModelVersion = value
+	case "active":
+		v.Active, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown vectorization field %q", key)
+	}
+	return err
+}
+
+func cmdProfiles(ctx context.Context, dataDir string, args []string) error {
+	usage := fmt.Errorf("usage: raglite profiles list | add <id> [flags] | set <id> key=value...")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	workflowsDB, err := db.OpenWorkflows(dataDir)
+	if err != nil {
+		return err
+	}
+	defer workflowsDB.Close()
+
+	loader := workflow.NewLoader(workflowsDB)
+
+	switch args[0] {
+	case "list":
+		profiles, err := loader.ListSearchProfiles(ctx)
+		if err != nil {
+			return err
+		}
+		if len(profiles) == 0 {
+			fmt.Println("No search profiles. Run 'raglite init' to load the defaults.")
+			return nil
+		}
+		fmt.Printf("%-12s %5s %9s  %-6s %-6s %s\n", "ID", "TOP_K", "MIN_SCORE", "RERANK", "ACTIVE", "WEIGHTS")
+		for _, p := range profiles {
+			weights := make([]string, 0, len(p.Layers))
+			for _, layer := range p.Layers {
+				weights = append(weights, fmt.Sprintf("%s:%g", layer, p.LayerWeights[layer]))
+			}
+			fmt.Printf("%-12s %5d %9.2f  %-6t %-6t %s\n",
+				p.ID, p.TopK, p.MinScore, p.Rerank, p.Active, strings.Join(weights, " "))
+		}
+		return nil
+
+	case "add":
+		if len(args) < 2 {
+			return usage
+		}
+		p := workflow.SearchProfile{ID: args[1], Active: true}
+		fs := flag.NewFlagSet("profiles add", flag.ContinueOnError)
+		fs.StringVar(&p.Name, "name", "", "Display name (default: id)")
+		fs.StringVar(&p.Description, "description", "", "Description")
+		layers := fs.String("layers", strings.Join(workflow.SearchLayers, ","), "Layers to score on (comma list)")
+		weights := fs.String("weights", "", "Layer weights as layer:weight,... (default: equal)")
+		fs.IntVar(&p.TopK, "top-k", 10, "Number of results")
+		fs.Float64Var(&p.MinScore, "min-score", 0, "Minimum result score")
+		fs.BoolVar(&p.Rerank, "rerank", false, "Rerank results by query term coverage")
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		p.Layers = splitList(*layers)
+		if p.LayerWeights, err = parseWeights(*weights); err != nil {
+			return err
+		}
+		if err := loader.AddSearchProfile(ctx, &p); err != nil {
+			return err
+		}
+		fmt.Printf("Added search profile %s\n", p.ID)
+		return nil
+
+	case "set":
+		if len(args) < 3 {
+			return usage
+		}
+		p, err := loader.GetSearchProfile(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		for _, kv := range args[2:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("expected key=value, got %q", kv)
+			}
+			if err := setProfileField(p, key, value); err != nil {
+				return err
+			}
+		}
+		if err := loader.UpdateSearchProfile(ctx, p); err != nil {
+			return err
+		}
+		fmt.Printf("Updated search profile %s\n", p.ID)
+		return nil
+
+	default:
+		return usage
+	}
+}
+
+// setProfileField applies a key=value assignment from 'raglite profiles set'.
+func setProfileField(p *workflow.SearchProfile, key, value string) error {
+	var err error
+	switch key {
+	case "name":
+		p.Name = value
+	case "description":
+		p.Description = value
+	case "layers":
+		p.Layers = splitList(value)
+		// Drop the weights of removed layers
+		for layer := range p.LayerWeights {
+			if !slices.Contains(p.Layers, layer) {
+				delete(p.LayerWeights, layer)
+			}
+		}
+	case "weights", "layer_weights":
+		p.LayerWeights, err = parseWeights(value)
+	case "top_k", "top-k":
+		if p.TopK, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s: expected an integer, got %q", key, value)
+		}
+	case "min_score", "min-score":
+		if p.MinScore, err = strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s: expected a number, got %q", key, value)
+		}
+	case "rerank", "rerank_enabled":
+		p.Rerank, err = strconv.ParseBool(value)
+	case "config":
+		if value != "" && !json.Valid([]byte(value)) {
+			return fmt.Errorf("config is not valid JSON")
+		}
+		p.Config = json.RawMessage(value)
+	case "active":
+		p.Active, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("unknown search profile field %q", key)
+	}
+	return err
+}
+
+// splitList splits a comma list, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// tagFlag collects repeated key=value flags.
+type tagFlag map[string]string
+
+func (t tagFlag) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if k = strings.TrimSpace(k); !ok || k == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	t[k] = strings.TrimSpace(v)
+	return nil
+}
+
+// parseWeights parses a comma list of layer:weight pairs.
+func parseWeights(value string) (map[string]float64, error) {
+	var weights map[string]float64
+	for _, pair := range splitList(value) {
+		layer, w, ok := strings.Cut(pair, ":")
+		weight, err := strconv.ParseFloat(w, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("weights: expected layer:weight, got %q", pair)
+		}
+		if weights == nil {
+			weights = make(map[string]float64)
+		}
+		weights[layer] = weight
+	}
+	return weights, nil
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -75,7 +76,9 @@ func Open(cfg Config) (*DB, error) {
 
 	// Build connection string for modernc.org/sqlite
 	// Note: modernc uses different pragma syntax than mattn
-	dsn := cfg.Path
+	// _time_format=sqlite stores time.Time as "YYYY-MM-DD HH:MM:SS.SSS-07:00",
+	// which julianday() and friends understand.
+	dsn := cfg.Path + "?_time_format=sqlite"
 
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
@@ -182,6 +185,14 @@ func (db *DB) initSchema(schemaFile string) error {
 		return fmt.Errorf("read schema %s: %w", schemaFile, err)
 	}
 
+	// Bring existing tables up to date before the schema references new columns
+	if err := db.migrateColumns(schemaFile); err != nil {
+		return fmt.Errorf("migrate schema %s: %w", schemaFile, err)
+	}
+	if err := db.rebuildTables(schemaFile, string(schema)); err != nil {
+		return fmt.Errorf("migrate schema %s: %w", schemaFile, err)
+	}
+
 	_, err = db.Exec(string(schema))
 	if err != nil {
 		return fmt.Errorf("execute schema %s: %w", schemaFile, err)
@@ -271,6 +282,77 @@ func (db *DB) TableExists(ctx context.Context, tableName string) (bool, error) {
 	return count > 0, nil
 }
 
+// Columns returns the column names of a table, in declaration order.
+// The table may be qualified with an attached schema ("corpus.chunks").
+// A missing table yields no columns and no error.
+func (db *DB) Columns(ctx context.Context, tableName string) ([]string, error) {
+	schema, table := "main", tableName
+	if idx := strings.Index(tableName, "."); idx != -1 {
+		schema, table = tableName[:idx], tableName[idx+1:]
+	}
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM %s.pragma_table_info(?)", schema), table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
+// timeLayouts are the formats SQLite produces for datetime('now') and
+// for time.Time values written by the driver.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+}
+
+// Time scans a TEXT timestamp column into a time.Time.
+// Columns declared TEXT come back from the driver as strings, which
+// database/sql cannot convert on its own: Scan(db.Time(&w.CreatedAt)).
+func Time(t *time.Time) sql.Scanner {
+	return timeScanner{t}
+}
+
+type timeScanner struct{ t *time.Time }
+
+func (s timeScanner) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s.t = time.Time{}
+		return nil
+	case time.Time:
+		*s.t = v
+		return nil
+	case []byte:
+		return s.parse(string(v))
+	case string:
+		return s.parse(v)
+	default:
+		return fmt.Errorf("scan time: unsupported type %T", src)
+	}
+}
+
+func (s timeScanner) parse(v string) error {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			*s.t = t
+			return nil
+		}
+	}
+	return fmt.Errorf("scan time: unrecognized format %q", v)
+}
+
 // RowCount returns the number of rows in a table.
 func (db *DB) RowCount(ctx context.Context, tableName string) (int64, error) {
 	var count int64
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// columnMigration adds a column introduced after its table first shipped.
+// Schema files only use CREATE TABLE IF NOT EXISTS, so existing databases
+// would otherwise never see the new column.
+type columnMigration struct {
+	table      string
+	column     string
+	definition string // must be valid for ALTER TABLE ADD COLUMN (constant default)
+}
+
+// schemaMigrations lists the column additions per schema file, oldest first.
+var schemaMigrations = map[string][]columnMigration{
+	"corpus.sql": {
+		{"step_history", "notes", "TEXT"},
+		{"raw_files", "superseded_by", "TEXT"},
+		{"raw_files", "deleted_at", "TEXT"},
+		{"chunks", "tombstoned_at", "TEXT"},
+		{"raw_files", "collection", "TEXT"},
+		{"raw_files", "tags", "TEXT"},
+		{"chunks", "collection", "TEXT"},
+		{"chunks", "tags", "TEXT"},
+		{"raw_files", "attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"raw_files", "last_error", "TEXT"},
+		{"raw_files", "last_failed_at", "TEXT"},
+		{"raw_files", "next_attempt_at", "TEXT"},
+		{"raw_files", "encoding", "TEXT NOT NULL DEFAULT 'identity'"},
+		{"raw_files", "priority", "INTEGER"},
+	},
+	"workflows.sql": {
+		{"chunking_strategies", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"chunking_strategies", "updated_at", "TEXT"},
+		{"vectorization_configs", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"vectorization_configs", "updated_at", "TEXT"},
+	},
+}
+
+// migrateColumns adds missing columns to tables that already exist.
+// Tables that do not exist yet are left to the schema file.
+func (db *DB) migrateColumns(schemaFile string) error {
+	ctx := context.Background()
+
+	for _, m := range schemaMigrations[schemaFile] {
+		cols, err := db.Columns(ctx, m.table)
+		if err != nil {
+			return fmt.Errorf("read columns of %s: %w", m.table, err)
+		}
+		if len(cols) == 0 {
+			continue
+		}
+
+		exists := false
+		for _, c := range cols {
+			if c == m.column {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	return nil
+}
+
+// tableRebuild recreates a table whose constraints changed after it first
+// shipped: SQLite cannot alter a CHECK constraint in place, and CREATE TABLE
+// IF NOT EXISTS leaves the old definition in existing databases.
+type tableRebuild struct {
+	table string
+	stale string // fragment of the old definition that triggers the rebuild
+}
+
+// schemaRebuilds lists the table rebuilds per schema file, oldest first.
+var schemaRebuilds = map[string][]tableRebuild{
+	"workflows.sql": {
+		// Operations are no longer a closed list (Engine.RegisterOperation)
+		{"workflow_steps", "CHECK (operation IN"},
+	},
+}
+
+// rebuildTables recreates the tables of schemaFile whose stored definition
+// is stale with their definition in schema, keeping their rows: create the
+// new table, copy, drop the old one and rename. Indexes are recreated by
+// the schema file.
+func (db *DB) rebuildTables(schemaFile, schema string) error {
+	ctx := context.Background()
+
+	for _, r := range schemaRebuilds[schemaFile] {
+		var current string
+		err := db.QueryRowContext(ctx,
+			"SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", r.table,
+		).Scan(&current)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read definition of %s: %w", r.table, err)
+		}
+		if !strings.Contains(current, r.stale) {
+			continue
+		}
+
+		definition, err := tableDefinition(schema, r.table)
+		if err != nil {
+			return err
+		}
+		cols, err := db.Columns(ctx, r.table)
+		if err != nil {
+			return fmt.Errorf("read columns of %s: %w", r.table, err)
+		}
+		if err := db.rebuildTable(ctx, r.table, definition, cols); err != nil {
+			return fmt.Errorf("rebuild %s: %w", r.table, err)
+		}
+	}
+
+	return nil
+}
+
+// rebuildTable replaces table with one created by definition, copying the
+// given columns. Foreign keys are off meanwhile so dropping the old table
+// neither fails nor cascades to the rows referencing it.
+func (db *DB) rebuildTable(ctx context.Context, table, definition string, cols []string) error {
+	var foreignKeys int
+	if err := db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	if foreignKeys == 1 {
+		defer db.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tmp := table + "_rebuild"
+	columns := strings.Join(cols, ", ")
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			fmt.Sprintf("DROP TABLE IF EXISTS %s", tmp),
+			strings.Replace(definition, table, tmp, 1),
+			fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmp, columns, columns, table),
+			fmt.Sprintf("DROP TABLE %s", table),
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmp, table),
+		} {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tableDefinition returns the CREATE TABLE statement of table in schema.
+func tableDefinition(schema, table string) (string, error) {
+	start := strings.Index(schema, "CREATE TABLE IF NOT EXISTS "+table+" (")
+	if start == -1 {
+		return "", fmt.Errorf("table %s not found in schema", table)
+	}
+	end := strings.Index(schema[start:], "\n);")
+	if end == -1 {
+		return "", fmt.Errorf("unterminated definition of %s", table)
+	}
+	return schema[start : start+end+len("\n);")], nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRebuildWorkflowSteps opens a workflows database created with the
+// first schema, whose operation CHECK predates registered operations.
+func TestRebuildWorkflowSteps(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	schema, err := os.ReadFile(filepath.Join("testdata", "workflows_v1.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := Open(DefaultConfig(filepath.Join(dir, "workflows.db"), DBTypeWorkflows))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		string(schema),
+		`INSERT INTO workflows (id, name, version) VALUES ('wf', 'Workflow', 1)`,
+		`INSERT INTO workflow_steps (workflow_id, step_order, step_name, operation, source, output)
+		 VALUES ('wf', 1, 'select', 'filter', '_input', 'step_1'), ('wf', 2, 'hash', 'hash', 'step_1', 'step_2')`,
+		`INSERT INTO workflow_step_dependencies (workflow_id, step_order, depends_on_step, dependency_type)
+		 VALUES ('wf', 2, 1, 'data')`,
+	} {
+		if _, err := old.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("seed v1 database: %v", err)
+		}
+	}
+	if _, err := old.ExecContext(ctx, `INSERT INTO workflow_steps (workflow_id, step_order, step_name, operation, source, output)
+		VALUES ('wf', 3, 'parse', 'parse', 'step_2', 'step_3')`); err == nil {
+		t.Fatal("v1 schema accepted a parse step")
+	}
+	old.Close()
+
+	db, err := OpenWorkflows(dir)
+	if err != nil {
+		t.Fatalf("open v1 database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, `INSERT INTO workflow_steps (workflow_id, step_order, step_name, operation, source, output)
+		VALUES ('wf', 3, 'parse', 'parse', 'step_2', 'step_3')`); err != nil {
+		t.Fatalf("insert parse step after migration: %v", err)
+	}
+
+	checks := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM workflow_steps WHERE workflow_id = 'wf'", 3},
+		{"SELECT COUNT(*) FROM workflow_step_dependencies", 1},
+		{"SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_steps_operation'", 1},
+		{"SELECT COUNT(*) FROM sqlite_master WHERE name = 'workflow_steps_rebuild'", 0},
+		{"SELECT COUNT(*) FROM pragma_foreign_key_check", 0},
+		{"SELECT foreign_keys FROM pragma_foreign_keys", 1},
+	}
+	for _, c := range checks {
+		var got int
+		if err := db.QueryRowContext(ctx, c.query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		if got != c.want {
+			t.Errorf("%s = %d, want %d", c.query, got, c.want)
+		}
+	}
+
+	// Deleting a workflow still cascades to its steps
+	if _, err := db.ExecContext(ctx, "DELETE FROM workflows WHERE id = 'wf'"); err != nil {
+		t.Fatal(err)
+	}
+	var steps int
+	db.QueryRowContext(ctx, "SELECT COUNT(*) FROM workflow_steps").Scan(&steps)
+	if steps != 0 {
+		t.Errorf("%d steps left after deleting their workflow", steps)
+	}
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// recordFailureSQL counts a failure of pending files and schedules their
+// next attempt with exponential backoff. After retry_max_attempts failures
+// a file is failed for good. The policy comes from the config table.
+const recordFailureSQL = `
+	WITH policy AS (
+		SELECT
+			COALESCE((SELECT CAST(value AS INTEGER) FROM config WHERE key = 'retry_max_attempts'), 5) AS max_attempts,
+			COALESCE((SELECT CAST(value AS INTEGER) FROM config WHERE key = 'retry_base_delay_seconds'), 30) AS base_delay,
+			COALESCE((SELECT CAST(value AS INTEGER) FROM config WHERE key = 'retry_max_delay_seconds'), 3600) AS max_delay
+	)
+	UPDATE raw_files SET
+		attempts = attempts + 1,
+		last_error = ?,
+		last_failed_at = datetime('now'),
+		next_attempt_at = datetime('now', '+' || (
+			SELECT MIN(base_delay * (1 << MIN(raw_files.attempts, 30)), max_delay) FROM policy
+		) || ' seconds'),
+		status = CASE
+			WHEN attempts + 1 >= (SELECT max_attempts FROM policy) THEN 'failed'
+			ELSE status
+		END
+	WHERE status = 'pending' AND id IN (SELECT value FROM json_each(?))
+	RETURNING id, status
+`
+
+// RecordFileFailure counts a processing failure of pending files and
+// returns the ids of those that reached the attempt limit and are now
+// failed. actor is recorded in the audit log.
+func (db *DB) RecordFileFailure(ctx context.Context, actor string, fileIDs []string, reason string) ([]string, error) {
+	ids, _ := json.Marshal(fileIDs)
+
+	var failed []string
+	err := db.Transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, recordFailureSQL, reason, string(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id, status string
+			if err := rows.Scan(&id, &status); err != nil {
+				return err
+			}
+			if status == "failed" {
+				failed = append(failed, id)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		return auditFailed(ctx, tx, actor, failed, reason)
+	})
+	return failed, err
+}
+
+// FailFiles marks pending files failed without retry, for failures another
+// attempt cannot fix.
+func (db *DB) FailFiles(ctx context.Context, actor string, fileIDs []string, reason string) error {
+	ids, _ := json.Marshal(fileIDs)
+
+	return db.Transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE raw_files SET
+				status = 'failed', last_error = ?, last_failed_at = datetime('now'), next_attempt_at = NULL
+			WHERE status = 'pending' AND id IN (SELECT value FROM json_each(?))
+			RETURNING id
+		`, reason, string(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var failed []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			failed = append(failed, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		return auditFailed(ctx, tx, actor, failed, reason)
+	})
+}
+
+func auditFailed(ctx context.Context, tx *sql.Tx, actor string, fileIDs []string, reason string) error {
+	for _, id := range fileIDs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO audit_log (actor, action, target, details)
+			VALUES (?, 'file_failed', ?, json_object('error', ?))
+		`, actor, id, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
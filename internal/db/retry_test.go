@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRecordFileFailure checks the attempt count, the exponential backoff
+// capped by retry_max_delay_seconds and the failure at retry_max_attempts.
+func TestRecordFileFailure(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenCorpus(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, stmt := range []string{
+		`INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum, status)
+		 VALUES ('f1', '/a.md', 'text/markdown', 1, 'f1', 'f1', 'pending'),
+		        ('f2', '/b.md', 'text/markdown', 1, 'f2', 'f2', 'chunked')`,
+		`INSERT OR REPLACE INTO config (key, value) VALUES
+		 ('retry_max_attempts', '3'), ('retry_base_delay_seconds', '10'), ('retry_max_delay_seconds', '25')`,
+	} {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	for i, want := range []struct {
+		attempts int
+		delay    int
+		status   string
+	}{
+		{1, 10, "pending"},
+		{2, 20, "pending"},
+		{3, 25, "failed"},
+	} {
+		failed, err := db.RecordFileFailure(ctx, "test", []string{"f1", "f2"}, "boom")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (want.status == "failed") != (len(failed) == 1) {
+			t.Errorf("failure %d: failed = %v", i+1, failed)
+		}
+
+		var attempts, delay int
+		var status, lastError string
+		err = db.QueryRowContext(ctx, `
+			SELECT attempts, strftime('%s', next_attempt_at) - strftime('%s', last_failed_at), status, last_error
+			FROM raw_files WHERE id = 'f1'
+		`).Scan(&attempts, &delay, &status, &lastError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != want.attempts || delay != want.delay || status != want.status || lastError != "boom" {
+			t.Errorf("failure %d: attempts %d, delay %ds, status %s, error %q; want %d, %ds, %s",
+				i+1, attempts, delay, status, lastError, want.attempts, want.delay, want.status)
+		}
+	}
+
+	// Failed and processed files are not counted again
+	if failed, err := db.RecordFileFailure(ctx, "test", []string{"f1", "f2"}, "again"); err != nil || len(failed) != 0 {
+		t.Errorf("failure of failed file: %v, %v", failed, err)
+	}
+	checks := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT attempts FROM raw_files WHERE id = 'f1'", 3},
+		{"SELECT attempts FROM raw_files WHERE id = 'f2'", 0},
+		{"SELECT COUNT(*) FROM audit_log WHERE action = 'file_failed' AND target = 'f1'", 1},
+	}
+	for _, c := range checks {
+		var got int
+		if err := db.QueryRowContext(ctx, c.query).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s = %d, want %d", c.query, got, c.want)
+		}
+	}
+}
+
+// TestFailFiles checks that files failed for good keep their attempt count
+// and lose their backoff.
+func TestFailFiles(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenCorpus(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum, status, attempts, next_attempt_at)
+		VALUES ('f1', '/a.md', 'text/markdown', 1, 'f1', 'f1', 'pending', 1, datetime('now', '+1 hour'))
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FailFiles(ctx, "test", []string{"f1"}, "invalid output"); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int
+	var status, lastError string
+	var next *string
+	if err := db.QueryRowContext(ctx,
+		"SELECT attempts, status, last_error, next_attempt_at FROM raw_files WHERE id = 'f1'",
+	).Scan(&attempts, &status, &lastError, &next); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 || status != "failed" || lastError != "invalid output" || next != nil {
+		t.Errorf("file = %d attempts, %s, %q, next %v", attempts, status, lastError, next)
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"go/ast"
@@ -152,9 +150,7 @@ func blocksToSegments(blocks []CodeBlock, language string) ([]Segment, error) {
 }
 
 func createCodeSegment(content, blockType, name, language string, startLine, endLine int) Segment {
-	hash := sha256.Sum256([]byte(content))
 	return Segment{
-		ID:          hex.EncodeToString(hash[:16]),
 		SegmentType: "code",
 		Content:     content,
 		Position:    startLine,
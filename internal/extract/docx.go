@@ -4,8 +4,6 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -87,7 +85,7 @@ type paragraph struct {
 }
 
 type paragraphProperties struct {
-	Style     *styleRef `xml:"pStyle"`
+	Style        *styleRef   `xml:"pStyle"`
 	OutlineLevel *outlineLvl `xml:"outlineLvl"`
 }
 
@@ -174,9 +172,7 @@ func (e *DOCXExtractor) convertToSegments(doc *document) ([]Segment, error) {
 			segType = "heading"
 		}
 
-		hash := sha256.Sum256([]byte(text))
 		segments = append(segments, Segment{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: segType,
 			Content:     text,
 			Position:    position,
@@ -195,9 +191,7 @@ func (e *DOCXExtractor) convertToSegments(doc *document) ([]Segment, error) {
 			continue
 		}
 
-		hash := sha256.Sum256([]byte(text))
 		segments = append(segments, Segment{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: "table",
 			Content:     text,
 			Position:    position,
@@ -269,9 +263,7 @@ func (e *DOCXExtractor) fallbackExtract(content []byte) ([]Segment, error) {
 			continue
 		}
 
-		hash := sha256.Sum256([]byte(p))
 		segments = append(segments, Segment{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: "text",
 			Content:     p,
 			Position:    i,
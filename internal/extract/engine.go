@@ -0,0 +1,68 @@
+package extract
+
+import (
+	"context"
+	"encoding/json"
+
+	"goraglite/internal/workflow"
+)
+
+// NewDefaultRegistry returns a registry holding the built-in extractors.
+func NewDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(NewPDFExtractor())
+	r.Register(NewDOCXExtractor())
+	r.Register(NewXLSXExtractor())
+	r.Register(NewCodeExtractor())
+	return r
+}
+
+// RegisterAll registers every extractor of the registry with a workflow
+// engine, for use by external steps.
+func (r *Registry) RegisterAll(engine *workflow.Engine) {
+	for _, ext := range r.extractors {
+		engine.RegisterExtractor(engineExtractor{ext})
+	}
+}
+
+// engineExtractor adapts an Extractor to the workflow engine.
+type engineExtractor struct {
+	Extractor
+}
+
+// Extract hands the extractor the options of the step config and converts
+// its segments to engine segments.
+func (e engineExtractor) Extract(ctx context.Context, content []byte, config json.RawMessage) ([]workflow.ExtractedSegment, error) {
+	var step struct {
+		Options json.RawMessage `json:"options"`
+	}
+	if config != nil && json.Unmarshal(config, &step) == nil && step.Options != nil {
+		config = step.Options
+	}
+
+	segments, err := e.Extractor.Extract(ctx, content, config)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]workflow.ExtractedSegment, len(segments))
+	for i, seg := range segments {
+		out[i] = workflow.ExtractedSegment{
+			ID:          seg.ID,
+			FileID:      seg.FileID,
+			SegmentType: seg.SegmentType,
+			Content:     seg.Content,
+			Page:        seg.Page,
+			Position:    seg.Position,
+			Confidence:  seg.Confidence,
+		}
+		if seg.BBox != nil {
+			bbox, err := json.Marshal(seg.BBox)
+			if err != nil {
+				return nil, err
+			}
+			out[i].BBox = string(bbox)
+		}
+	}
+	return out, nil
+}
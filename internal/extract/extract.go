@@ -8,17 +8,19 @@ import (
 	"fmt"
 )
 
-// Segment represents an extracted text segment.
+// Segment represents an extracted text segment. Extractors leave ID empty:
+// the workflow engine derives it from the file, the extractor and the
+// segment position, so equal content in two files gets two segments.
 type Segment struct {
-	ID           string   `json:"id"`
-	FileID       string   `json:"file_id"`
-	SegmentType  string   `json:"segment_type"` // text, table, image_ocr, metadata, code
-	Content      string   `json:"content"`
-	Page         *int     `json:"page,omitempty"`
-	Position     int      `json:"position"`
-	BBox         *BBox    `json:"bbox,omitempty"`
-	Confidence   float64  `json:"confidence,omitempty"`
-	Metadata     Metadata `json:"metadata,omitempty"`
+	ID          string   `json:"id"`
+	FileID      string   `json:"file_id"`
+	SegmentType string   `json:"segment_type"` // text, table, image_ocr, metadata, code
+	Content     string   `json:"content"`
+	Page        *int     `json:"page,omitempty"`
+	Position    int      `json:"position"`
+	BBox        *BBox    `json:"bbox,omitempty"`
+	Confidence  *float64 `json:"confidence,omitempty"`
+	Metadata    Metadata `json:"metadata,omitempty"`
 }
 
 // BBox represents a bounding box.
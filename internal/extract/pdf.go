@@ -3,8 +3,6 @@ package extract
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os/exec"
@@ -117,12 +115,11 @@ func (e *PDFExtractor) parseText(text string) ([]Segment, error) {
 		// Page break detection
 		if pagePattern.MatchString(trimmed) || line == "\f" {
 			if currentBlock.Len() > 0 {
-				hash := sha256.Sum256([]byte(currentBlock.String()))
+				page := currentPage
 				segments = append(segments, Segment{
-					ID:          hex.EncodeToString(hash[:16]),
 					SegmentType: "text",
 					Content:     strings.TrimSpace(currentBlock.String()),
-					Page:        &currentPage,
+					Page:        &page,
 					Position:    position,
 					Metadata: Metadata{
 						LineStart: blockStart,
@@ -140,12 +137,11 @@ func (e *PDFExtractor) parseText(text string) ([]Segment, error) {
 		// Empty line = paragraph break
 		if trimmed == "" {
 			if currentBlock.Len() > 0 {
-				hash := sha256.Sum256([]byte(currentBlock.String()))
+				page := currentPage
 				segments = append(segments, Segment{
-					ID:          hex.EncodeToString(hash[:16]),
 					SegmentType: "text",
 					Content:     strings.TrimSpace(currentBlock.String()),
-					Page:        &currentPage,
+					Page:        &page,
 					Position:    position,
 					Metadata: Metadata{
 						LineStart: blockStart,
@@ -165,12 +161,11 @@ func (e *PDFExtractor) parseText(text string) ([]Segment, error) {
 
 	// Last block
 	if currentBlock.Len() > 0 {
-		hash := sha256.Sum256([]byte(currentBlock.String()))
+		page := currentPage
 		segments = append(segments, Segment{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: "text",
 			Content:     strings.TrimSpace(currentBlock.String()),
-			Page:        &currentPage,
+			Page:        &page,
 			Position:    position,
 			Metadata: Metadata{
 				LineStart: blockStart,
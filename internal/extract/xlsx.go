@@ -4,8 +4,6 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -194,10 +192,7 @@ func (e *XLSXExtractor) convertToSegments(ws *worksheet, sharedStrings []string,
 		}
 
 		rowText := strings.Join(cells, " | ")
-		hash := sha256.Sum256([]byte(rowText))
-
 		segments = append(segments, Segment{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: "table",
 			Content:     rowText,
 			Position:    startPos + row.R,
@@ -247,11 +242,8 @@ func (e *XLSXExtractor) fallbackExtract(content []byte, sheetName string, startP
 	}
 
 	text := strings.Join(values, " | ")
-	hash := sha256.Sum256([]byte(text))
-
 	return []Segment{
 		{
-			ID:          hex.EncodeToString(hash[:16]),
 			SegmentType: "table",
 			Content:     text,
 			Position:    startPos,
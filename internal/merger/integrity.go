@@ -0,0 +1,111 @@
+package merger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// danglingRows are the derived rows whose parent row is gone, by table,
+// in the order they are deleted. Foreign keys prevent them while enabled;
+// they come from writes made without.
+var danglingRows = []struct {
+	table string
+	where string
+}{
+	{"chunks", "file_id NOT IN (SELECT id FROM raw_files)"},
+	{"extracted_segments", "file_id NOT IN (SELECT id FROM raw_files)"},
+	{"parsed_units", "segment_id NOT IN (SELECT id FROM extracted_segments)"},
+	{"chunk_features", "chunk_id NOT IN (SELECT id FROM chunks)"},
+	{"chunk_vectors", "chunk_id NOT IN (SELECT id FROM chunks)"},
+	{"chunk_relations", "from_chunk_id NOT IN (SELECT id FROM chunks) OR to_chunk_id NOT IN (SELECT id FROM chunks)"},
+	{"chunk_lineage", "chunk_id NOT IN (SELECT id FROM chunks)"},
+}
+
+// Integrity reports derived rows whose parent is gone and whether the
+// full-text index drifted from the chunks.
+type Integrity struct {
+	Dangling map[string]int64 `json:"dangling"` // rows by table
+	FTSDrift bool             `json:"fts_drift"`
+	Repaired bool             `json:"repaired"`
+}
+
+// Problems returns the number of problems found.
+func (i *Integrity) Problems() int64 {
+	var n int64
+	for _, count := range i.Dangling {
+		n += count
+	}
+	if i.FTSDrift {
+		n++
+	}
+	return n
+}
+
+// CheckIntegrity looks for derived rows whose parent row is gone and
+// checks the full-text index against the chunks. With repair, dangling
+// rows are deleted and the index is rebuilt.
+func (m *Merger) CheckIntegrity(ctx context.Context, repair bool) (*Integrity, error) {
+	result := &Integrity{Dangling: make(map[string]int64)}
+	for _, d := range danglingRows {
+		var n int64
+		err := m.corpusDB.QueryRowContext(ctx,
+			fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", d.table, d.where),
+		).Scan(&n)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", d.table, err)
+		}
+		if n > 0 {
+			result.Dangling[d.table] = n
+		}
+	}
+
+	// With rank 1 the index is compared with the content table as well
+	_, err := m.corpusDB.ExecContext(ctx, "INSERT INTO chunks_fts(chunks_fts, rank) VALUES ('integrity-check', 1)")
+	result.FTSDrift = err != nil
+
+	if !repair || result.Problems() == 0 {
+		return result, nil
+	}
+
+	err = m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		if result.Dangling["chunks"] > 0 {
+			// References without cascade to the chunks about to go
+			const dangling = `SELECT id FROM chunks WHERE file_id NOT IN (SELECT id FROM raw_files)`
+			for _, stmt := range []string{
+				`UPDATE feedback_log SET result_chunk_id = NULL WHERE result_chunk_id IN (` + dangling + `)`,
+				`UPDATE chunks SET parent_id = NULL WHERE parent_id IN (` + dangling + `)`,
+			} {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+		}
+
+		// In order: deleting chunks leaves their features and vectors dangling
+		// when foreign keys are off
+		for _, d := range danglingRows {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", d.table, d.where))
+			if err != nil {
+				return fmt.Errorf("delete dangling %s: %w", d.table, err)
+			}
+		}
+
+		if result.FTSDrift {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO chunks_fts(chunks_fts) VALUES ('rebuild')"); err != nil {
+				return fmt.Errorf("rebuild fts: %w", err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO audit_log (actor, action, target, details)
+			VALUES ('merger', 'fsck', 'derived', json_object('problems', ?, 'fts_rebuilt', ?))
+		`, result.Problems(), result.FTSDrift)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Repaired = true
+	return result, nil
+}
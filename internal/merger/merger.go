@@ -6,12 +6,15 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"goraglite/internal/db"
@@ -20,55 +23,65 @@ import (
 // Merger integrates completed run outputs into the corpus.
 // It is the only component that writes to corpus.db.
 type Merger struct {
-	corpusDB *db.DB
-	queueDir string
-	doneDir  string
-	failDir  string
+	corpusDB      *db.DB
+	queueDir      string
+	doneDir       string
+	failDir       string
+	quarantineDir string // runs whose output failed validation, with their report
+	workflowsPath string // workflows.db, for the vectorization configs
 
 	mu        sync.Mutex
 	running   bool
 	stopCh    chan struct{}
 	batchSize int
 	interval  time.Duration
+
+	tombstonesDue atomic.Bool // set by RequestTombstones, cleared by the next batch
 }
 
 // Config holds merger configuration.
 type Config struct {
-	QueueDir  string
-	DoneDir   string
-	FailDir   string
-	BatchSize int
-	Interval  time.Duration
+	QueueDir      string
+	DoneDir       string
+	FailDir       string
+	QuarantineDir string
+	WorkflowsDB   string // path of workflows.db; vector dimensions are not checked against configs when empty
+	BatchSize     int
+	Interval      time.Duration
 }
 
 // DefaultConfig returns sensible defaults.
 func DefaultConfig(dataDir string) Config {
 	return Config{
-		QueueDir:  filepath.Join(dataDir, "queue", "pending"),
-		DoneDir:   filepath.Join(dataDir, "queue", "done"),
-		FailDir:   filepath.Join(dataDir, "queue", "failed"),
-		BatchSize: 100,
-		Interval:  time.Second,
+		QueueDir:      filepath.Join(dataDir, "queue", "pending"),
+		DoneDir:       filepath.Join(dataDir, "queue", "done"),
+		FailDir:       filepath.Join(dataDir, "queue", "failed"),
+		QuarantineDir: filepath.Join(dataDir, "queue", "quarantine"),
+		WorkflowsDB:   filepath.Join(dataDir, "workflows.db"),
+		BatchSize:     100,
+		Interval:      time.Second,
 	}
 }
 
 // New creates a new merger.
 func New(corpusDB *db.DB, cfg Config) (*Merger, error) {
 	// Ensure directories exist
-	for _, dir := range []string{cfg.QueueDir, cfg.DoneDir, cfg.FailDir} {
+	for _, dir := range []string{cfg.QueueDir, cfg.DoneDir, cfg.FailDir, cfg.QuarantineDir} {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, fmt.Errorf("create dir %s: %w", dir, err)
 		}
 	}
 
 	return &Merger{
-		corpusDB:  corpusDB,
-		queueDir:  cfg.QueueDir,
-		doneDir:   cfg.DoneDir,
-		failDir:   cfg.FailDir,
-		batchSize: cfg.BatchSize,
-		interval:  cfg.Interval,
-		stopCh:    make(chan struct{}),
+		corpusDB:      corpusDB,
+		queueDir:      cfg.QueueDir,
+		doneDir:       cfg.DoneDir,
+		failDir:       cfg.FailDir,
+		quarantineDir: cfg.QuarantineDir,
+		workflowsPath: cfg.WorkflowsDB,
+		batchSize:     cfg.BatchSize,
+		interval:      cfg.Interval,
+		stopCh:        make(chan struct{}),
 	}, nil
 }
 
@@ -82,6 +95,9 @@ func (m *Merger) Start(ctx context.Context) error {
 	m.running = true
 	m.mu.Unlock()
 
+	// Deletions seen while no merger ran are tombstoned by the first batch
+	m.tombstonesDue.Store(true)
+
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
@@ -110,96 +126,320 @@ func (m *Merger) Stop() {
 	}
 }
 
-// ProcessOne processes a single run file immediately.
-func (m *Merger) ProcessOne(ctx context.Context, runDBPath string) error {
-	return m.mergeRun(ctx, runDBPath)
+// ProcessOne processes a single run file immediately. The run file stays
+// where it is.
+func (m *Merger) ProcessOne(ctx context.Context, runDBPath string) (*MergeResult, error) {
+	start := time.Now()
+	result, err := m.mergeRun(ctx, runDBPath)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Status, result.Error = MergeFailed, err.Error()
+	}
+	return result, err
+}
+
+// RequestTombstones has the next batch apply tombstones, for deletions
+// recorded outside a merge (watched files). Merges and purges apply them
+// within their own transaction.
+func (m *Merger) RequestTombstones() {
+	m.tombstonesDue.Store(true)
 }
 
-// processBatch processes a batch of pending runs.
+// ApplyTombstones retires document versions replaced by a newer merged
+// version and archive members no live archive contains any more,
+// tombstones the chunks of retired versions and deleted files, and lifts
+// the tombstones of files that came back. It returns the number of chunks
+// changed.
+func (m *Merger) ApplyTombstones(ctx context.Context) (int64, error) {
+	var changed int64
+	err := m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		changed, err = m.applyTombstones(ctx, tx)
+		return err
+	})
+	return changed, err
+}
+
+// applyTombstones is ApplyTombstones within a transaction.
+func (m *Merger) applyTombstones(ctx context.Context, tx *sql.Tx) (int64, error) {
+	// Members of an archive that came back are live again
+	_, err := tx.ExecContext(ctx, `
+		UPDATE document_versions SET retired_at = NULL
+		WHERE retired_at IS NOT NULL
+			AND version = (SELECT MAX(version) FROM document_versions d WHERE d.document_id = document_versions.document_id)
+			AND EXISTS (SELECT 1 FROM archive_members am WHERE `+liveMember+`)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("restore archive members: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE document_versions SET retired_at = datetime('now')
+		WHERE retired_at IS NULL AND EXISTS (
+			SELECT 1 FROM document_versions n
+			WHERE n.document_id = document_versions.document_id
+				AND n.version > document_versions.version
+				AND n.merged_at IS NOT NULL
+		)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("retire versions: %w", err)
+	}
+	retired, _ := res.RowsAffected()
+
+	// Members no live archive holds; a member whose newer version is not
+	// merged yet is retired by that merge instead. Repeated for members of
+	// nested archives.
+	for {
+		res, err = tx.ExecContext(ctx, `
+			UPDATE document_versions SET retired_at = datetime('now')
+			WHERE retired_at IS NULL
+				AND EXISTS (
+					SELECT 1 FROM archive_members am
+					WHERE am.document_id = document_versions.document_id AND am.file_id = document_versions.file_id
+				)
+				AND NOT EXISTS (SELECT 1 FROM archive_members am WHERE `+liveMember+`)
+				AND NOT EXISTS (
+					SELECT 1 FROM document_versions n
+					WHERE n.document_id = document_versions.document_id
+						AND n.version > document_versions.version AND n.merged_at IS NULL
+				)
+		`)
+		if err != nil {
+			return 0, fmt.Errorf("retire archive members: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			break
+		}
+		retired += n
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE documents SET current_version = (`+currentVersion+`)
+		WHERE current_version IS NOT (`+currentVersion+`)
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("update current versions: %w", err)
+	}
+
+	// A file is retired once every document version holding it is retired
+	const retiredFiles = `
+		SELECT id FROM raw_files WHERE deleted_at IS NOT NULL
+		UNION
+		SELECT file_id FROM document_versions
+		GROUP BY file_id HAVING COUNT(*) = COUNT(retired_at)
+	`
+
+	res, err = tx.ExecContext(ctx, `
+		UPDATE chunks SET tombstoned_at = datetime('now')
+		WHERE tombstoned_at IS NULL AND file_id IN (`+retiredFiles+`)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	tombstoned, _ := res.RowsAffected()
+
+	res, err = tx.ExecContext(ctx, `
+		UPDATE chunks SET tombstoned_at = NULL
+		WHERE tombstoned_at IS NOT NULL AND file_id NOT IN (`+retiredFiles+`)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	restored, _ := res.RowsAffected()
+
+	changed := tombstoned + restored
+	if changed > 0 || retired > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO audit_log (actor, action, details)
+			VALUES ('merger', 'tombstone', json_object('retired_versions', ?, 'tombstoned', ?, 'restored', ?))
+		`, retired, tombstoned, restored)
+	}
+	return changed, err
+}
+
+// currentVersion selects the last merged, live version of the outer
+// documents row.
+const currentVersion = `
+	SELECT MAX(version) FROM document_versions
+	WHERE document_id = documents.id AND merged_at IS NOT NULL AND retired_at IS NULL`
+
+// liveMember matches archive_members rows (alias am) of the outer
+// document_versions row held by an archive that is neither deleted nor
+// retired.
+const liveMember = `am.document_id = document_versions.document_id
+	AND am.file_id = document_versions.file_id
+	AND EXISTS (
+		SELECT 1 FROM raw_files a JOIN document_versions av ON av.file_id = a.id
+		WHERE a.id = am.archive_id AND a.deleted_at IS NULL AND av.retired_at IS NULL
+	)`
+
+// processBatch processes a batch of pending runs, after the tombstones
+// requested since the previous batch.
 func (m *Merger) processBatch(ctx context.Context) error {
+	if m.tombstonesDue.Swap(false) {
+		if _, err := m.ApplyTombstones(ctx); err != nil {
+			m.tombstonesDue.Store(true)
+			fmt.Fprintf(os.Stderr, "apply tombstones: %v\n", err)
+		}
+	}
+
+	results, err := m.Drain(ctx, m.batchSize)
+	for _, r := range results {
+		switch r.Status {
+		case MergeFailed:
+			fmt.Fprintf(os.Stderr, "merge failed for %s: %s\n", r.Run, r.Error)
+		case MergeQuarantined:
+			fmt.Fprintf(os.Stderr, "run %s quarantined: %s (report %s)\n", r.Run, r.Error, r.Report)
+		}
+	}
+	return err
+}
+
+// queued returns the queued run databases, oldest first. Runs queued in
+// the same instant are taken by name, so the order is deterministic.
+func (m *Merger) queued() ([]string, error) {
 	entries, err := os.ReadDir(m.queueDir)
 	if err != nil {
-		return fmt.Errorf("read queue dir: %w", err)
+		return nil, fmt.Errorf("read queue dir: %w", err)
 	}
 
-	// Filter and sort by modification time (FIFO)
-	var dbFiles []string
+	type queuedRun struct {
+		path    string
+		modTime time.Time
+	}
+	var runs []queuedRun
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".db") {
 			continue
 		}
-		dbFiles = append(dbFiles, filepath.Join(m.queueDir, entry.Name()))
-	}
-
-	if len(dbFiles) == 0 {
-		return nil
+		info, err := entry.Info()
+		if err != nil {
+			// Taken by another merger meanwhile
+			continue
+		}
+		runs = append(runs, queuedRun{filepath.Join(m.queueDir, entry.Name()), info.ModTime()})
 	}
 
-	// Sort by modification time
-	sort.Slice(dbFiles, func(i, j int) bool {
-		fi, _ := os.Stat(dbFiles[i])
-		fj, _ := os.Stat(dbFiles[j])
-		if fi == nil || fj == nil {
-			return false
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].modTime.Equal(runs[j].modTime) {
+			return runs[i].modTime.Before(runs[j].modTime)
 		}
-		return fi.ModTime().Before(fj.ModTime())
+		return runs[i].path < runs[j].path
 	})
 
-	// Process up to batchSize
-	count := m.batchSize
-	if len(dbFiles) < count {
-		count = len(dbFiles)
+	paths := make([]string, len(runs))
+	for i, r := range runs {
+		paths[i] = r.path
 	}
+	return paths, nil
+}
 
-	for i := 0; i < count; i++ {
-		if err := m.mergeRun(ctx, dbFiles[i]); err != nil {
-			// Move to failed
-			failPath := filepath.Join(m.failDir, filepath.Base(dbFiles[i]))
-			os.Rename(dbFiles[i], failPath)
-			fmt.Fprintf(os.Stderr, "merge failed for %s: %v\n", dbFiles[i], err)
-			continue
-		}
+// Drain merges up to limit queued runs, all of them when limit is 0, in
+// queue order. Merged runs move to the done directory, runs whose output
+// fails validation to the quarantine one and runs that fail to merge to the
+// failed one. It returns the result of each run taken. When
+// ctx is cancelled the run being merged is rolled back and left queued.
+func (m *Merger) Drain(ctx context.Context, limit int) ([]*MergeResult, error) {
+	runs, err := m.queued()
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
 
-		// Move to done
-		donePath := filepath.Join(m.doneDir, filepath.Base(dbFiles[i]))
-		os.Rename(dbFiles[i], donePath)
+	var results []*MergeResult
+	for _, path := range runs {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		result := m.mergeQueued(ctx, path)
+		if result == nil {
+			// Interrupted merges were rolled back: leave the run queued
+			return results, ctx.Err()
+		}
+		results = append(results, result)
 	}
+	return results, nil
+}
 
-	return nil
+// mergeQueued merges a queued run and moves it out of the queue. It
+// returns nil when ctx was cancelled during the merge.
+func (m *Merger) mergeQueued(ctx context.Context, path string) *MergeResult {
+	start := time.Now()
+	result, err := m.mergeRun(ctx, path)
+	result.Run = filepath.Base(path)
+	result.Duration = time.Since(start)
+
+	dest := m.doneDir
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m.recordFailure(ctx, path, err)
+		result.Status, result.Error = MergeFailed, err.Error()
+		dest = m.failDir
+
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			result.Status = MergeQuarantined
+			result.Report, err = m.quarantineRun(path, invalid.Report)
+			if err != nil {
+				result.Error += fmt.Sprintf("; quarantine: %v", err)
+			}
+			return result
+		}
+	}
+	if err := os.Rename(path, filepath.Join(dest, result.Run)); err != nil && result.Error == "" {
+		result.Error = fmt.Sprintf("move out of queue: %v", err)
+	}
+	return result
 }
 
-// mergeRun merges a single run's output into the corpus.
-func (m *Merger) mergeRun(ctx context.Context, runDBPath string) error {
+// mergeRun merges a single run's output into the corpus. The result is
+// never nil and holds what is known of the run when the merge fails.
+func (m *Merger) mergeRun(ctx context.Context, runDBPath string) (*MergeResult, error) {
+	result := &MergeResult{Run: filepath.Base(runDBPath)}
+
 	// Verify file exists
 	if _, err := os.Stat(runDBPath); err != nil {
-		return fmt.Errorf("run db not found: %w", err)
+		return result, fmt.Errorf("run db not found: %w", err)
 	}
 
 	// Attach run database
 	alias := "run_src"
 	if err := m.corpusDB.Attach(ctx, runDBPath, alias); err != nil {
-		return fmt.Errorf("attach run db: %w", err)
+		return result, fmt.Errorf("attach run db: %w", err)
 	}
 	defer m.corpusDB.Detach(ctx, alias)
 
-	// Verify run completed successfully
-	var status string
-	err := m.corpusDB.QueryRowContext(ctx, fmt.Sprintf("SELECT status FROM %s._run_meta LIMIT 1", alias)).Scan(&status)
-	if err != nil {
-		return fmt.Errorf("check run status: %w", err)
-	}
-	if status != "completed" {
-		return fmt.Errorf("run not completed, status: %s", status)
-	}
-
 	// Get run metadata
-	var runID, workflowID string
+	var runID, workflowID, status string
 	var workflowVersion int
-	err = m.corpusDB.QueryRowContext(ctx,
-		fmt.Sprintf("SELECT run_id, workflow_id, workflow_version FROM %s._run_meta LIMIT 1", alias),
-	).Scan(&runID, &workflowID, &workflowVersion)
+	var fileIDs sql.NullString
+	err := m.corpusDB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT run_id, workflow_id, workflow_version, status, json_extract(config, '$.parameters.file_ids')
+		FROM %s._run_meta LIMIT 1
+	`, alias)).Scan(&runID, &workflowID, &workflowVersion, &status, &fileIDs)
 	if err != nil {
-		return fmt.Errorf("get run meta: %w", err)
+		return result, fmt.Errorf("get run meta: %w", err)
+	}
+	result.RunID, result.WorkflowID = runID, workflowID
+
+	// Failed runs are kept in the history so workflow metrics see them
+	if status == "failed" {
+		if err := m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+			return m.recordHistory(ctx, tx, alias, "skipped", nil)
+		}); err != nil {
+			return result, fmt.Errorf("record failed run: %w", err)
+		}
+		result.Status = MergeRunFailed
+		return result, nil
+	}
+	if status != "completed" {
+		return result, fmt.Errorf("run not completed, status: %s", status)
 	}
 
 	// Check if already merged (idempotency)
@@ -209,24 +449,80 @@ func (m *Merger) mergeRun(ctx context.Context, runDBPath string) error {
 		runID,
 	).Scan(&existingCount)
 	if existingCount > 0 {
-		return nil // Already merged
+		result.Status = MergeDuplicate
+		return result, nil
+	}
+
+	configs, err := m.vectorConfigs(ctx)
+	if err != nil {
+		return result, fmt.Errorf("read vectorization configs: %w", err)
 	}
 
 	// Merge in transaction
-	return m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+	err = m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		if err := validateRun(ctx, tx, alias, runID, workflowID, configs); err != nil {
+			return err
+		}
+
+		policy, err := policyOf(ctx, tx, workflowID)
+		if err != nil {
+			return fmt.Errorf("merge policy: %w", err)
+		}
+		result.Policy = policy
+
+		// Earlier output of the run's files goes before the new one comes in
+		conflict := "OR IGNORE"
+		if policy != PolicyAppend {
+			conflict = "OR REPLACE"
+			files, err := runFiles(ctx, tx, alias)
+			if err != nil {
+				return err
+			}
+			if policy == PolicyReplaceIfNewer {
+				newer, err := newerOutput(ctx, tx, files, workflowID, workflowVersion)
+				if err != nil {
+					return fmt.Errorf("check newer output: %w", err)
+				}
+				if newer > 0 {
+					result.Status = MergeSkipped
+					return m.recordHistory(ctx, tx, alias, "skipped", nil)
+				}
+			}
+			result.ChunksReplaced, err = replaceOutput(ctx, tx, alias, runID, files)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO audit_log (actor, action, target, details)
+				VALUES ('merger', 'merge_replace', ?, json_object('policy', ?, 'files', json_array_length(?), 'chunks', ?))
+			`, runID, policy, files, result.ChunksReplaced)
+			if err != nil {
+				return fmt.Errorf("audit replace: %w", err)
+			}
+		}
+
+		// Merge segments and parsed units (chunks reference units by id)
+		if err := m.mergeSegments(ctx, tx, alias); err != nil {
+			return fmt.Errorf("merge segments: %w", err)
+		}
+		if err := m.mergeUnits(ctx, tx, alias); err != nil {
+			return fmt.Errorf("merge units: %w", err)
+		}
+
 		// Merge chunks
 		chunksInserted, err := m.mergeChunks(ctx, tx, alias, runID)
 		if err != nil {
 			return fmt.Errorf("merge chunks: %w", err)
 		}
+		result.ChunksInserted = chunksInserted
 
 		// Merge features
-		if err := m.mergeFeatures(ctx, tx, alias); err != nil {
+		if err := m.mergeFeatures(ctx, tx, alias, runID, conflict); err != nil {
 			return fmt.Errorf("merge features: %w", err)
 		}
 
 		// Merge vectors
-		if err := m.mergeVectors(ctx, tx, alias); err != nil {
+		if err := m.mergeVectors(ctx, tx, alias, runID, conflict); err != nil {
 			return fmt.Errorf("merge vectors: %w", err)
 		}
 
@@ -236,14 +532,8 @@ func (m *Merger) mergeRun(ctx context.Context, runDBPath string) error {
 		}
 
 		// Update run history
-		_, err = tx.ExecContext(ctx, `
-			INSERT OR REPLACE INTO run_history
-			(run_id, workflow_id, workflow_version, started_at, finished_at, status, rows_produced, merge_status)
-			SELECT run_id, workflow_id, workflow_version, started_at, finished_at, status, ?, 'merged'
-			FROM run_src._run_meta
-		`, chunksInserted)
-		if err != nil {
-			return fmt.Errorf("update run history: %w", err)
+		if err := m.recordHistory(ctx, tx, alias, "merged", chunksInserted); err != nil {
+			return err
 		}
 
 		// Update raw_files status for processed files
@@ -255,8 +545,167 @@ func (m *Merger) mergeRun(ctx context.Context, runDBPath string) error {
 			return fmt.Errorf("update file status: %w", err)
 		}
 
+		// The input files of the run are now live versions of their
+		// documents, those that produced no chunk included
+		files, err := runFiles(ctx, tx, alias)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE document_versions SET merged_at = datetime('now')
+			WHERE merged_at IS NULL
+				AND file_id IN (SELECT value FROM json_each(?))
+		`, files)
+		if err != nil {
+			return fmt.Errorf("update document versions: %w", err)
+		}
+		if _, err := m.applyTombstones(ctx, tx); err != nil {
+			return fmt.Errorf("retire versions: %w", err)
+		}
+
 		return nil
 	})
+	if err != nil {
+		result.ChunksInserted, result.ChunksReplaced = 0, 0
+		return result, err
+	}
+	if result.Status == "" {
+		result.Status = MergeMerged
+	}
+
+	// Input files the run produced nothing for are still pending: count
+	// the attempt, so they are retried with backoff and fail in the end
+	if result.Status == MergeMerged && fileIDs.String != "" {
+		if _, err := m.corpusDB.RecordFileFailure(ctx, "merger", strings.Split(fileIDs.String, ","), "no output"); err != nil {
+			fmt.Fprintf(os.Stderr, "record files without output: %v\n", err)
+		}
+	}
+	return result, nil
+}
+
+// recordFailure counts a failed merge against the input files of the run,
+// so they are retried with backoff. Output that failed validation would be
+// produced again by a retry: its files are failed for good. Runs that failed
+// themselves were counted by the worker that ran them: each failure has a
+// single owner.
+func (m *Merger) recordFailure(ctx context.Context, runDBPath string, mergeErr error) {
+	runDB, err := db.Open(db.DefaultConfig(runDBPath, db.DBTypeRun))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open failed run: %v\n", err)
+		return
+	}
+	var status string
+	var fileIDs sql.NullString
+	runDB.QueryRowContext(ctx,
+		"SELECT status, json_extract(config, '$.parameters.file_ids') FROM _run_meta LIMIT 1",
+	).Scan(&status, &fileIDs)
+	runDB.Close()
+
+	if status == "failed" || fileIDs.String == "" {
+		return
+	}
+	reason := fmt.Sprintf("merge %s: %v", filepath.Base(runDBPath), mergeErr)
+	ids := strings.Split(fileIDs.String, ",")
+	var invalid *ValidationError
+	if errors.As(mergeErr, &invalid) {
+		err = m.corpusDB.FailFiles(ctx, "merger", ids, reason)
+	} else {
+		_, err = m.corpusDB.RecordFileFailure(ctx, "merger", ids, reason)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record failure: %v\n", err)
+	}
+}
+
+// recordHistory records the run and its step executions in run_history and step_history.
+func (m *Merger) recordHistory(ctx context.Context, tx *sql.Tx, alias, mergeStatus string, rowsProduced any) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR REPLACE INTO run_history
+		(run_id, workflow_id, workflow_version, started_at, finished_at, status, worker_id, rows_produced, merge_status)
+		SELECT run_id, workflow_id, workflow_version, started_at, finished_at, status, worker_id, ?, ?
+		FROM %s._run_meta
+	`, alias), rowsProduced, mergeStatus)
+	if err != nil {
+		return fmt.Errorf("update run history: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR REPLACE INTO step_history
+		(run_id, step_order, step_name, duration_ms, rows_in, rows_out, delta_score, notes)
+		SELECT m.run_id, s.step_order, s.step_name, s.duration_ms, s.rows_in, s.rows_out, s.delta_score, s.notes
+		FROM %s._step_executions s, %s._run_meta m
+	`, alias, alias))
+	if err != nil {
+		return fmt.Errorf("update step history: %w", err)
+	}
+
+	return nil
+}
+
+// mergeSegments merges extracted segments from run output to corpus.
+func (m *Merger) mergeSegments(ctx context.Context, tx *sql.Tx, alias string) error {
+	// Check if table exists
+	var tableExists int
+	err := tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name='_output_segments'", alias),
+	).Scan(&tableExists)
+	if err != nil || tableExists == 0 {
+		return nil
+	}
+
+	// Runs made before segments carried their bounding box lack the columns
+	columns, err := queryStrings(ctx, tx, "SELECT name FROM pragma_table_info('_output_segments', ?)", alias)
+	if err != nil {
+		return err
+	}
+	bbox, confidence := "NULL", "NULL"
+	if slices.Contains(columns, "bbox") {
+		bbox = "bbox"
+	}
+	if slices.Contains(columns, "confidence") {
+		confidence = "confidence"
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR IGNORE INTO extracted_segments
+		(id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence)
+		SELECT id, file_id, extractor, extractor_version, segment_type, content, page, position, %s, %s
+		FROM %s._output_segments
+		WHERE file_id IN (SELECT id FROM raw_files)
+	`, bbox, confidence, alias))
+	if err != nil {
+		return err
+	}
+
+	// Files extracted but not chunked yet leave the processing queue
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE raw_files SET status = 'extracted'
+		WHERE status = 'pending' AND id IN (SELECT file_id FROM %s._output_segments)
+	`, alias))
+	return err
+}
+
+// mergeUnits merges parsed units from run output to corpus.
+// Units are inserted in production order so parents precede their children.
+func (m *Merger) mergeUnits(ctx context.Context, tx *sql.Tx, alias string) error {
+	// Check if table exists
+	var tableExists int
+	err := tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name='_output_units'", alias),
+	).Scan(&tableExists)
+	if err != nil || tableExists == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR IGNORE INTO parsed_units
+		(id, segment_id, unit_type, level, content, tokens, parent_id, position)
+		SELECT id, segment_id, unit_type, level, content, tokens, parent_id, position
+		FROM %s._output_units
+		WHERE segment_id IN (SELECT id FROM extracted_segments)
+		ORDER BY rowid
+	`, alias))
+	return err
 }
 
 // mergeChunks merges chunks from run output to corpus.
@@ -272,21 +721,44 @@ func (m *Merger) mergeChunks(ctx context.Context, tx *sql.Tx, alias, runID strin
 
 	result, err := tx.ExecContext(ctx, fmt.Sprintf(`
 		INSERT OR IGNORE INTO chunks
-		(id, file_id, unit_ids, content, token_count, chunk_type, overlap_prev, overlap_next, hash, position, parent_id, created_by_run)
+		(id, file_id, unit_ids, content, token_count, chunk_type, overlap_prev, overlap_next, hash, position, parent_id, created_by_run,
+		 collection, tags)
 		SELECT
-			id, file_id, unit_ids, content, token_count, chunk_type,
-			overlap_prev, overlap_next, hash, position, parent_id, '%s'
-		FROM %s._output
+			o.id, o.file_id, o.unit_ids, o.content, o.token_count, o.chunk_type,
+			o.overlap_prev, o.overlap_next, o.hash, o.position, o.parent_id, '%s',
+			r.collection, r.tags
+		FROM %s._output o
+		JOIN raw_files r ON r.id = o.file_id
 	`, runID, alias))
 	if err != nil {
 		return 0, err
 	}
 
-	return result.RowsAffected()
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the lineage of the chunks this run actually created
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR REPLACE INTO chunk_lineage (chunk_id, run_id, source_chain)
+		SELECT o.id, '%s', o._source_chain
+		FROM %s._output o
+		JOIN chunks c ON c.id = o.id AND c.created_by_run = '%s'
+		WHERE o._source_chain IS NOT NULL
+	`, runID, alias, runID))
+	if err != nil {
+		return 0, fmt.Errorf("merge lineage: %w", err)
+	}
+
+	return inserted, nil
 }
 
-// mergeFeatures merges chunk features from run output to corpus.
-func (m *Merger) mergeFeatures(ctx context.Context, tx *sql.Tx, alias string) error {
+// mergeFeatures merges chunk features from run output to corpus, for the
+// chunks the run created: chunks of other files or runs keep theirs.
+// conflict is the conflict clause of the merge policy: OR IGNORE keeps the
+// features chunks already have.
+func (m *Merger) mergeFeatures(ctx context.Context, tx *sql.Tx, alias, runID, conflict string) error {
 	// Check if table exists
 	var tableExists int
 	err := tx.QueryRowContext(ctx,
@@ -297,15 +769,19 @@ func (m *Merger) mergeFeatures(ctx context.Context, tx *sql.Tx, alias string) er
 	}
 
 	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
-		INSERT OR REPLACE INTO chunk_features (chunk_id, feature_name, feature_value, feature_meta)
+		INSERT %s INTO chunk_features (chunk_id, feature_name, feature_value, feature_meta)
 		SELECT chunk_id, feature_name, feature_value, feature_meta
 		FROM %s._output_features
-	`, alias))
+		WHERE chunk_id IN (SELECT id FROM chunks WHERE created_by_run = ?)
+	`, conflict, alias), runID)
 	return err
 }
 
-// mergeVectors merges chunk vectors from run output to corpus.
-func (m *Merger) mergeVectors(ctx context.Context, tx *sql.Tx, alias string) error {
+// mergeVectors merges chunk vectors from run output to corpus, for the
+// chunks the run created: chunks of other files or runs keep theirs.
+// conflict is the conflict clause of the merge policy: OR IGNORE keeps the
+// vectors chunks already have.
+func (m *Merger) mergeVectors(ctx context.Context, tx *sql.Tx, alias, runID, conflict string) error {
 	// Check if table exists
 	var tableExists int
 	err := tx.QueryRowContext(ctx,
@@ -316,10 +792,11 @@ func (m *Merger) mergeVectors(ctx context.Context, tx *sql.Tx, alias string) err
 	}
 
 	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
-		INSERT OR REPLACE INTO chunk_vectors (chunk_id, layer, vector, dimensions, model_version)
+		INSERT %s INTO chunk_vectors (chunk_id, layer, vector, dimensions, model_version)
 		SELECT chunk_id, layer, vector, dimensions, model_version
 		FROM %s._output_vectors
-	`, alias))
+		WHERE chunk_id IN (SELECT id FROM chunks WHERE created_by_run = ?)
+	`, conflict, alias), runID)
 	return err
 }
 
@@ -338,17 +815,19 @@ func (m *Merger) mergeRelations(ctx context.Context, tx *sql.Tx, alias, runID st
 		INSERT OR IGNORE INTO chunk_relations (from_chunk_id, to_chunk_id, relation_type, weight, created_by_run)
 		SELECT from_chunk_id, to_chunk_id, relation_type, weight, '%s'
 		FROM %s._output_relations
+		WHERE from_chunk_id IN (SELECT id FROM chunks) AND to_chunk_id IN (SELECT id FROM chunks)
 	`, runID, alias))
 	return err
 }
 
 // Status returns the merger status.
 type Status struct {
-	Running       bool      `json:"running"`
-	PendingCount  int       `json:"pending_count"`
-	DoneCount     int       `json:"done_count"`
-	FailedCount   int       `json:"failed_count"`
-	LastProcessed time.Time `json:"last_processed,omitempty"`
+	Running          bool      `json:"running"`
+	PendingCount     int       `json:"pending_count"`
+	DoneCount        int       `json:"done_count"`
+	FailedCount      int       `json:"failed_count"`
+	QuarantinedCount int       `json:"quarantined_count"`
+	LastProcessed    time.Time `json:"last_processed,omitempty"`
 }
 
 // Status returns current merger status.
@@ -384,6 +863,15 @@ func (m *Merger) Status() (*Status, error) {
 		}
 	}
 
+	// Count quarantined
+	if entries, err := os.ReadDir(m.quarantineDir); err == nil {
+		for _, e := range entries {
+			if strings.HasSuffix(e.Name(), ".db") {
+				status.QuarantinedCount++
+			}
+		}
+	}
+
 	return status, nil
 }
 
@@ -442,13 +930,28 @@ func (m *Merger) RetryFailed(ctx context.Context) (int, error) {
 	return retried, nil
 }
 
+// Merge outcomes of a run.
+const (
+	MergeMerged      = "merged"
+	MergeDuplicate   = "duplicate"   // merged before, nothing done
+	MergeSkipped     = "skipped"     // older than the output of its files, see PolicyReplaceIfNewer
+	MergeQuarantined = "quarantined" // output failed validation, see ValidationReport
+	MergeRunFailed   = "run-failed"  // the run itself failed, recorded in the history only
+	MergeFailed      = "failed"
+)
+
 // MergeResult holds the result of a merge operation.
 type MergeResult struct {
-	RunID          string    `json:"run_id"`
-	WorkflowID     string    `json:"workflow_id"`
-	ChunksInserted int64     `json:"chunks_inserted"`
+	Run            string        `json:"run"` // run database file name
+	RunID          string        `json:"run_id,omitempty"`
+	WorkflowID     string        `json:"workflow_id,omitempty"`
+	Status         string        `json:"status"`
+	Policy         string        `json:"policy,omitempty"`
+	ChunksInserted int64         `json:"chunks_inserted"`
+	ChunksReplaced int64         `json:"chunks_replaced"` // earlier chunks of the run's files deleted
 	Duration       time.Duration `json:"duration"`
-	Error          string    `json:"error,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	Report         string        `json:"report,omitempty"` // validation report of a quarantined run
 }
 
 // MarshalJSON implements json.Marshaler.
@@ -0,0 +1,104 @@
+package merger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"goraglite/internal/db"
+)
+
+// newTestMerger opens a corpus in a temporary data directory holding the
+// pending files fileIDs and returns a merger over it.
+func newTestMerger(t *testing.T, fileIDs ...string) (*Merger, string) {
+	t.Helper()
+	dir := t.TempDir()
+	corpusDB, err := db.OpenCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { corpusDB.Close() })
+
+	for _, id := range fileIDs {
+		if _, err := corpusDB.ExecContext(context.Background(), `
+			INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum)
+			VALUES (?1, '/' || ?1, 'text/markdown', 1, ?1, ?1)
+		`, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := New(corpusDB, DefaultConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, dir
+}
+
+// writeRun creates a completed run database of a workflow version over
+// fileIDs (comma-separated) in dir, fills it with stmts and returns its path.
+func writeRun(t *testing.T, dir, runID, workflowID string, version int, fileIDs string, stmts ...string) string {
+	t.Helper()
+	runDB, err := db.CreateRun(dir, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runDB.Close()
+
+	config := fmt.Sprintf(`{"parameters":{"file_ids":%q}}`, fileIDs)
+	stmts = append([]string{fmt.Sprintf(`
+		INSERT INTO _run_meta (run_id, workflow_id, workflow_version, status, finished_at, config)
+		VALUES ('%s', '%s', %d, 'completed', datetime('now'), '%s')
+	`, runID, workflowID, version, config)}, stmts...)
+	for _, stmt := range stmts {
+		if _, err := runDB.ExecContext(context.Background(), stmt); err != nil {
+			t.Fatalf("seed run %s: %v", runID, err)
+		}
+	}
+	return filepath.Join(dir, runID+".db")
+}
+
+// chunkRow returns an INSERT of a chunk of file f1 into a run output.
+func chunkRow(id, content string, position int) string {
+	return fmt.Sprintf(`
+		INSERT INTO _output (id, file_id, content, token_count, chunk_type, hash, position)
+		VALUES ('%s', 'f1', '%s', 1, 'semantic', '%s', %d)
+	`, id, content, id, position)
+}
+
+// TestMergeCountsFilesWithoutOutput checks that an input file a merged run
+// produced nothing for has the attempt counted instead of staying pending.
+func TestMergeCountsFilesWithoutOutput(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1", "f2")
+
+	result, err := m.ProcessOne(ctx, writeRun(t, dir, "run1", "wf", 1, "f1,f2", chunkRow("c1", "alpha", 0)))
+	if err != nil || result.Status != MergeMerged {
+		t.Fatalf("merge: %v, %v", result.Status, err)
+	}
+
+	for _, tc := range []struct {
+		id, status string
+		attempts   int
+	}{
+		{"f1", "vectorized", 0},
+		{"f2", "pending", 1},
+	} {
+		var status string
+		var attempts int
+		var lastError sql.NullString
+		if err := m.corpusDB.QueryRowContext(ctx,
+			"SELECT status, attempts, last_error FROM raw_files WHERE id = ?", tc.id,
+		).Scan(&status, &attempts, &lastError); err != nil {
+			t.Fatal(err)
+		}
+		if status != tc.status || attempts != tc.attempts {
+			t.Errorf("%s: status %s, %d attempts; want %s, %d", tc.id, status, attempts, tc.status, tc.attempts)
+		}
+		if tc.attempts > 0 && lastError.String != "no output" {
+			t.Errorf("%s: last error %q", tc.id, lastError.String)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package merger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// Merge policies: what a merge does with the earlier output of the files
+// of a run.
+const (
+	// PolicyAppend keeps the earlier output and only adds new rows: chunks
+	// already in the corpus keep their features and vectors.
+	PolicyAppend = "append"
+	// PolicyReplaceFile deletes everything earlier runs produced for the
+	// files of the run before merging it.
+	PolicyReplaceFile = "replace-file"
+	// PolicyReplaceIfNewer replaces like PolicyReplaceFile unless a newer
+	// version of the same workflow produced the earlier output, in which
+	// case the run is skipped.
+	PolicyReplaceIfNewer = "replace-if-newer-version"
+)
+
+// validPolicy reports whether policy is a merge policy.
+func validPolicy(policy string) bool {
+	return policy == PolicyAppend || policy == PolicyReplaceFile || policy == PolicyReplaceIfNewer
+}
+
+// Policy is the merge policy of a workflow.
+type Policy struct {
+	WorkflowID string    `json:"workflow_id"`
+	Policy     string    `json:"policy"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// DefaultPolicy returns the merge policy of workflows without their own.
+func (m *Merger) DefaultPolicy(ctx context.Context) string {
+	if policy, _ := m.corpusDB.GetConfig(ctx, "merge_policy"); validPolicy(policy) {
+		return policy
+	}
+	return PolicyAppend
+}
+
+// SetDefaultPolicy sets the merge policy of workflows without their own.
+func (m *Merger) SetDefaultPolicy(ctx context.Context, policy string) error {
+	if !validPolicy(policy) {
+		return fmt.Errorf("invalid merge policy %q (%s, %s or %s)", policy, PolicyAppend, PolicyReplaceFile, PolicyReplaceIfNewer)
+	}
+	if err := m.corpusDB.SetConfig(ctx, "merge_policy", policy); err != nil {
+		return err
+	}
+	m.audit(ctx, "set_merge_policy", "default", policy)
+	return nil
+}
+
+// ListPolicies returns the merge policies set for workflows.
+func (m *Merger) ListPolicies(ctx context.Context) ([]Policy, error) {
+	rows, err := m.corpusDB.QueryContext(ctx,
+		"SELECT workflow_id, policy, updated_at FROM merge_policies ORDER BY workflow_id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []Policy
+	for rows.Next() {
+		var p Policy
+		if err := rows.Scan(&p.WorkflowID, &p.Policy, db.Time(&p.UpdatedAt)); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// SetPolicy sets the merge policy of a workflow. It applies to the runs
+// merged from then on, queued ones included.
+func (m *Merger) SetPolicy(ctx context.Context, workflowID, policy string) error {
+	if !validPolicy(policy) {
+		return fmt.Errorf("invalid merge policy %q (%s, %s or %s)", policy, PolicyAppend, PolicyReplaceFile, PolicyReplaceIfNewer)
+	}
+	_, err := m.corpusDB.ExecContext(ctx, `
+		INSERT INTO merge_policies (workflow_id, policy) VALUES (?, ?)
+		ON CONFLICT(workflow_id) DO UPDATE SET policy = excluded.policy, updated_at = datetime('now')
+	`, workflowID, policy)
+	if err != nil {
+		return fmt.Errorf("set merge policy of %s: %w", workflowID, err)
+	}
+	m.audit(ctx, "set_merge_policy", workflowID, policy)
+	return nil
+}
+
+// DeletePolicy removes the merge policy of a workflow, which falls back to
+// the default one.
+func (m *Merger) DeletePolicy(ctx context.Context, workflowID string) error {
+	res, err := m.corpusDB.ExecContext(ctx, "DELETE FROM merge_policies WHERE workflow_id = ?", workflowID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("workflow %s has no merge policy", workflowID)
+	}
+	m.audit(ctx, "delete_merge_policy", workflowID, "")
+	return nil
+}
+
+// audit records a merge policy change.
+func (m *Merger) audit(ctx context.Context, action, target, policy string) {
+	m.corpusDB.ExecContext(ctx, `
+		INSERT INTO audit_log (actor, action, target, details)
+		VALUES ('merger', ?, ?, json_object('policy', NULLIF(?, '')))
+	`, action, target, policy)
+}
+
+// policyOf returns the merge policy of a workflow. It reads within tx: the
+// corpus has a single connection.
+func policyOf(ctx context.Context, tx *sql.Tx, workflowID string) (string, error) {
+	var policy string
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(
+			(SELECT policy FROM merge_policies WHERE workflow_id = ?),
+			(SELECT value FROM config WHERE key = 'merge_policy'),
+			''
+		)
+	`, workflowID).Scan(&policy)
+	if err != nil || !validPolicy(policy) {
+		return PolicyAppend, err
+	}
+	return policy, nil
+}
+
+// runFiles returns the files of the attached run as a JSON array: its input
+// files (file_ids parameter or _input snapshot) and those of its output,
+// restricted to files still in the corpus.
+func runFiles(ctx context.Context, tx *sql.Tx, alias string) (string, error) {
+	var input sql.NullString
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT json_extract(config, '$.parameters.file_ids') FROM %s._run_meta LIMIT 1", alias,
+	)).Scan(&input)
+	if err != nil {
+		return "", fmt.Errorf("read run input: %w", err)
+	}
+	var ids []string
+	if input.String != "" {
+		ids = strings.Split(input.String, ",")
+	}
+	list, _ := json.Marshal(ids)
+
+	// Runs without file_ids took every pending file: their _input snapshot
+	query := `SELECT value FROM json_each(?)`
+	for table, column := range map[string]string{"_input": "id", "_output": "file_id", "_output_segments": "file_id"} {
+		var exists int
+		tx.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name=?", alias,
+		), table).Scan(&exists)
+		if exists > 0 {
+			query += fmt.Sprintf(" UNION SELECT %s FROM %s.%s", column, alias, table)
+		}
+	}
+
+	files, err := queryStrings(ctx, tx, `
+		SELECT json_group_array(id) FROM raw_files WHERE id IN (`+query+`)
+	`, string(list))
+	if err != nil {
+		return "", fmt.Errorf("list run files: %w", err)
+	}
+	return files[0], nil
+}
+
+// newerOutput returns the number of chunks of a set of files (a JSON
+// array) produced by a newer version of a workflow.
+func newerOutput(ctx context.Context, tx *sql.Tx, files, workflowID string, version int) (int64, error) {
+	var n int64
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM chunks c
+		JOIN run_history h ON h.run_id = c.created_by_run
+		WHERE c.file_id IN (SELECT value FROM json_each(?))
+			AND h.workflow_id = ? AND h.workflow_version > ?
+	`, files, workflowID, version).Scan(&n)
+	return n, err
+}
+
+// replaceOutput deletes what runs other than runID produced for a set of
+// files (a JSON array): their chunks with features, vectors, relations and
+// lineage, and, for the files the run extracted again, their segments and
+// units. It returns the number of chunks deleted.
+func replaceOutput(ctx context.Context, tx *sql.Tx, alias, runID, files string) (int64, error) {
+	// References without cascade, as for a purge
+	const replaced = `SELECT id FROM chunks
+		WHERE file_id IN (SELECT value FROM json_each(?1)) AND created_by_run IS NOT ?2`
+	for _, stmt := range []string{
+		`UPDATE feedback_log SET result_chunk_id = NULL WHERE result_chunk_id IN (` + replaced + `)`,
+		`UPDATE chunks SET parent_id = NULL WHERE parent_id IN (` + replaced + `)
+			AND (file_id NOT IN (SELECT value FROM json_each(?1)) OR created_by_run IS ?2)`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, files, runID); err != nil {
+			return 0, fmt.Errorf("replace references: %w", err)
+		}
+	}
+
+	// Features, vectors, relations and lineage follow by cascade
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM chunks WHERE file_id IN (SELECT value FROM json_each(?1)) AND created_by_run IS NOT ?2
+	`, files, runID)
+	if err != nil {
+		return 0, fmt.Errorf("replace chunks: %w", err)
+	}
+	chunks, _ := res.RowsAffected()
+
+	var extracted int
+	tx.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s.sqlite_master WHERE type='table' AND name='_output_segments'", alias,
+	)).Scan(&extracted)
+	if extracted == 0 {
+		return chunks, nil
+	}
+
+	// Segments are replaced only for the files the run extracted again
+	segments := fmt.Sprintf(`SELECT id FROM extracted_segments
+		WHERE file_id IN (SELECT value FROM json_each(?1))
+			AND file_id IN (SELECT file_id FROM %s._output_segments)`, alias)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE parsed_units SET parent_id = NULL
+		WHERE parent_id IN (SELECT id FROM parsed_units WHERE segment_id IN (`+segments+`))
+			AND segment_id NOT IN (`+segments+`)
+	`, files)
+	if err != nil {
+		return 0, fmt.Errorf("replace unit references: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM extracted_segments WHERE id IN (`+segments+`)`, files); err != nil {
+		return 0, fmt.Errorf("replace segments: %w", err)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,138 @@
+package merger
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMergePolicies merges successive runs of a workflow over the same file
+// under each merge policy.
+func TestMergePolicies(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1")
+
+	chunks := func() []string {
+		t.Helper()
+		var list string
+		var ids []string
+		if err := m.corpusDB.QueryRowContext(ctx,
+			"SELECT COALESCE(group_concat(id, ','), '') FROM (SELECT id FROM chunks ORDER BY id)",
+		).Scan(&list); err != nil {
+			t.Fatal(err)
+		}
+		if list != "" {
+			ids = strings.Split(list, ",")
+		}
+		return ids
+	}
+	merge := func(path, wantStatus string, wantChunks ...string) *MergeResult {
+		t.Helper()
+		result, err := m.ProcessOne(ctx, path)
+		if err != nil {
+			t.Fatalf("merge %s: %v", path, err)
+		}
+		if result.Status != wantStatus {
+			t.Errorf("merge %s: status %s, want %s", result.RunID, result.Status, wantStatus)
+		}
+		if got := chunks(); !reflect.DeepEqual(got, wantChunks) {
+			t.Errorf("after %s: chunks %v, want %v", result.RunID, got, wantChunks)
+		}
+		return result
+	}
+
+	if policy := m.DefaultPolicy(ctx); policy != PolicyAppend {
+		t.Fatalf("default policy = %s", policy)
+	}
+	if err := m.SetPolicy(ctx, "wf", "overwrite"); err == nil {
+		t.Error("invalid policy accepted")
+	}
+
+	// Append keeps chunks already in the corpus and their vectors
+	merge(writeRun(t, dir, "run1", "wf", 1, "f1",
+		chunkRow("c1", "alpha", 0), chunkRow("c2", "beta", 1),
+		`INSERT INTO _output_vectors VALUES ('c1', 'structure', zeroblob(12), 3, 'v1')`,
+	), MergeMerged, "c1", "c2")
+	merge(writeRun(t, dir, "run2", "wf", 1, "f1",
+		chunkRow("c1", "alpha", 0), chunkRow("c3", "gamma", 1),
+		`INSERT INTO _output_vectors VALUES ('c1', 'structure', randomblob(12), 3, 'v2')`,
+	), MergeMerged, "c1", "c2", "c3")
+	var model string
+	if err := m.corpusDB.QueryRowContext(ctx, "SELECT model_version FROM chunk_vectors WHERE chunk_id = 'c1'").Scan(&model); err != nil || model != "v1" {
+		t.Errorf("vector of c1 from %q, %v; want v1 kept", model, err)
+	}
+
+	// Replace drops what earlier runs produced for the file
+	if err := m.SetPolicy(ctx, "wf", PolicyReplaceFile); err != nil {
+		t.Fatal(err)
+	}
+	result := merge(writeRun(t, dir, "run3", "wf", 1, "f1", chunkRow("c4", "delta", 0)), MergeMerged, "c4")
+	if result.Policy != PolicyReplaceFile || result.ChunksReplaced != 3 {
+		t.Errorf("run3: policy %s, %d chunks replaced", result.Policy, result.ChunksReplaced)
+	}
+
+	// Replace if newer: a newer version replaces, an older one is skipped
+	if err := m.SetPolicy(ctx, "wf", PolicyReplaceIfNewer); err != nil {
+		t.Fatal(err)
+	}
+	merge(writeRun(t, dir, "run4", "wf", 2, "f1", chunkRow("c5", "epsilon", 0)), MergeMerged, "c5")
+	merge(writeRun(t, dir, "run5", "wf", 1, "f1", chunkRow("c6", "zeta", 0)), MergeSkipped, "c5")
+
+	var status string
+	if err := m.corpusDB.QueryRowContext(ctx, "SELECT merge_status FROM run_history WHERE run_id = 'run5'").Scan(&status); err != nil || status != "skipped" {
+		t.Errorf("run5 history = %q, %v", status, err)
+	}
+
+	// Without its own policy a workflow falls back to the default one
+	if err := m.DeletePolicy(ctx, "wf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDefaultPolicy(ctx, PolicyReplaceFile); err != nil {
+		t.Fatal(err)
+	}
+	merge(writeRun(t, dir, "run6", "wf", 1, "f1", chunkRow("c7", "eta", 0)), MergeMerged, "c7")
+}
+
+// TestReplaceSharedChunkID checks that a run merged under a replace policy
+// cannot overwrite the vectors and features of a chunk another file owns.
+func TestReplaceSharedChunkID(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1", "f2")
+	if err := m.SetPolicy(ctx, "wf", PolicyReplaceFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.ProcessOne(ctx, writeRun(t, dir, "run1", "wf", 1, "f1",
+		chunkRow("c1", "alpha", 0),
+		`INSERT INTO _output_vectors VALUES ('c1', 'structure', zeroblob(12), 3, 'v1')`,
+		`INSERT INTO _output_features VALUES ('c1', 'line_count', 1, NULL)`,
+	)); err != nil {
+		t.Fatal(err)
+	}
+	// f2 produces a chunk with the id of the chunk of f1
+	result, err := m.ProcessOne(ctx, writeRun(t, dir, "run2", "wf", 1, "f2",
+		`INSERT INTO _output (id, file_id, content, token_count, chunk_type, hash, position)
+		 VALUES ('c1', 'f2', 'alpha', 1, 'semantic', 'c1', 0)`,
+		`INSERT INTO _output_vectors VALUES ('c1', 'structure', randomblob(12), 3, 'v2')`,
+		`INSERT INTO _output_features VALUES ('c1', 'line_count', 9, NULL)`,
+	))
+	if err != nil || result.Status != MergeMerged {
+		t.Fatalf("merge run2: %v, %v", result.Status, err)
+	}
+
+	var fileID, model string
+	var lines float64
+	if err := m.corpusDB.QueryRowContext(ctx, `
+		SELECT c.file_id, v.model_version, f.feature_value
+		FROM chunks c
+		JOIN chunk_vectors v ON v.chunk_id = c.id
+		JOIN chunk_features f ON f.chunk_id = c.id AND f.feature_name = 'line_count'
+		WHERE c.id = 'c1'
+	`).Scan(&fileID, &model, &lines); err != nil {
+		t.Fatal(err)
+	}
+	if fileID != "f1" || model != "v1" || lines != 1 {
+		t.Errorf("c1: file %s, vector %s, line_count %v; want f1, v1, 1", fileID, model, lines)
+	}
+}
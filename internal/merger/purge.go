@@ -0,0 +1,205 @@
+package merger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// PurgeResult reports what a purge deleted.
+type PurgeResult struct {
+	Files     []string `json:"files"`     // purged file ids, archive members included
+	Chunks    int64    `json:"chunks"`    // chunks deleted with their features, vectors and relations
+	Documents int64    `json:"documents"` // documents left without any version
+	Storage   int      `json:"storage"`   // storage objects removed
+}
+
+// purgeMembersSQL returns the members of the archives of a purge set (a
+// JSON array) that are not in it yet. A member that is also a version of
+// a document outside these archives, ingested on its own, is kept.
+const purgeMembersSQL = `
+	SELECT DISTINCT am.file_id FROM archive_members am
+	WHERE am.archive_id IN (SELECT value FROM json_each(?1))
+		AND am.file_id NOT IN (SELECT value FROM json_each(?1))
+		AND NOT EXISTS (
+			SELECT 1 FROM document_versions v
+			WHERE v.file_id = am.file_id AND NOT EXISTS (
+				SELECT 1 FROM archive_members h
+				WHERE h.document_id = v.document_id AND h.file_id = v.file_id
+					AND h.archive_id IN (SELECT value FROM json_each(?1))
+			)
+		)
+`
+
+// Purge deletes files and everything derived from them: segments, units,
+// chunks with their features, vectors and relations, and the document
+// versions they back. Members of purged archives are purged too. Storage
+// objects whose hash no remaining document version or file references are
+// removed once the deletion is committed. Runs merged later skip the rows
+// of purged files. Callers hold the data directory lock.
+func (m *Merger) Purge(ctx context.Context, fileIDs []string) (*PurgeResult, error) {
+	requested, _ := json.Marshal(fileIDs)
+
+	result := &PurgeResult{}
+	storage := make(map[string]string) // file id (content hash) -> storage object
+	err := m.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		for _, id := range fileIDs {
+			var path string
+			err := tx.QueryRowContext(ctx, "SELECT external_path FROM raw_files WHERE id = ?", id).Scan(&path)
+			if err == sql.ErrNoRows || slices.Contains(result.Files, id) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			result.Files = append(result.Files, id)
+			storage[id] = path
+		}
+		if len(result.Files) == 0 {
+			return nil
+		}
+
+		// Members of purged archives, down to nested archives
+		for {
+			ids, _ := json.Marshal(result.Files)
+			members, err := queryStrings(ctx, tx, purgeMembersSQL, string(ids))
+			if err != nil {
+				return fmt.Errorf("purge members: %w", err)
+			}
+			if len(members) == 0 {
+				break
+			}
+			for _, id := range members {
+				var path string
+				if err := tx.QueryRowContext(ctx, "SELECT external_path FROM raw_files WHERE id = ?", id).Scan(&path); err != nil {
+					return err
+				}
+				result.Files = append(result.Files, id)
+				storage[id] = path
+			}
+		}
+
+		ids, _ := json.Marshal(result.Files)
+		set := string(ids)
+
+		// Audited first, while the chunks can still be counted
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO audit_log (actor, action, target, details)
+			SELECT 'merger', 'purge', p.value, json_object(
+				'chunks', (SELECT COUNT(*) FROM chunks c WHERE c.file_id = p.value),
+				'archive_member', p.value NOT IN (SELECT value FROM json_each(?))
+			)
+			FROM json_each(?) p
+		`, string(requested), set)
+		if err != nil {
+			return fmt.Errorf("audit purge: %w", err)
+		}
+
+		// References without cascade: feedback keeps its query, other
+		// files' chunks and units lose a parent from the purged files
+		const purgedChunks = `SELECT id FROM chunks WHERE file_id IN (SELECT value FROM json_each(?))`
+		for _, stmt := range []string{
+			`UPDATE feedback_log SET result_chunk_id = NULL WHERE result_chunk_id IN (` + purgedChunks + `)`,
+			`UPDATE chunks SET parent_id = NULL
+			WHERE parent_id IN (` + purgedChunks + `) AND file_id NOT IN (SELECT value FROM json_each(?1))`,
+			`UPDATE parsed_units SET parent_id = NULL
+			WHERE parent_id IN (
+				SELECT u.id FROM parsed_units u JOIN extracted_segments s ON s.id = u.segment_id
+				WHERE s.file_id IN (SELECT value FROM json_each(?1))
+			)
+			AND segment_id NOT IN (SELECT id FROM extracted_segments WHERE file_id IN (SELECT value FROM json_each(?1)))`,
+			`UPDATE raw_files SET superseded_by = NULL WHERE superseded_by IN (SELECT value FROM json_each(?))`,
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, set); err != nil {
+				return fmt.Errorf("purge references: %w", err)
+			}
+		}
+
+		// Chunks are deleted first so the FTS triggers see them go; the
+		// rest follows raw_files by cascade
+		res, err := tx.ExecContext(ctx, `DELETE FROM chunks WHERE file_id IN (SELECT value FROM json_each(?))`, set)
+		if err != nil {
+			return fmt.Errorf("purge chunks: %w", err)
+		}
+		result.Chunks, _ = res.RowsAffected()
+
+		// Member documents of purged archives lose the versions of kept members
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM document_versions WHERE EXISTS (
+				SELECT 1 FROM archive_members am
+				WHERE am.document_id = document_versions.document_id AND am.file_id = document_versions.file_id
+					AND am.archive_id IN (SELECT value FROM json_each(?))
+			)
+		`, set)
+		if err != nil {
+			return fmt.Errorf("purge member versions: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM raw_files WHERE id IN (SELECT value FROM json_each(?))`, set); err != nil {
+			return fmt.Errorf("purge files: %w", err)
+		}
+
+		res, err = tx.ExecContext(ctx, `
+			DELETE FROM documents
+			WHERE NOT EXISTS (SELECT 1 FROM document_versions v WHERE v.document_id = documents.id)
+		`)
+		if err != nil {
+			return fmt.Errorf("purge documents: %w", err)
+		}
+		result.Documents, _ = res.RowsAffected()
+
+		if _, err := m.applyTombstones(ctx, tx); err != nil {
+			return fmt.Errorf("retire versions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Storage is content-addressed: an object stays while a document
+	// version or a file still points at its hash, such as content ingested
+	// again since the deletion
+	for _, id := range result.Files {
+		path := storage[id]
+		var refs int
+		if err := m.corpusDB.QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM document_versions WHERE file_id = ?1)
+				+ (SELECT COUNT(*) FROM raw_files WHERE id = ?1 OR external_path = ?2)
+		`, id, path).Scan(&refs); err != nil {
+			return result, err
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			result.Storage++
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return result, fmt.Errorf("remove %s: %w", path, err)
+		}
+	}
+	return result, nil
+}
+
+// queryStrings returns the single string column of a query.
+func queryStrings(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
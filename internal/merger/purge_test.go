@@ -0,0 +1,70 @@
+package merger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPurge purges a file whose storage object another file shares: the
+// chunks and their FTS rows go, the object stays until its last file goes.
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1", "f2")
+
+	object := filepath.Join(dir, "object")
+	if err := os.WriteFile(object, []byte("alpha"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.corpusDB.ExecContext(ctx, "UPDATE raw_files SET external_path = ?", object); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ProcessOne(ctx, writeRun(t, dir, "run1", "wf", 1, "f1",
+		chunkRow("c1", "alpha", 0), chunkRow("c2", "beta", 1),
+		`INSERT INTO _output_vectors VALUES ('c1', 'structure', zeroblob(12), 3, 'v1')`,
+	)); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(query string) int {
+		t.Helper()
+		var n int
+		if err := m.corpusDB.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM chunks_fts WHERE chunks_fts MATCH 'alpha OR beta'"); n != 2 {
+		t.Fatalf("%d FTS rows before the purge, want 2", n)
+	}
+
+	result, err := m.Purge(ctx, []string{"f1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Chunks != 2 || result.Storage != 0 {
+		t.Errorf("purge f1: %d chunks, %d storage objects; want 2, 0", result.Chunks, result.Storage)
+	}
+	for query, want := range map[string]int{
+		"SELECT COUNT(*) FROM raw_files WHERE id = 'f1'":                         0,
+		"SELECT COUNT(*) FROM chunks":                                            0,
+		"SELECT COUNT(*) FROM chunk_vectors":                                     0,
+		"SELECT COUNT(*) FROM chunks_fts WHERE chunks_fts MATCH 'alpha OR beta'": 0,
+	} {
+		if n := count(query); n != want {
+			t.Errorf("%s = %d, want %d", query, n, want)
+		}
+	}
+	if _, err := os.Stat(object); err != nil {
+		t.Errorf("storage object shared with f2 removed: %v", err)
+	}
+
+	// The last file of the object takes it along
+	if result, err = m.Purge(ctx, []string{"f2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(object); !os.IsNotExist(err) || result.Storage != 1 {
+		t.Errorf("storage object of the last file kept: %v, %d removed", err, result.Storage)
+	}
+}
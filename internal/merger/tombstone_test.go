@@ -0,0 +1,45 @@
+package merger
+
+import (
+	"context"
+	"testing"
+)
+
+// TestTombstonesOnRequest checks that an idle batch leaves the corpus alone
+// and that a requested batch tombstones the chunks of a deleted file.
+func TestTombstonesOnRequest(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1")
+
+	if _, err := m.ProcessOne(ctx, writeRun(t, dir, "run1", "wf", 1, "f1", chunkRow("c1", "alpha", 0))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.corpusDB.ExecContext(ctx, "UPDATE raw_files SET deleted_at = datetime('now') WHERE id = 'f1'"); err != nil {
+		t.Fatal(err)
+	}
+	tombstoned := func() bool {
+		t.Helper()
+		var n int
+		if err := m.corpusDB.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM chunks WHERE id = 'c1' AND tombstoned_at IS NOT NULL",
+		).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n == 1
+	}
+
+	if err := m.processBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tombstoned() {
+		t.Error("idle batch applied tombstones")
+	}
+
+	m.RequestTombstones()
+	if err := m.processBatch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !tombstoned() {
+		t.Error("requested batch left the chunk of the deleted file live")
+	}
+}
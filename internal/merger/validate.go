@@ -0,0 +1,238 @@
+package merger
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// outputColumns are the columns the merge reads from each output table of
+// a run. Tables a run does not have are not merged.
+var outputColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"_output_segments", []string{"id", "file_id", "extractor", "extractor_version", "segment_type", "content", "page", "position"}},
+	{"_output_units", []string{"id", "segment_id", "unit_type", "level", "content", "tokens", "parent_id", "position"}},
+	{"_output", []string{"id", "file_id", "unit_ids", "content", "token_count", "chunk_type", "overlap_prev", "overlap_next",
+		"hash", "position", "parent_id", "_source_chain"}},
+	{"_output_features", []string{"chunk_id", "feature_name", "feature_value", "feature_meta"}},
+	{"_output_vectors", []string{"chunk_id", "layer", "vector", "dimensions", "model_version"}},
+	{"_output_relations", []string{"from_chunk_id", "to_chunk_id", "relation_type", "weight"}},
+}
+
+// Allowed values, as the CHECK constraints of corpus.sql. Rows breaking
+// them would be dropped silently by INSERT OR IGNORE.
+const (
+	segmentTypes  = `('text', 'table', 'image_ocr', 'metadata', 'code')`
+	unitTypes     = `('paragraph', 'heading', 'list_item', 'cell', 'code_block', 'sentence')`
+	chunkTypes    = `('semantic', 'fixed_window', 'sentence', 'paragraph')`
+	vectorLayers  = `('structure', 'lexical', 'contextual', 'blend')`
+	relationTypes = `('references', 'follows', 'parent_of', 'similar_to', 'calls', 'imports')`
+)
+
+// maxSamples is the number of offending rows listed per problem.
+const maxSamples = 5
+
+// ValidationProblem is a check a run output failed.
+type ValidationProblem struct {
+	Check   string   `json:"check"`
+	Table   string   `json:"table"`
+	Rows    int      `json:"rows"`              // offending rows
+	Samples []string `json:"samples,omitempty"` // ids of the first ones
+	Detail  string   `json:"detail,omitempty"`
+}
+
+// ValidationReport is the result of the validation of a run output.
+type ValidationReport struct {
+	Run        string              `json:"run"` // run database file name
+	RunID      string              `json:"run_id"`
+	WorkflowID string              `json:"workflow_id"`
+	CheckedAt  time.Time           `json:"checked_at"`
+	Problems   []ValidationProblem `json:"problems"`
+}
+
+// ValidationError is returned for a run whose output failed validation.
+type ValidationError struct {
+	Report *ValidationReport
+}
+
+func (e *ValidationError) Error() string {
+	checks := make([]string, 0, len(e.Report.Problems))
+	for _, p := range e.Report.Problems {
+		check := p.Table + " " + p.Check
+		if p.Rows > 0 {
+			check += fmt.Sprintf(" (%d)", p.Rows)
+		}
+		checks = append(checks, check)
+	}
+	return fmt.Sprintf("invalid output: %s", strings.Join(checks, ", "))
+}
+
+// validateRun checks the output tables of the attached run before they are
+// merged: the columns the merge reads, the references of features,
+// vectors, relations and units to rows of the run or the corpus, the
+// allowed types, bounding boxes and confidences of segments, vector
+// dimensions consistent per layer, with the vectorization configs (a JSON
+// array, see vectorConfigs) and with the vectors of the corpus, and empty or
+// duplicate chunks. It returns a *ValidationError when a check fails.
+func validateRun(ctx context.Context, tx *sql.Tx, alias, runID, workflowID, configs string) error {
+	report := &ValidationReport{RunID: runID, WorkflowID: workflowID, CheckedAt: time.Now().UTC()}
+
+	// Tables present with the columns the merge reads
+	valid := make(map[string]bool)
+	present := make(map[string][]string)
+	for _, t := range outputColumns {
+		columns, err := queryStrings(ctx, tx, "SELECT name FROM pragma_table_info(?, ?)", t.table, alias)
+		if err != nil {
+			return fmt.Errorf("read columns of %s: %w", t.table, err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		present[t.table] = columns
+		var missing []string
+		for _, c := range t.columns {
+			if !slices.Contains(columns, c) {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) > 0 {
+			report.Problems = append(report.Problems, ValidationProblem{
+				Check: "schema", Table: t.table, Detail: "missing columns " + strings.Join(missing, ", "),
+			})
+			continue
+		}
+		valid[t.table] = true
+	}
+
+	// Rows may reference rows of the run as well as of the corpus
+	chunkIDs, segmentIDs := "SELECT id FROM chunks", "SELECT id FROM extracted_segments"
+	if valid["_output"] {
+		chunkIDs += fmt.Sprintf(" UNION SELECT id FROM %s._output", alias)
+	}
+	if valid["_output_segments"] {
+		segmentIDs += fmt.Sprintf(" UNION SELECT id FROM %s._output_segments", alias)
+	}
+
+	type check struct {
+		check, table, query string
+	}
+	checks := []check{
+		{"duplicate_id", "_output_segments", `SELECT id FROM {t} GROUP BY id HAVING COUNT(*) > 1`},
+		{"segment_type", "_output_segments", `SELECT id FROM {t} WHERE segment_type IS NULL OR segment_type NOT IN ` + segmentTypes},
+
+		{"duplicate_id", "_output_units", `SELECT id FROM {t} GROUP BY id HAVING COUNT(*) > 1`},
+		{"unit_type", "_output_units", `SELECT id FROM {t} WHERE unit_type IS NULL OR unit_type NOT IN ` + unitTypes},
+		{"segment_ref", "_output_units", `SELECT id FROM {t} WHERE segment_id NOT IN (` + segmentIDs + `)`},
+
+		{"duplicate_id", "_output", `SELECT id FROM {t} GROUP BY id HAVING COUNT(*) > 1`},
+		{"empty_content", "_output", `SELECT id FROM {t} WHERE content IS NULL OR trim(content) = ''`},
+		{"duplicate_content", "_output", `SELECT MIN(id) FROM {t} WHERE trim(content) != '' GROUP BY file_id, content HAVING COUNT(*) > 1`},
+		{"chunk_type", "_output", `SELECT id FROM {t} WHERE chunk_type IS NULL OR chunk_type NOT IN ` + chunkTypes},
+		{"parent_ref", "_output", `SELECT id FROM {t} WHERE parent_id IS NOT NULL AND parent_id NOT IN (` + chunkIDs + `)`},
+
+		{"chunk_ref", "_output_features", `SELECT DISTINCT chunk_id FROM {t} WHERE chunk_id NOT IN (` + chunkIDs + `)`},
+
+		{"chunk_ref", "_output_vectors", `SELECT DISTINCT chunk_id FROM {t} WHERE chunk_id NOT IN (` + chunkIDs + `)`},
+		{"layer", "_output_vectors", `SELECT DISTINCT chunk_id FROM {t} WHERE layer IS NULL OR layer NOT IN ` + vectorLayers},
+		{"dimensions", "_output_vectors", `SELECT layer FROM {t} GROUP BY layer HAVING COUNT(DISTINCT dimensions) > 1`},
+		{"config_dimensions", "_output_vectors", `SELECT DISTINCT v.chunk_id FROM {t} v JOIN json_each(:configs) c
+			ON json_extract(c.value, '$.layer') = v.layer AND json_extract(c.value, '$.model_version') = v.model_version
+			WHERE json_extract(c.value, '$.dimensions') != v.dimensions`},
+		{"corpus_dimensions", "_output_vectors", `SELECT v.layer FROM (SELECT DISTINCT layer, dimensions FROM {t}) v
+			WHERE EXISTS (SELECT 1 FROM chunk_vectors cv WHERE cv.layer = v.layer AND cv.dimensions != v.dimensions)`},
+		{"vector_size", "_output_vectors", `SELECT chunk_id FROM {t} WHERE length(vector) != 4 * dimensions`},
+
+		{"chunk_ref", "_output_relations", `SELECT from_chunk_id || ' -> ' || to_chunk_id FROM {t}
+			WHERE from_chunk_id NOT IN (` + chunkIDs + `) OR to_chunk_id NOT IN (` + chunkIDs + `)`},
+		{"relation_type", "_output_relations", `SELECT from_chunk_id || ' -> ' || to_chunk_id FROM {t}
+			WHERE relation_type IS NULL OR relation_type NOT IN ` + relationTypes},
+		{"weight", "_output_relations", `SELECT from_chunk_id || ' -> ' || to_chunk_id FROM {t}
+			WHERE weight < 0 OR weight > 1`},
+	}
+	// Segments of runs made before they carried a bounding box have neither
+	if slices.Contains(present["_output_segments"], "bbox") {
+		checks = append(checks, check{"bbox", "_output_segments", `SELECT id FROM {t} WHERE bbox IS NOT NULL AND json_valid(bbox) = 0`})
+	}
+	if slices.Contains(present["_output_segments"], "confidence") {
+		checks = append(checks, check{"confidence", "_output_segments", `SELECT id FROM {t} WHERE confidence < 0 OR confidence > 1`})
+	}
+
+	for _, c := range checks {
+		if !valid[c.table] {
+			continue
+		}
+		query := strings.ReplaceAll(c.query, "{t}", alias+"."+c.table)
+		rows, err := queryStrings(ctx, tx, query, sql.Named("configs", configs))
+		if err != nil {
+			return fmt.Errorf("check %s of %s: %w", c.check, c.table, err)
+		}
+		if len(rows) > 0 {
+			report.Problems = append(report.Problems, ValidationProblem{
+				Check: c.check, Table: c.table, Rows: len(rows), Samples: rows[:min(len(rows), maxSamples)],
+			})
+		}
+	}
+
+	if len(report.Problems) > 0 {
+		return &ValidationError{Report: report}
+	}
+	return nil
+}
+
+// vectorConfigs returns the layer, model version and dimensions of the
+// vectorization configs as a JSON array, empty without a workflows
+// database.
+func (m *Merger) vectorConfigs(ctx context.Context) (string, error) {
+	if m.workflowsPath == "" {
+		return "[]", nil
+	}
+	if _, err := os.Stat(m.workflowsPath); errors.Is(err, os.ErrNotExist) {
+		return "[]", nil
+	}
+	workflowsDB, err := db.Open(db.DefaultConfig(m.workflowsPath, db.DBTypeWorkflows))
+	if err != nil {
+		return "", err
+	}
+	defer workflowsDB.Close()
+
+	var configs string
+	err = workflowsDB.QueryRowContext(ctx, `
+		SELECT json_group_array(json_object('layer', layer, 'model_version', model_version, 'dimensions', dimensions))
+		FROM vectorization_configs
+	`).Scan(&configs)
+	return configs, err
+}
+
+// quarantineRun moves a run whose output failed validation to the
+// quarantine directory with its report, as <run>.json, and returns the
+// path of the report.
+func (m *Merger) quarantineRun(runDBPath string, report *ValidationReport) (string, error) {
+	report.Run = filepath.Base(runDBPath)
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return "", err
+	}
+	reportPath := filepath.Join(m.quarantineDir, strings.TrimSuffix(report.Run, ".db")+".json")
+	if err := os.WriteFile(reportPath, data.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(runDBPath, filepath.Join(m.quarantineDir, report.Run)); err != nil {
+		return reportPath, err
+	}
+	return reportPath, nil
+}
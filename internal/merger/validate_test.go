@@ -0,0 +1,134 @@
+package merger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goraglite/internal/db"
+)
+
+// TestValidateRun merges runs each breaking one check and expects the
+// merge to fail with that check.
+func TestValidateRun(t *testing.T) {
+	ctx := context.Background()
+	m, dir := newTestMerger(t, "f1")
+
+	// The corpus holds structure vectors of 3 dimensions; the configs
+	// declare 8 dimensions for lexical vectors of model lex-v1
+	workflowsDB, err := db.OpenWorkflows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = workflowsDB.ExecContext(ctx, `
+		INSERT INTO vectorization_configs (id, name, layer, dimensions, algorithm, model_version)
+		VALUES ('lex', 'Lexical', 'lexical', 8, 'tfidf', 'lex-v1')
+	`)
+	workflowsDB.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ProcessOne(ctx, writeRun(t, dir, "seed", "wf", 1, "f1",
+		chunkRow("c0", "seed", 0),
+		`INSERT INTO _output_vectors VALUES ('c0', 'structure', zeroblob(12), 3, 'st-v1')`,
+	)); err != nil {
+		t.Fatalf("seed corpus: %v", err)
+	}
+
+	for _, tt := range []struct {
+		check string
+		stmts []string
+	}{
+		{"schema", []string{`DROP TABLE _output`, `CREATE TABLE _output (id TEXT, file_id TEXT, content TEXT)`}},
+		{"empty_content", []string{chunkRow("c1", "  ", 0)}},
+		{"duplicate_content", []string{chunkRow("c1", "same", 0), chunkRow("c2", "same", 1)}},
+		{"chunk_type", []string{`INSERT INTO _output (id, file_id, content, token_count, chunk_type, hash, position)
+			VALUES ('c1', 'f1', 'text', 1, 'bogus', 'h', 0)`}},
+		{"parent_ref", []string{`INSERT INTO _output (id, file_id, content, token_count, chunk_type, hash, position, parent_id)
+			VALUES ('c1', 'f1', 'text', 1, 'semantic', 'h', 0, 'missing')`}},
+		{"chunk_ref", []string{`INSERT INTO _output_features VALUES ('missing', 'length', 1, NULL)`}},
+		{"layer", []string{chunkRow("c1", "text", 0), `INSERT INTO _output_vectors VALUES ('c1', 'colour', zeroblob(12), 3, 'x')`}},
+		{"vector_size", []string{chunkRow("c1", "text", 0), `INSERT INTO _output_vectors VALUES ('c1', 'structure', zeroblob(8), 3, 'st-v1')`}},
+		{"dimensions", []string{chunkRow("c1", "a", 0), chunkRow("c2", "b", 1),
+			`INSERT INTO _output_vectors VALUES ('c1', 'blend', zeroblob(8), 2, 'b'), ('c2', 'blend', zeroblob(12), 3, 'b')`}},
+		{"config_dimensions", []string{chunkRow("c1", "text", 0), `INSERT INTO _output_vectors VALUES ('c1', 'lexical', zeroblob(16), 4, 'lex-v1')`}},
+		{"corpus_dimensions", []string{chunkRow("c1", "text", 0), `INSERT INTO _output_vectors VALUES ('c1', 'structure', zeroblob(16), 4, 'st-v2')`}},
+		{"relation_type", []string{chunkRow("c1", "a", 0), chunkRow("c2", "b", 1),
+			`INSERT INTO _output_relations VALUES ('c1', 'c2', 'likes', 0.5)`}},
+		{"weight", []string{chunkRow("c1", "a", 0), chunkRow("c2", "b", 1),
+			`INSERT INTO _output_relations VALUES ('c1', 'c2', 'follows', 2)`}},
+		{"segment_type", []string{`INSERT INTO _output_segments (id, file_id, extractor, extractor_version, segment_type, content, position)
+			VALUES ('s1', 'f1', 'x', '1', 'slide', 'text', 0)`}},
+		{"bbox", []string{`INSERT INTO _output_segments (id, file_id, extractor, extractor_version, segment_type, content, position, bbox)
+			VALUES ('s1', 'f1', 'x', '1', 'text', 'text', 0, '{x:1')`}},
+		{"confidence", []string{`INSERT INTO _output_segments (id, file_id, extractor, extractor_version, segment_type, content, position, confidence)
+			VALUES ('s1', 'f1', 'x', '1', 'text', 'text', 0, 1.5)`}},
+		{"segment_ref", []string{`INSERT INTO _output_units (id, segment_id, unit_type, content, position)
+			VALUES ('u1', 'missing', 'paragraph', 'text', 0)`}},
+	} {
+		_, err := m.ProcessOne(ctx, writeRun(t, dir, "run_"+tt.check, "wf", 1, "f1", tt.stmts...))
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: err = %v, want a validation error", tt.check, err)
+			continue
+		}
+		if len(invalid.Report.Problems) != 1 || invalid.Report.Problems[0].Check != tt.check {
+			t.Errorf("%s: problems = %+v", tt.check, invalid.Report.Problems)
+		}
+	}
+
+	var chunks int
+	if err := m.corpusDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM chunks").Scan(&chunks); err != nil || chunks != 1 {
+		t.Errorf("corpus holds %d chunks, want the seed only (%v)", chunks, err)
+	}
+}
+
+// TestQuarantine checks that a queued run failing validation is moved to
+// the quarantine directory with its report and fails its files for good.
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMerger(t, "f1")
+
+	writeRun(t, m.queueDir, "bad", "wf", 1, "f1", chunkRow("c1", "", 0))
+	results, err := m.Drain(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Status != MergeQuarantined {
+		t.Fatalf("results = %+v", results)
+	}
+
+	if _, err := os.Stat(filepath.Join(m.quarantineDir, "bad.db")); err != nil {
+		t.Errorf("run not quarantined: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.queueDir, "bad.db")); err == nil {
+		t.Error("run left in the queue")
+	}
+	data, err := os.ReadFile(results[0].Report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report ValidationReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Run != "bad.db" || report.RunID != "bad" || len(report.Problems) != 1 ||
+		report.Problems[0].Check != "empty_content" || strings.Join(report.Problems[0].Samples, ",") != "c1" {
+		t.Errorf("report = %+v", report)
+	}
+
+	var status string
+	var attempts int
+	if err := m.corpusDB.QueryRowContext(ctx,
+		"SELECT status, attempts FROM raw_files WHERE id = 'f1'",
+	).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" || attempts != 0 {
+		t.Errorf("file is %s after %d attempts, want failed without retry", status, attempts)
+	}
+}
@@ -0,0 +1,306 @@
+package orchestrator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Archive kinds recognized by ingestion.
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// Skip reasons specific to archive members.
+const (
+	SkipUnsafePath = "unsafe_path" // absolute or escaping member path
+	SkipTooDeep    = "too_deep"    // archive nested beyond MaxDepth
+)
+
+// ArchiveLimits bounds archive expansion. Exceeding the member count,
+// total size or compression ratio aborts the archive as a suspected bomb;
+// oversized members are skipped.
+type ArchiveLimits struct {
+	MaxDepth      int     // nesting of archives in archives
+	MaxMembers    int     // files per archive
+	MaxMemberSize int64   // bytes per member
+	MaxTotalSize  int64   // expanded bytes per archive
+	MaxRatio      float64 // expanded/compressed size of a zip member
+}
+
+// DefaultArchiveLimits returns sensible defaults.
+func DefaultArchiveLimits() ArchiveLimits {
+	return ArchiveLimits{
+		MaxDepth:      3,
+		MaxMembers:    10000,
+		MaxMemberSize: 256 << 20,
+		MaxTotalSize:  2 << 30,
+		MaxRatio:      100,
+	}
+}
+
+// ratioFloor is the expanded size below which the zip ratio is not checked:
+// small files of repeated bytes compress legitimately well.
+const ratioFloor = 1 << 20
+
+// archiveSeparator joins an archive path and a member path.
+const archiveSeparator = "!/"
+
+// archiveKind returns the archive kind of a file from its name and header,
+// or "" for other files.
+func archiveKind(path string, header []byte) string {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case detectMimeType(path, header) == "application/zip":
+		return archiveZip
+	case len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b &&
+		(strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")):
+		return archiveTarGz
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return archiveTar
+	}
+	return ""
+}
+
+// sniffArchive reads the header of a file and returns its archive kind.
+func sniffArchive(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, _ := file.Read(header)
+	return archiveKind(path, header[:n])
+}
+
+// archiveExpander writes the members of an archive into a directory while
+// enforcing the limits.
+type archiveExpander struct {
+	dest    string
+	limits  ArchiveLimits
+	members int
+	total   int64
+	skipped map[string]int
+}
+
+// expandArchive expands an archive into dest and returns the members it
+// skipped by reason.
+func expandArchive(ctx context.Context, kind, path, dest string, limits ArchiveLimits) (map[string]int, error) {
+	x := &archiveExpander{dest: dest, limits: limits, skipped: make(map[string]int)}
+
+	if kind == archiveZip {
+		return x.skipped, x.expandZip(ctx, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if kind == archiveTarGz {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return x.skipped, x.expandTar(ctx, r)
+}
+
+func (x *archiveExpander) expandZip(ctx context.Context, path string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("open zip: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if size := f.UncompressedSize64; size > ratioFloor && f.CompressedSize64 > 0 &&
+			float64(size)/float64(f.CompressedSize64) > x.limits.MaxRatio {
+			return fmt.Errorf("suspected zip bomb: %s expands %d to %d bytes", f.Name, f.CompressedSize64, size)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("open %s: %w", f.Name, err)
+		}
+		err = x.write(f.Name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *archiveExpander) expandTar(ctx context.Context, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		// Links and devices are not followed
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := x.write(hdr.Name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// write copies one member to the destination. declared is the size from
+// the archive header; the copy is bounded regardless of it.
+func (x *archiveExpander) write(name string, declared int64, r io.Reader) error {
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "./"))
+	if !filepath.IsLocal(rel) {
+		x.skipped[SkipUnsafePath]++
+		return nil
+	}
+	if declared > x.limits.MaxMemberSize {
+		x.skipped[SkipTooLarge]++
+		return nil
+	}
+
+	x.members++
+	if x.members > x.limits.MaxMembers {
+		return fmt.Errorf("archive has more than %d members", x.limits.MaxMembers)
+	}
+
+	target := filepath.Join(x.dest, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(r, x.limits.MaxMemberSize+1))
+	if err != nil {
+		return fmt.Errorf("expand %s: %w", name, err)
+	}
+	if n > x.limits.MaxMemberSize {
+		// The header lied about the size
+		out.Close()
+		os.Remove(target)
+		x.skipped[SkipTooLarge]++
+		return nil
+	}
+
+	x.total += n
+	if x.total > x.limits.MaxTotalSize {
+		return fmt.Errorf("archive expands beyond %d bytes", x.limits.MaxTotalSize)
+	}
+	return nil
+}
+
+// ingestArchive expands an archive and ingests its members as documents
+// under the archive's source path and document id, honoring ignore files
+// found in the archive and the filters of opts.
+func (o *Orchestrator) ingestArchive(ctx context.Context, kind, path, archiveID, source, docID string, opts IngestOptions) error {
+	tmp, err := os.MkdirTemp("", "raglite-archive-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	skipped, err := expandArchive(ctx, kind, path, tmp, o.archiveLimits)
+	opts.addSkipped(skipped)
+	if err != nil {
+		return err
+	}
+
+	ignore := newIgnoreMatcher(tmp)
+	err = filepath.WalkDir(tmp, func(member string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() && member == tmp {
+			ignore.load(member)
+			return nil
+		}
+
+		rel, _ := filepath.Rel(tmp, member)
+		rel = filepath.ToSlash(rel)
+		if reason := skipReason(ignore, member, rel, d.IsDir(), opts.Filter); reason != "" {
+			opts.addSkipped(map[string]int{reason: 1})
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.load(member)
+			return nil
+		}
+		if reason := contentSkipReason(member, opts.Filter.MaxSize); reason != "" {
+			opts.addSkipped(map[string]int{reason: 1})
+			return nil
+		}
+
+		memberOpts := opts
+		memberOpts.DocumentID = docID + archiveSeparator + rel
+		memberOpts.sourcePath = source + archiveSeparator + rel
+		memberOpts.depth = opts.depth + 1
+		if memberOpts.depth > o.archiveLimits.MaxDepth && sniffArchive(member) != "" {
+			opts.addSkipped(map[string]int{SkipTooDeep: 1})
+			return nil
+		}
+
+		fileID, err := o.IngestFile(ctx, member, memberOpts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		_, err = o.corpusDB.ExecContext(ctx, `
+			INSERT OR REPLACE INTO archive_members (archive_id, member_path, file_id, document_id)
+			VALUES (?, ?, ?, ?)
+		`, archiveID, rel, fileID, memberOpts.DocumentID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The archive itself is not processed: it is live as soon as expanded
+	_, err = o.corpusDB.ExecContext(ctx,
+		"UPDATE raw_files SET status = 'extracted' WHERE id = ? AND status = 'pending'", archiveID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = o.corpusDB.ExecContext(ctx,
+		"UPDATE document_versions SET merged_at = datetime('now') WHERE file_id = ? AND merged_at IS NULL", archiveID,
+	)
+	return err
+}
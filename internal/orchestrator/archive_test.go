@@ -0,0 +1,179 @@
+package orchestrator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// member is one entry of a test archive.
+type member struct {
+	name    string
+	content string
+}
+
+// writeZip creates a zip archive holding members, deflated.
+func writeZip(t *testing.T, path string, members ...member) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExpandArchiveLimits checks that unsafe and oversized members are
+// skipped and that exceeding an archive-wide limit aborts expansion.
+func TestExpandArchiveLimits(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	limits := ArchiveLimits{MaxDepth: 1, MaxMembers: 3, MaxMemberSize: 100, MaxTotalSize: 150, MaxRatio: 100}
+
+	path := filepath.Join(dir, "ok.zip")
+	writeZip(t, path,
+		member{"a.txt", "alpha"},
+		member{"docs/b.txt", "beta"},
+		member{"../evil.txt", "escapes"},
+		member{"/abs.txt", "absolute"},
+		member{"big.txt", strings.Repeat("x", 101)},
+	)
+	dest := filepath.Join(dir, "ok")
+	skipped, err := expandArchive(ctx, archiveZip, path, dest, limits)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if skipped[SkipUnsafePath] != 2 || skipped[SkipTooLarge] != 1 {
+		t.Errorf("skipped = %v, want 2 unsafe paths and 1 too large", skipped)
+	}
+	for _, rel := range []string{"a.txt", "docs/b.txt"} {
+		if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("member %s not expanded: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+		t.Error("member escaped the destination")
+	}
+
+	for _, tt := range []struct {
+		name    string
+		members []member
+		want    string
+	}{
+		{"members", []member{{"1", "a"}, {"2", "b"}, {"3", "c"}, {"4", "d"}}, "more than 3 members"},
+		{"total", []member{{"1", strings.Repeat("a", 80)}, {"2", strings.Repeat("b", 80)}}, "beyond 150 bytes"},
+	} {
+		path := filepath.Join(dir, tt.name+".zip")
+		writeZip(t, path, tt.members...)
+		_, err := expandArchive(ctx, archiveZip, path, filepath.Join(dir, tt.name), limits)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Beyond the ratio floor, a member that compresses too well is a bomb
+	path = filepath.Join(dir, "bomb.zip")
+	writeZip(t, path, member{"zeros", strings.Repeat("\x00", 2*ratioFloor)})
+	limits.MaxMemberSize, limits.MaxTotalSize = 4*ratioFloor, 4*ratioFloor
+	if _, err := expandArchive(ctx, archiveZip, path, filepath.Join(dir, "bomb"), limits); err == nil ||
+		!strings.Contains(err.Error(), "zip bomb") {
+		t.Errorf("bomb: err = %v", err)
+	}
+}
+
+// TestExpandTarGz checks that tar members other than regular files are not
+// expanded.
+func TestExpandTarGz(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range []*tar.Header{
+		{Name: "./a.txt", Typeflag: tar.TypeReg, Size: 5, Mode: 0644},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd", Mode: 0777},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			tw.Write([]byte("alpha"))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	path := filepath.Join(dir, "a.tar.gz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if kind := sniffArchive(path); kind != archiveTarGz {
+		t.Fatalf("kind = %q, want %q", kind, archiveTarGz)
+	}
+	dest := filepath.Join(dir, "out")
+	if _, err := expandArchive(context.Background(), archiveTarGz, path, dest, DefaultArchiveLimits()); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "a.txt")); err != nil || string(content) != "alpha" {
+		t.Errorf("a.txt = %q, %v", content, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "link")); err == nil {
+		t.Error("symlink expanded")
+	}
+}
+
+// TestIngestArchiveDepth checks that archive members become documents of
+// their own and that archives nested beyond MaxDepth are skipped.
+func TestIngestArchiveDepth(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	inner := filepath.Join(dir, "inner.zip")
+	writeZip(t, inner, member{"deep.txt", "deep"})
+	content, err := os.ReadFile(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := filepath.Join(dir, "outer.zip")
+	writeZip(t, outer, member{"top.txt", "top"}, member{"nested/inner.zip", string(content)})
+
+	cfg := DefaultConfig(filepath.Join(dir, "data"))
+	cfg.Archive.MaxDepth = 0
+	o := newTestOrchestrator(t, cfg)
+
+	skipped := make(map[string]int)
+	archiveID, err := o.IngestFile(ctx, outer, IngestOptions{Skipped: skipped})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped[SkipTooDeep] != 1 {
+		t.Errorf("skipped = %v, want the nested archive too deep", skipped)
+	}
+
+	var memberPath, docID string
+	err = o.corpusDB.QueryRowContext(ctx,
+		"SELECT member_path, document_id FROM archive_members WHERE archive_id = ?", archiveID,
+	).Scan(&memberPath, &docID)
+	if err != nil {
+		t.Fatalf("archive members: %v", err)
+	}
+	if memberPath != "top.txt" || !strings.HasSuffix(docID, "outer.zip"+archiveSeparator+"top.txt") {
+		t.Errorf("member = %s, document = %s", memberPath, docID)
+	}
+}
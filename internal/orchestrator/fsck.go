@@ -0,0 +1,254 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"goraglite/internal/storage"
+)
+
+// Storage problems found by CheckStorage.
+const (
+	StorageMissing = "missing" // the object of a file is gone
+	StorageCorrupt = "corrupt" // the object no longer matches the file checksum
+	StorageOrphan  = "orphan"  // an object no file references
+)
+
+// StorageIssue is a storage problem and, when repaired, what was done.
+type StorageIssue struct {
+	Kind   string `json:"kind"`
+	FileID string `json:"file_id,omitempty"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+	Repair string `json:"repair,omitempty"`
+}
+
+// StorageReport is the result of CheckStorage.
+type StorageReport struct {
+	Files   int            `json:"files"`   // raw_files rows checked
+	Objects int            `json:"objects"` // storage objects found
+	Issues  []StorageIssue `json:"issues"`
+}
+
+// Unrepaired returns the number of issues left as found.
+func (r *StorageReport) Unrepaired() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if issue.Repair == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// storedFile is a raw_files row as CheckStorage sees it.
+type storedFile struct{ id, source, path, encoding, checksum string }
+
+// CheckStorage re-hashes the storage object of every file against its
+// checksum and looks for objects no file references. With repair, a
+// missing or corrupt object is relinked to an intact copy found in storage
+// or restored from the source path when it still has the same content;
+// corrupt and orphaned objects are moved to storage/quarantine.
+func (o *Orchestrator) CheckStorage(ctx context.Context, repair bool) (*StorageReport, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT id, source_path, external_path, encoding, checksum FROM raw_files ORDER BY imported_at",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var files []storedFile
+	for rows.Next() {
+		var f storedFile
+		if err := rows.Scan(&f.id, &f.source, &f.path, &f.encoding, &f.checksum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &StorageReport{Files: len(files)}
+	referenced := make(map[string]bool)
+	for _, f := range files {
+		referenced[absPath(f.path)] = true
+	}
+
+	// Objects no file references
+	var orphans []string
+	root := filepath.Join(o.dataDir, "storage", "raw")
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		report.Objects++
+		if !referenced[absPath(path)] {
+			orphans = append(orphans, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk storage: %w", err)
+	}
+
+	// Orphans are candidates to relink missing objects, hashed on demand
+	var orphanSums map[string]string
+	intactCopy := func(checksum string) string {
+		if orphanSums == nil {
+			orphanSums = make(map[string]string)
+			for _, path := range orphans {
+				if sum, err := hashObject(path, objectEncoding(path)); err == nil {
+					orphanSums[sum] = path
+				}
+			}
+		}
+		return orphanSums[checksum]
+	}
+	relinked := make(map[string]bool)
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		issue := StorageIssue{FileID: f.id, Path: f.path}
+		sum, err := hashObject(f.path, f.encoding)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			issue.Kind = StorageMissing
+		case err != nil:
+			issue.Kind, issue.Detail = StorageCorrupt, err.Error()
+		case sum != f.checksum:
+			issue.Kind, issue.Detail = StorageCorrupt, "sha256 "+sum
+		default:
+			continue
+		}
+
+		if repair {
+			issue.Repair, err = o.restoreObject(ctx, f, issue.Kind == StorageCorrupt, intactCopy)
+			if err != nil {
+				issue.Detail = err.Error()
+			}
+			if issue.Repair != "" {
+				relinked[intactCopy(f.checksum)] = true
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	for _, path := range orphans {
+		issue := StorageIssue{Kind: StorageOrphan, Path: path}
+		switch {
+		case relinked[path]:
+			issue.Repair = "relinked"
+		case repair:
+			dest, err := o.quarantine(path)
+			if err != nil {
+				issue.Detail = err.Error()
+			} else {
+				issue.Repair = "quarantined to " + dest
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	if repair && len(report.Issues) > 0 {
+		o.audit(ctx, "fsck", "storage", fmt.Sprintf(`{"issues":%d,"unrepaired":%d}`,
+			len(report.Issues), report.Unrepaired()))
+	}
+	return report, nil
+}
+
+// restoreObject puts an intact object for a file back at its storage path:
+// an orphaned object with the same checksum, else a copy of the source when
+// it still has the same content. A corrupt object is quarantined first. It
+// returns what was done, or "" when no intact copy was found.
+func (o *Orchestrator) restoreObject(ctx context.Context, f storedFile, corrupt bool, intactCopy func(string) string) (string, error) {
+	orphan := intactCopy(f.checksum)
+	if orphan == "" {
+		// Members of archives have no source of their own
+		if strings.Contains(f.source, archiveSeparator) {
+			return "", nil
+		}
+		if sum, err := hashObject(f.source, storage.Identity); err != nil || sum != f.checksum {
+			return "", nil
+		}
+	}
+	if corrupt {
+		if _, err := o.quarantine(f.path); err != nil {
+			return "", err
+		}
+	}
+
+	var repair string
+	if orphan != "" {
+		// Relink the file to the orphan where it lies
+		_, err := o.corpusDB.ExecContext(ctx,
+			"UPDATE raw_files SET external_path = ?, encoding = ? WHERE id = ?", orphan, objectEncoding(orphan), f.id,
+		)
+		if err != nil {
+			return "", err
+		}
+		repair = "relinked to " + orphan
+	} else {
+		src, err := os.Open(f.source)
+		if err != nil {
+			return "", err
+		}
+		_, err = storage.Write(f.path, f.encoding, src)
+		src.Close()
+		if err != nil {
+			return "", fmt.Errorf("restore from %s: %w", f.source, err)
+		}
+		repair = "restored from " + f.source
+	}
+
+	o.audit(ctx, "fsck_repair", f.id, fmt.Sprintf(`{"repair":%q}`, repair))
+	return repair, nil
+}
+
+// quarantine moves a storage object to storage/quarantine and returns its
+// new path.
+func (o *Orchestrator) quarantine(path string) (string, error) {
+	dir := filepath.Join(o.dataDir, "storage", "quarantine")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%s.%d", filepath.Base(path), time.Now().UnixNano()))
+	if err := os.Rename(path, dest); err != nil {
+		return "", fmt.Errorf("quarantine %s: %w", path, err)
+	}
+	return dest, nil
+}
+
+// objectEncoding returns the encoding of a storage object from its name.
+func objectEncoding(path string) string {
+	if strings.HasSuffix(path, ".gz") {
+		return storage.Gzip
+	}
+	return storage.Identity
+}
+
+// absPath returns the absolute form of a path, or the path itself.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,209 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFiles are read in every walked directory, in order: rules of a
+// later file override the earlier ones.
+var ignoreFiles = []string{".gitignore", ".ragliteignore"}
+
+// defaultIgnore applies at the root of every walk: VCS internals and the
+// ignore files themselves.
+var defaultIgnore = []string{".git/", ".gitignore", ".ragliteignore"}
+
+// Skip reasons reported by directory ingestion.
+const (
+	SkipIgnored     = "ignored"      // matched .gitignore/.ragliteignore
+	SkipExcluded    = "excluded"     // matched an exclude glob
+	SkipNotIncluded = "not_included" // matched no include glob
+	SkipTooLarge    = "too_large"
+	SkipBinary      = "binary" // no extractor would read it
+	SkipUnreadable  = "unreadable"
+)
+
+// ignoreRule is one line of an ignore file.
+type ignoreRule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool // matched against the path relative to the file's directory
+}
+
+// ignoreMatcher holds the ignore rules of the directories of one walk,
+// keyed by directory.
+type ignoreMatcher struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	m := &ignoreMatcher{root: root, rules: make(map[string][]ignoreRule)}
+	m.rules[root] = parseIgnore(defaultIgnore)
+	return m
+}
+
+// load reads the ignore files of dir. Missing files are not an error.
+func (m *ignoreMatcher) load(dir string) {
+	for _, name := range ignoreFiles {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		var lines []string
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		m.rules[dir] = append(m.rules[dir], parseIgnore(lines)...)
+	}
+}
+
+// ignored reports whether path is ignored. Rules of deeper directories take
+// precedence, and within a directory the last matching rule wins.
+func (m *ignoreMatcher) ignored(path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == m.root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules := m.rules[dirs[i]]
+		if len(rules) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		base := filepath.Base(path)
+		for _, r := range rules {
+			if r.dirOnly && !isDir {
+				continue
+			}
+			name := base
+			if r.anchored {
+				name = rel
+			}
+			if r.re.MatchString(name) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// parseIgnore parses gitignore-style lines.
+func parseIgnore(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // escaped leading # or !
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		re, err := globRegexp(line)
+		if err != nil {
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// globMatch matches a glob against a slash-separated relative path. Globs
+// without a slash match the base name at any depth.
+func globMatch(pattern, rel string) bool {
+	name := rel
+	if !strings.Contains(pattern, "/") {
+		name = rel[strings.LastIndex(rel, "/")+1:]
+	}
+	re, err := globRegexp(strings.TrimPrefix(pattern, "/"))
+	return err == nil && re.MatchString(name)
+}
+
+// globRegexp compiles a glob with gitignore semantics: * and ? stop at
+// slashes, ** spans directories, [...] is a character class.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// looksBinary reports whether a file header holds binary data that no
+// extractor handles: unknown binaries and media.
+func looksBinary(path string, header []byte) bool {
+	mimeType := detectMimeType(path, header)
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return mimeType == "application/octet-stream" && bytes.IndexByte(header, 0) >= 0
+}
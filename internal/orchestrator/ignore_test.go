@@ -0,0 +1,134 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGlobMatch covers the glob syntax of include and exclude filters.
+func TestGlobMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/raglite/main.go", true},
+		{"*.go", "main.gox", false},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"**/*.md", "a.md", true},
+		{"**/*.md", "docs/sub/a.md", true},
+		{"/build/**", "build/x/y.o", true},
+		{"/build/**", "src/build/y.o", false},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file10.txt", false},
+		{"[ab].txt", "a.txt", true},
+		{"[!ab].txt", "a.txt", false},
+		{"[!ab].txt", "c.txt", true},
+		{`\*.txt`, "*.txt", true},
+		{`\*.txt`, "a.txt", false},
+	} {
+		if got := globMatch(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+// TestIgnoreMatcher checks precedence between the rules of nested ignore
+// files, negation, directory-only and anchored rules.
+func TestIgnoreMatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":         "# build output\n*.log\n!keep.log\nbuild/\n/top.txt\n",
+		"sub/.ragliteignore": "keep.log\n!debug.log\n",
+	})
+
+	m := newIgnoreMatcher(root)
+	m.load(root)
+	m.load(filepath.Join(root, "sub"))
+
+	for _, tt := range []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"sub/keep.log", false, true},
+		{"sub/debug.log", false, false},
+		{"sub/other.log", false, true},
+		{".git", true, true},
+		{".gitignore", false, true},
+		{"a.md", false, false},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(tt.rel))
+		if got := m.ignored(path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%s, dir=%v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+// TestIngestTreeFilters checks the skip reasons reported by a directory
+// ingestion.
+func TestIngestTreeFilters(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":    "*.log\nbuild/\n",
+		"a.md":          "# Kept\n",
+		"b.log":         "ignored\n",
+		"build/x.md":    "# Ignored directory\n",
+		"notes/c.md":    "# Excluded directory\n",
+		"big.md":        strings.Repeat("large ", 200),
+		"img.png":       "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"main.go":       "package main\n",
+		"sub/deep/d.md": "# Kept too\n",
+	})
+
+	o := newTestOrchestrator(t, DefaultConfig(t.TempDir()))
+	report, err := o.IngestTree(context.Background(), root, DirOptions{
+		Recursive: true,
+		Include:   []string{"*.md", "*.png"},
+		Exclude:   []string{"notes"},
+		MaxSize:   1000,
+	}, IngestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.IDs) != 2 {
+		t.Errorf("ingested %d files, want a.md and sub/deep/d.md", len(report.IDs))
+	}
+	want := map[string]int{
+		SkipIgnored:     3, // .gitignore, b.log, build/
+		SkipExcluded:    1,
+		SkipNotIncluded: 1,
+		SkipTooLarge:    1,
+		SkipBinary:      1,
+	}
+	for reason, n := range want {
+		if report.Skipped[reason] != n {
+			t.Errorf("skipped[%s] = %d, want %d", reason, report.Skipped[reason], n)
+		}
+	}
+}
+
+// writeFiles creates files under dir, keyed by slash-separated path.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
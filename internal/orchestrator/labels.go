@@ -0,0 +1,43 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// labelFile sets the collection of a file and merges tags into its tags,
+// then copies both to the chunks already merged for it. Chunks merged
+// later pick the labels up from raw_files.
+func (o *Orchestrator) labelFile(ctx context.Context, fileID, collection string, tags map[string]string) error {
+	if collection == "" && len(tags) == 0 {
+		return nil
+	}
+	patch, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		patch = []byte("{}")
+	}
+
+	return o.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE raw_files SET
+				collection = COALESCE(NULLIF(?, ''), collection),
+				tags = json_patch(COALESCE(tags, '{}'), ?)
+			WHERE id = ?
+		`, collection, string(patch), fileID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE chunks SET
+				collection = (SELECT collection FROM raw_files WHERE id = ?),
+				tags = (SELECT tags FROM raw_files WHERE id = ?)
+			WHERE file_id = ?
+		`, fileID, fileID, fileID)
+		return err
+	})
+}
@@ -0,0 +1,25 @@
+package orchestrator
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pidFile is the lock file of a data directory. The process holding the
+// lock writes its PID into it.
+const pidFile = "daemon.pid"
+
+// readPID reads the PID written in an open lock file.
+func readPID(f *os.File) (int, bool) {
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	return pid, true
+}
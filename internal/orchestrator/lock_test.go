@@ -0,0 +1,35 @@
+package orchestrator
+
+import (
+	"os"
+	"testing"
+)
+
+// TestLockDataDir checks that a data directory has a single lock holder,
+// whose PID is reported, and can be locked again once released.
+func TestLockDataDir(t *testing.T) {
+	dir := t.TempDir()
+
+	unlock, err := LockDataDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok := DaemonPID(dir); !ok || pid != os.Getpid() {
+		t.Errorf("DaemonPID = %d, %v; want %d", pid, ok, os.Getpid())
+	}
+	if _, err := LockDataDir(dir); err == nil {
+		t.Fatal("data directory locked twice")
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := DaemonPID(dir); ok {
+		t.Error("DaemonPID reports a released lock")
+	}
+	unlock, err = LockDataDir(dir)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,66 @@
+//go:build unix
+
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockDataDir takes the exclusive lock of a data directory with flock on its
+// lock file. The kernel releases the lock when the holder exits, so a crashed
+// process never leaves a stale lock behind.
+// The returned function releases the lock.
+func LockDataDir(dataDir string) (func() error, error) {
+	path := filepath.Join(dataDir, pidFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("lock %s: %w", dataDir, err)
+		}
+		if pid, ok := DaemonPID(dataDir); ok {
+			return nil, fmt.Errorf("daemon already running on %s (pid %d)", dataDir, pid)
+		}
+		return nil, fmt.Errorf("%s is locked by another process", dataDir)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write pid file: %w", err)
+	}
+
+	return func() error {
+		// Closing the file releases the lock; the file stays for the next holder
+		f.Truncate(0)
+		return f.Close()
+	}, nil
+}
+
+// DaemonPID returns the PID of the process holding the lock of a data
+// directory.
+func DaemonPID(dataDir string) (int, bool) {
+	f, err := os.Open(filepath.Join(dataDir, pidFile))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	// A shared lock is only refused while the exclusive lock is held
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0, false
+	}
+
+	return readPID(f)
+}
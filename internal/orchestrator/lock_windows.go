@@ -0,0 +1,79 @@
+//go:build windows
+
+package orchestrator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LockDataDir takes the exclusive lock of a data directory by creating its
+// lock file exclusively. A lock file left by a crashed process is stale once
+// its PID is gone, and is then taken over.
+// The returned function releases the lock.
+func LockDataDir(dataDir string) (func() error, error) {
+	path := filepath.Join(dataDir, pidFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		if pid, ok := DaemonPID(dataDir); ok {
+			return nil, fmt.Errorf("daemon already running on %s (pid %d)", dataDir, pid)
+		}
+		if _, ok := lockFilePID(path); !ok {
+			return nil, fmt.Errorf("%s is locked by another process (remove %s if none is running)", dataDir, path)
+		}
+		// The holder is gone: take the stale lock over
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale lock file: %w", err)
+		}
+		f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("%s is locked by another process", dataDir)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("write pid file: %w", err)
+	}
+
+	return func() error {
+		// The lock is the file itself: removing it releases the lock
+		err := f.Close()
+		if rerr := os.Remove(path); err == nil {
+			err = rerr
+		}
+		return err
+	}, nil
+}
+
+// DaemonPID returns the PID of the process holding the lock of a data
+// directory.
+func DaemonPID(dataDir string) (int, bool) {
+	pid, ok := lockFilePID(filepath.Join(dataDir, pidFile))
+	if !ok {
+		return 0, false
+	}
+	// FindProcess opens the process and fails once it has exited
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, false
+	}
+	p.Release()
+	return pid, true
+}
+
+// lockFilePID reads the PID written in a lock file.
+func lockFilePID(path string) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	return readPID(f)
+}
@@ -0,0 +1,195 @@
+package orchestrator
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// extMimeTypes are the extensions resolved before the system MIME table,
+// which varies between hosts (.ts is video/mp2t on some).
+var extMimeTypes = map[string]string{
+	".txt":  "text/plain",
+	".md":   "text/markdown",
+	".go":   "text/x-go",
+	".py":   "text/x-python",
+	".js":   "text/javascript",
+	".jsx":  "text/javascript",
+	".mjs":  "text/javascript",
+	".ts":   "text/typescript",
+	".tsx":  "text/typescript",
+	".rs":   "text/x-rust",
+	".java": "text/x-java",
+	".c":    "text/x-c",
+	".h":    "text/x-c",
+	".cpp":  "text/x-c++",
+	".rb":   "text/x-ruby",
+	".sh":   "text/x-sh",
+	".bash": "text/x-sh",
+	".sql":  "text/x-sql",
+	".html": "text/html",
+	".htm":  "text/html",
+	".csv":  "text/csv",
+	".yaml": "text/yaml",
+	".yml":  "text/yaml",
+	".json": "application/json",
+	".toml": "text/toml",
+}
+
+// shebangTypes maps script interpreters to MIME types.
+var shebangTypes = map[string]string{
+	"python":  "text/x-python",
+	"python3": "text/x-python",
+	"sh":      "text/x-sh",
+	"bash":    "text/x-sh",
+	"zsh":     "text/x-sh",
+	"node":    "text/javascript",
+	"perl":    "text/x-perl",
+	"ruby":    "text/x-ruby",
+}
+
+// zipMimeTypes identify OOXML packages by a part they must contain.
+var zipMimeTypes = []struct {
+	part     string
+	mimeType string
+}{
+	{"word/document.xml", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/workbook.xml", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/presentation.xml", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+}
+
+// detectMimeType detects the MIME type of a file. Binary signatures win
+// over the extension, which wins over text heuristics (shebang, markup,
+// encoding).
+func detectMimeType(path string, content []byte) string {
+	if mimeType := sniffBinary(path, content); mimeType != "" {
+		return mimeType
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if mimeType, ok := extMimeTypes[ext]; ok {
+		return mimeType
+	}
+	if ext != "" {
+		if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+			// Strip parameters
+			if idx := strings.Index(mimeType, ";"); idx != -1 {
+				mimeType = mimeType[:idx]
+			}
+			return mimeType
+		}
+	}
+
+	if mimeType := sniffText(content); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// sniffBinary recognizes binary formats by their magic number.
+func sniffBinary(path string, content []byte) string {
+	switch {
+	case bytes.HasPrefix(content, []byte("%PDF")):
+		return "application/pdf"
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")), bytes.HasPrefix(content, []byte("PK\x05\x06")):
+		return sniffZip(path, content)
+	case bytes.HasPrefix(content, []byte{0x1f, 0x8b}):
+		return "application/gzip"
+	case len(content) >= 262 && string(content[257:262]) == "ustar":
+		return "application/x-tar"
+	case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(content, []byte{0xff, 0xd8, 0xff}):
+		return "image/jpeg"
+	case bytes.HasPrefix(content, []byte("GIF87a")), bytes.HasPrefix(content, []byte("GIF89a")):
+		return "image/gif"
+	case len(content) >= 12 && string(content[:4]) == "RIFF" && string(content[8:12]) == "WEBP":
+		return "image/webp"
+	case bytes.HasPrefix(content, []byte("II*\x00")), bytes.HasPrefix(content, []byte("MM\x00*")):
+		return "image/tiff"
+	case bytes.HasPrefix(content, []byte("SQLite format 3\x00")):
+		return "application/vnd.sqlite3"
+	case bytes.HasPrefix(content, []byte(`{\rtf`)):
+		return "application/rtf"
+	}
+	return ""
+}
+
+// sniffZip tells ODF and EPUB packages apart by their leading mimetype
+// entry, and OOXML packages by their parts. Other zips are archives.
+func sniffZip(path string, content []byte) string {
+	// ODF and EPUB store an uncompressed "mimetype" entry first
+	if len(content) >= 30 {
+		nameLen := int(binary.LittleEndian.Uint16(content[26:28]))
+		extraLen := int(binary.LittleEndian.Uint16(content[28:30]))
+		size := int(binary.LittleEndian.Uint32(content[18:22]))
+		start := 30 + nameLen + extraLen
+		if 30+nameLen <= len(content) && string(content[30:30+nameLen]) == "mimetype" &&
+			size > 0 && start+size <= len(content) {
+			return string(content[start : start+size])
+		}
+	}
+
+	if zr, err := zip.OpenReader(path); err == nil {
+		defer zr.Close()
+		for _, t := range zipMimeTypes {
+			for _, f := range zr.File {
+				if f.Name == t.part {
+					return t.mimeType
+				}
+			}
+		}
+	}
+	return "application/zip"
+}
+
+// sniffText recognizes text content: UTF-16 byte order marks (the content
+// is decoded to UTF-8 at extraction), scripts by their shebang, markup, and
+// valid UTF-8.
+func sniffText(content []byte) string {
+	if bytes.HasPrefix(content, []byte{0xff, 0xfe}) || bytes.HasPrefix(content, []byte{0xfe, 0xff}) {
+		return "text/plain"
+	}
+	content = bytes.TrimPrefix(content, []byte{0xef, 0xbb, 0xbf})
+	if len(content) == 0 || bytes.IndexByte(content, 0) >= 0 {
+		return ""
+	}
+
+	if bytes.HasPrefix(content, []byte("#!")) {
+		line, _, _ := bytes.Cut(content[2:], []byte("\n"))
+		fields := strings.Fields(string(line))
+		if len(fields) > 0 {
+			interp := filepath.Base(fields[0])
+			if interp == "env" && len(fields) > 1 {
+				interp = fields[1]
+			}
+			if mimeType, ok := shebangTypes[interp]; ok {
+				return mimeType
+			}
+		}
+		return "text/plain"
+	}
+
+	head := strings.ToLower(string(bytes.TrimSpace(content[:min(len(content), 64)])))
+	switch {
+	case strings.HasPrefix(head, "<!doctype html"), strings.HasPrefix(head, "<html"):
+		return "text/html"
+	case strings.HasPrefix(head, "<?xml"):
+		return "application/xml"
+	case strings.HasPrefix(head, "%!ps"):
+		return "application/postscript"
+	}
+
+	// A header cut mid-rune is still text
+	for i := 0; i < 3 && len(content) > 0 && !utf8.Valid(content); i++ {
+		content = content[:len(content)-1]
+	}
+	if utf8.Valid(content) {
+		return "text/plain"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package orchestrator
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDetectMimeType covers magic numbers, extensions and text heuristics.
+func TestDetectMimeType(t *testing.T) {
+	for _, tt := range []struct {
+		path    string
+		content string
+		want    string
+	}{
+		{"report.bin", "%PDF-1.7\n", "application/pdf"},
+		{"report.txt", "%PDF-1.7\n", "application/pdf"}, // signatures win over the extension
+		{"photo", "\x89PNG\r\n\x1a\n\x00\x00", "image/png"},
+		{"photo", "\xff\xd8\xff\xe0", "image/jpeg"},
+		{"data", "SQLite format 3\x00", "application/vnd.sqlite3"},
+		{"notes.md", "# Title\n", "text/markdown"},
+		{"lib.ts", "export const a = 1\n", "text/typescript"},
+		{"run", "#!/usr/bin/env python3\nprint(1)\n", "text/x-python"},
+		{"run", "#!/bin/bash\necho\n", "text/x-sh"},
+		{"run", "#!/usr/bin/awk -f\n", "text/plain"},
+		{"page", "  <!DOCTYPE html><html></html>", "text/html"},
+		{"feed", "<?xml version=\"1.0\"?><rss/>", "application/xml"},
+		{"README", "\xef\xbb\xbfplain text", "text/plain"},
+		{"README", "\xff\xfeh\x00i\x00", "text/plain"},
+		{"README", "caf\xc3", "text/plain"}, // header cut mid-rune
+		{"blob", "\x00\x01\x02", "application/octet-stream"},
+		{"blob", "\xc3\x28\xa0\xa1 invalid", "application/octet-stream"},
+	} {
+		if got := detectMimeType(tt.path, []byte(tt.content)); got != tt.want {
+			t.Errorf("detectMimeType(%s, %q) = %s, want %s", tt.path, tt.content, got, tt.want)
+		}
+	}
+}
+
+// TestSniffZip tells office packages from plain zip archives.
+func TestSniffZip(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name  string
+		parts []string
+		want  string
+	}{
+		{"doc.zip", []string{"[Content_Types].xml", "word/document.xml"}, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"sheet.bin", []string{"xl/workbook.xml"}, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"text.odt", []string{"mimetype", "content.xml"}, "application/vnd.oasis.opendocument.text"},
+		{"archive.docx", []string{"a.txt", "b.txt"}, "application/zip"},
+	} {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, part := range tt.parts {
+			content := []byte("<x/>")
+			if part == "mimetype" {
+				content = []byte("application/vnd.oasis.opendocument.text")
+			}
+			// ODF stores its mimetype entry first, uncompressed, with its
+			// size in the local header
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:               part,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE(content),
+				CompressedSize64:   uint64(len(content)),
+				UncompressedSize64: uint64(len(content)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(content)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		header := buf.Bytes()[:min(buf.Len(), 512)]
+		if got := detectMimeType(path, header); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRouteMimeType checks that exact routes win over globs, and the most
+// specific glob over broader ones.
+func TestRouteMimeType(t *testing.T) {
+	routes := []Route{
+		{Pattern: "*/*", WorkflowID: "fallback"},
+		{Pattern: "text/*", WorkflowID: "text"},
+		{Pattern: "text/x-*", WorkflowID: "code"},
+		{Pattern: "text/markdown", WorkflowID: "markdown"},
+	}
+	for mimeType, want := range map[string]string{
+		"text/markdown":   "markdown",
+		"text/x-go":       "code",
+		"text/plain":      "text",
+		"application/pdf": "fallback",
+	} {
+		if got, ok := routeMimeType(routes, mimeType); !ok || got != want {
+			t.Errorf("routeMimeType(%s) = %s, %v; want %s", mimeType, got, ok, want)
+		}
+	}
+	if _, ok := routeMimeType(routes[1:], "image/png"); ok {
+		t.Error("image/png routed without a matching pattern")
+	}
+}
@@ -4,17 +4,20 @@ package orchestrator
 import (
 	"context"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
-	"mime"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
 	"goraglite/internal/db"
+	"goraglite/internal/vector"
 	"goraglite/internal/workflow"
 )
 
@@ -25,19 +28,27 @@ type Orchestrator struct {
 	engine      *workflow.Engine
 	dataDir     string
 
+	archiveLimits ArchiveLimits
+
 	mu           sync.RWMutex
 	workers      map[string]*Worker
-	workflowMap  map[string]string // mime_type -> workflow_id
+	workflowMap  map[string]string // mime_type -> workflow_id, overrides mime_routes
 	maxWorkers   int
 	pollInterval time.Duration
+	onEvent      workflow.EventHandler
+	dispatched   map[string]time.Time // files run but not merged yet, skipped by the next poll
+	served       map[string]int64     // workflow_id -> dispatch sequence of its last job
+	serveSeq     int64
 }
 
 // Worker represents a workflow execution worker.
 type Worker struct {
-	ID        string
-	Status    WorkerStatus
-	CurrentRun string
-	StartedAt time.Time
+	ID         string       `json:"id"`
+	Status     WorkerStatus `json:"status"`
+	Workflow   string       `json:"workflow,omitempty"`
+	CurrentRun string       `json:"current_run,omitempty"`
+	StartedAt  time.Time    `json:"started_at,omitempty"` // start of the current run
+	RunsDone   int          `json:"runs_done"`
 }
 
 // WorkerStatus represents worker state.
@@ -54,6 +65,7 @@ type Config struct {
 	DataDir      string
 	MaxWorkers   int
 	PollInterval time.Duration
+	Archive      ArchiveLimits
 }
 
 // DefaultConfig returns sensible defaults.
@@ -62,39 +74,121 @@ func DefaultConfig(dataDir string) Config {
 		DataDir:      dataDir,
 		MaxWorkers:   4,
 		PollInterval: 5 * time.Second,
+		Archive:      DefaultArchiveLimits(),
 	}
 }
 
 // New creates a new orchestrator.
 func New(corpusDB, workflowsDB *db.DB, engine *workflow.Engine, cfg Config) *Orchestrator {
+	if cfg.Archive == (ArchiveLimits{}) {
+		cfg.Archive = DefaultArchiveLimits()
+	}
 	return &Orchestrator{
 		corpusDB:     corpusDB,
 		workflowsDB:  workflowsDB,
 		engine:       engine,
 		dataDir:      cfg.DataDir,
 		workers:      make(map[string]*Worker),
-		workflowMap:  defaultWorkflowMap(),
+		dispatched:   make(map[string]time.Time),
+		served:       make(map[string]int64),
+		workflowMap:  make(map[string]string),
 		maxWorkers:   cfg.MaxWorkers,
 		pollInterval: cfg.PollInterval,
+
+		archiveLimits: cfg.Archive,
 	}
 }
 
-// defaultWorkflowMap returns default mime type to workflow mappings.
-func defaultWorkflowMap() map[string]string {
-	return map[string]string{
-		"application/pdf":                   "pdf_chunking_v1",
-		"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "docx_chunking_v1",
-		"application/msword":                "docx_chunking_v1",
-		"text/plain":                        "text_chunking_v1",
-		"text/markdown":                     "text_chunking_v1",
-		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "xlsx_chunking_v1",
-		"application/vnd.ms-excel":          "xlsx_chunking_v1",
+// IngestOptions controls how a file is ingested.
+type IngestOptions struct {
+	// DocumentID is the logical document the file is a version of.
+	// Defaults to the absolute source path.
+	DocumentID string
+
+	// Collection and Tags label the file and its chunks. Labels given for
+	// content already in the corpus replace its collection and add to its tags.
+	Collection string
+	Tags       map[string]string
+
+	// Priority, when set, orders the file in the processing queue ahead of
+	// lower ones; otherwise the priority of its collection applies.
+	Priority *int
+
+	// Filter applies to the members of archives.
+	Filter DirOptions
+	// Skipped, when set, counts the archive members skipped by reason.
+	Skipped map[string]int
+
+	sourcePath string // recorded source path, defaults to path
+	depth      int    // archive nesting
+}
+
+func (opts *IngestOptions) addSkipped(skipped map[string]int) {
+	if opts.Skipped == nil {
+		return
+	}
+	for reason, n := range skipped {
+		opts.Skipped[reason] += n
 	}
 }
 
-// Ingest imports a file into the corpus.
-// HOROS: Files are copied to external storage, not stored as BLOB.
+// Ingest imports a file into the corpus as a new version of the document
+// at its path.
 func (o *Orchestrator) Ingest(ctx context.Context, path string) (string, error) {
+	return o.IngestFile(ctx, path, IngestOptions{})
+}
+
+// IngestFile imports a file into the corpus and records it as the latest
+// version of its document. Zip and tar archives are expanded: each member
+// becomes a document of its own, at `<archive>!/<member path>`.
+func (o *Orchestrator) IngestFile(ctx context.Context, path string, opts IngestOptions) (string, error) {
+	source := opts.sourcePath
+	if source == "" {
+		source = path
+	}
+	id, err := o.storeFile(ctx, path, source)
+	if err != nil {
+		return "", err
+	}
+
+	if err := o.labelFile(ctx, id, opts.Collection, opts.Tags); err != nil {
+		return "", fmt.Errorf("label file: %w", err)
+	}
+	if opts.Priority != nil {
+		_, err := o.corpusDB.ExecContext(ctx, "UPDATE raw_files SET priority = ? WHERE id = ?", *opts.Priority, id)
+		if err != nil {
+			return "", fmt.Errorf("set priority: %w", err)
+		}
+	}
+
+	if opts.sourcePath == "" {
+		if source, err = filepath.Abs(path); err != nil {
+			return "", err
+		}
+	}
+	docID := opts.DocumentID
+	if docID == "" {
+		docID = source
+	}
+	if err := o.versionDocument(ctx, docID, source, id); err != nil {
+		return "", fmt.Errorf("version document: %w", err)
+	}
+
+	if kind := sniffArchive(path); kind != "" {
+		if err := o.ingestArchive(ctx, kind, path, id, source, docID, opts); err != nil {
+			err = fmt.Errorf("expand archive %s: %w", source, err)
+			o.corpusDB.FailFiles(ctx, "orchestrator", []string{id}, err.Error())
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+// storeFile copies a file to external storage and inserts its raw_files
+// row. Content already in the corpus is not stored twice.
+// HOROS: Files are copied to external storage, not stored as BLOB.
+func (o *Orchestrator) storeFile(ctx context.Context, path, source string) (string, error) {
 	// Open file for hashing
 	file, err := os.Open(path)
 	if err != nil {
@@ -132,22 +226,17 @@ func (o *Orchestrator) Ingest(ctx context.Context, path string) (string, error)
 	mimeType := detectMimeType(path, header[:n])
 
 	// HOROS: Copy file to external storage (cp/rm compatible)
-	// Storage layout: {dataDir}/storage/raw/{hash[0:2]}/{hash}
-	storageDir := filepath.Join(o.dataDir, "storage", "raw", checksum[:2])
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
-		return "", fmt.Errorf("create storage dir: %w", err)
-	}
-
-	externalPath := filepath.Join(storageDir, checksum)
-	if err := copyFile(path, externalPath); err != nil {
+	// Storage layout: {dataDir}/storage/raw/{hash[0:2]}/{hash}[.gz]
+	encoding, externalPath, err := o.writeObject(ctx, file, checksum, mimeType, info.Size())
+	if err != nil {
 		return "", fmt.Errorf("copy to storage: %w", err)
 	}
 
 	// Insert into corpus (external_path instead of content BLOB)
 	_, err = o.corpusDB.ExecContext(ctx, `
-		INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum, status)
-		VALUES (?, ?, ?, ?, ?, ?, 'pending')
-	`, id, path, mimeType, info.Size(), externalPath, checksum)
+		INSERT INTO raw_files (id, source_path, mime_type, size, external_path, encoding, checksum, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'pending')
+	`, id, source, mimeType, info.Size(), externalPath, encoding, checksum)
 	if err != nil {
 		// Clean up copied file on failure
 		os.Remove(externalPath)
@@ -158,136 +247,265 @@ func (o *Orchestrator) Ingest(ctx context.Context, path string) (string, error)
 	o.corpusDB.ExecContext(ctx, `
 		INSERT INTO audit_log (actor, action, target, details)
 		VALUES ('orchestrator', 'ingest', ?, ?)
-	`, id, fmt.Sprintf(`{"path":"%s","external_path":"%s","mime":"%s","size":%d}`, path, externalPath, mimeType, info.Size()))
+	`, id, fmt.Sprintf(`{"path":"%s","external_path":"%s","encoding":"%s","mime":"%s","size":%d}`, source, externalPath, encoding, mimeType, info.Size()))
 
 	return id, nil
 }
 
-// copyFile copies a file from src to dst.
-// HOROS: Simple cp operation for Data-Physics law compliance.
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
+// DirOptions filters the files of a directory ingestion.
+type DirOptions struct {
+	Recursive bool
+	Include   []string // globs; when set, only matching files are ingested
+	Exclude   []string // globs of files and directories to skip
+	MaxSize   int64    // bytes, 0 for no limit
+}
 
-	return out.Sync()
+// DirReport is the outcome of a directory ingestion.
+type DirReport struct {
+	IDs     []string       `json:"ids"`
+	Skipped map[string]int `json:"skipped"` // by reason; a skipped directory counts once
 }
 
-// IngestDir imports all files from a directory.
+// IngestDir imports all files from a directory, honoring .gitignore and
+// .ragliteignore files.
 func (o *Orchestrator) IngestDir(ctx context.Context, dirPath string, recursive bool) ([]string, error) {
-	var ids []string
+	report, err := o.IngestTree(ctx, dirPath, DirOptions{Recursive: recursive}, IngestOptions{})
+	return report.IDs, err
+}
+
+// IngestTree imports the files of a directory that pass the ignore files
+// and the filters of opts. The collection and tags of labels apply to every
+// file.
+func (o *Orchestrator) IngestTree(ctx context.Context, dirPath string, opts DirOptions, labels IngestOptions) (*DirReport, error) {
+	report := &DirReport{Skipped: make(map[string]int)}
+
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return report, err
+	}
+	dataDir, _ := filepath.Abs(o.dataDir)
+	ignore := newIgnoreMatcher(root)
 
 	walkFn := func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() && path == root {
+			ignore.load(path)
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if reason := skipReason(ignore, path, rel, d.IsDir(), opts); reason != "" {
+			report.Skipped[reason]++
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		if d.IsDir() {
-			if !recursive && path != dirPath {
+			// Never ingest our own storage
+			if !opts.Recursive || path == dataDir {
 				return filepath.SkipDir
 			}
+			ignore.load(path)
+			return nil
+		}
+		if !d.Type().IsRegular() {
 			return nil
 		}
 
-		id, err := o.Ingest(ctx, path)
+		if reason := contentSkipReason(path, opts.MaxSize); reason != "" {
+			report.Skipped[reason]++
+			return nil
+		}
+
+		id, err := o.IngestFile(ctx, path, IngestOptions{
+			Collection: labels.Collection,
+			Tags:       labels.Tags,
+			Priority:   labels.Priority,
+			Filter:     opts,
+			Skipped:    report.Skipped,
+		})
 		if err != nil {
 			return fmt.Errorf("ingest %s: %w", path, err)
 		}
-		ids = append(ids, id)
+		report.IDs = append(report.IDs, id)
 		return nil
 	}
 
-	if err := filepath.WalkDir(dirPath, walkFn); err != nil {
-		return ids, err
+	if err := filepath.WalkDir(root, walkFn); err != nil {
+		return report, err
 	}
 
-	return ids, nil
+	return report, nil
 }
 
-// ProcessPending processes all pending files.
-func (o *Orchestrator) ProcessPending(ctx context.Context) error {
-	// Query pending files
-	rows, err := o.corpusDB.QueryContext(ctx, `
-		SELECT id, mime_type FROM raw_files WHERE status = 'pending' LIMIT 100
-	`)
+// skipReason returns why a walked entry is skipped by name, or "".
+func skipReason(ignore *ignoreMatcher, path, rel string, isDir bool, opts DirOptions) string {
+	if ignore.ignored(path, isDir) {
+		return SkipIgnored
+	}
+	for _, glob := range opts.Exclude {
+		if globMatch(glob, rel) {
+			return SkipExcluded
+		}
+	}
+	if isDir || len(opts.Include) == 0 {
+		return ""
+	}
+	for _, glob := range opts.Include {
+		if globMatch(glob, rel) {
+			return ""
+		}
+	}
+	return SkipNotIncluded
+}
+
+// contentSkipReason returns why a file is skipped by size or content, or "".
+func contentSkipReason(path string, maxSize int64) string {
+	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return SkipUnreadable
 	}
-	defer rows.Close()
+	defer file.Close()
 
-	type pendingFile struct {
-		ID       string
-		MimeType string
+	info, err := file.Stat()
+	if err != nil {
+		return SkipUnreadable
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		return SkipTooLarge
 	}
-	var pending []pendingFile
 
-	for rows.Next() {
-		var p pendingFile
-		if err := rows.Scan(&p.ID, &p.MimeType); err != nil {
-			continue
-		}
-		pending = append(pending, p)
+	header := make([]byte, 512)
+	n, _ := file.Read(header)
+	if looksBinary(path, header[:n]) && archiveKind(path, header[:n]) == "" {
+		return SkipBinary
 	}
+	return ""
+}
 
-	if len(pending) == 0 {
-		return nil
+// ProcessPending processes the pending files in priority order. Each free
+// worker takes the next batch of up to runBatchSize files of one workflow
+// from the processing queue, so files queued meanwhile with a higher
+// priority go first. Batches run concurrently on up to MaxWorkers workers.
+func (o *Orchestrator) ProcessPending(ctx context.Context) error {
+	// Files stay pending until their run is merged: skip those already dispatched
+	if err := o.pruneDispatched(ctx); err != nil {
+		return err
 	}
 
-	// Group by workflow
-	workflowFiles := make(map[string][]string)
-	for _, p := range pending {
-		workflowID, ok := o.workflowMap[p.MimeType]
-		if !ok {
-			continue
-		}
-		workflowFiles[workflowID] = append(workflowFiles[workflowID], p.ID)
+	routes, err := o.ListRoutes(ctx)
+	if err != nil {
+		return fmt.Errorf("load mime routes: %w", err)
 	}
 
-	// Execute workflows
-	for workflowID, fileIDs := range workflowFiles {
-		cfg := workflow.RunConfig{
-			BatchSize: 10,
-			Parameters: map[string]string{
-				"file_ids": strings.Join(fileIDs, ","),
-			},
+	job, ok, err := o.nextJob(ctx, routes)
+	if err != nil || !ok {
+		return err
+	}
+	o.runJobs(ctx, job, func() (runJob, bool) {
+		job, ok, err := o.nextJob(ctx, routes)
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "next job: %v\n", err)
 		}
+		return job, ok
+	})
+	return ctx.Err()
+}
 
-		run, err := o.engine.Run(ctx, workflowID, cfg)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "workflow %s failed: %v\n", workflowID, err)
-			continue
+// pruneDispatched forgets dispatched files that are no longer pending (their
+// run was merged) or whose run failed since they were dispatched.
+func (o *Orchestrator) pruneDispatched(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.dispatched) > 0 {
+		ids := make([]string, 0, len(o.dispatched))
+		for id := range o.dispatched {
+			ids = append(ids, id)
 		}
+		list, _ := json.Marshal(ids)
 
-		// Move run db to queue
-		queuePath := filepath.Join(o.dataDir, "queue", "pending", filepath.Base(run.DBPath))
-		if err := os.Rename(run.DBPath, queuePath); err != nil {
-			fmt.Fprintf(os.Stderr, "move run to queue: %v\n", err)
+		rows, err := o.corpusDB.QueryContext(ctx, `
+			SELECT id, last_failed_at FROM raw_files
+			WHERE status = 'pending' AND id IN (SELECT value FROM json_each(?))
+		`, string(list))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		still := make(map[string]time.Time)
+		for rows.Next() {
+			var id string
+			var failedAt time.Time
+			if err := rows.Scan(&id, db.Time(&failedAt)); err != nil {
+				return err
+			}
+			// Timestamps have second precision
+			at := o.dispatched[id]
+			if failedAt.IsZero() || failedAt.Before(at.Truncate(time.Second)) {
+				still[id] = at
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
 		}
+		o.dispatched = still
 	}
-
 	return nil
 }
 
-// Search executes a search query.
-func (o *Orchestrator) Search(ctx context.Context, query string, topK int) ([]SearchResult, error) {
-	cfg := workflow.RunConfig{
-		Parameters: map[string]string{
-			"query":  query,
-			"top_k":  fmt.Sprintf("%d", topK),
-			"layers": "structure,lexical,blend",
-		},
+// SearchOptions selects how a query is searched.
+type SearchOptions struct {
+	Profile string // search_configs id, "default" when empty
+	TopK    int    // overrides the profile top_k when > 0
+	// Collection and Where restrict the search to chunks of files in a
+	// collection and carrying all the given tags.
+	Collection string
+	Where      map[string]string
+	// AllVersions also searches retired document versions and deleted files
+	AllVersions bool
+}
+
+// Search executes a search query with the layers, weights and cutoffs of a
+// search profile. Profiles with rerank enabled reorder the results by query
+// term coverage of the full chunk content.
+func (o *Orchestrator) Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult, error) {
+	if opts.Profile == "" {
+		opts.Profile = "default"
+	}
+	profile, err := workflow.NewLoader(o.workflowsDB).GetSearchProfile(ctx, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if !profile.Active {
+		return nil, fmt.Errorf("search profile %s is not active", profile.ID)
+	}
+	if opts.TopK > 0 {
+		profile.TopK = opts.TopK
+	}
+
+	params := profile.Parameters()
+	params["query"] = query
+	params["collection"] = opts.Collection
+	where, _ := json.Marshal(opts.Where)
+	if opts.Where == nil {
+		where = []byte("{}")
+	}
+	params["where"] = string(where)
+	params["all_versions"] = "0"
+	if opts.AllVersions {
+		params["all_versions"] = "1"
 	}
+	cfg := workflow.RunConfig{Parameters: params}
 
 	run, err := o.engine.Run(ctx, "search_v1", cfg)
 	if err != nil {
@@ -303,7 +521,7 @@ func (o *Orchestrator) Search(ctx context.Context, query string, topK int) ([]Se
 
 	rows, err := runDB.QueryContext(ctx, `
 		SELECT chunk_id, score, layer_scores, snippet, file_id
-		FROM _output
+		FROM _results
 		ORDER BY score DESC
 	`)
 	if err != nil {
@@ -321,11 +539,61 @@ func (o *Orchestrator) Search(ctx context.Context, query string, topK int) ([]Se
 		r.LayerScores = layerScores
 		results = append(results, r)
 	}
+	rows.Close()
 
 	// Cleanup run db
 	os.Remove(run.DBPath)
 
-	return results, nil
+	if profile.Rerank {
+		if err := o.rerank(ctx, query, results); err != nil {
+			return nil, fmt.Errorf("rerank: %w", err)
+		}
+	}
+
+	// The cutoffs also apply to reranked scores
+	kept := results[:0]
+	for _, r := range results {
+		if r.Score >= profile.MinScore {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) > profile.TopK {
+		kept = kept[:profile.TopK]
+	}
+
+	return kept, nil
+}
+
+// rerank blends each result score with the share of query terms found in
+// the chunk content, then sorts the results by the new score.
+func (o *Orchestrator) rerank(ctx context.Context, query string, results []SearchResult) error {
+	terms := vector.Tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	for i := range results {
+		var content string
+		err := o.corpusDB.QueryRowContext(ctx, "SELECT content FROM chunks WHERE id = ?", results[i].ChunkID).Scan(&content)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		found := make(map[string]bool)
+		for _, token := range vector.Tokenize(content) {
+			found[token] = true
+		}
+		matched := 0
+		for _, term := range terms {
+			if found[term] {
+				matched++
+			}
+		}
+		coverage := float64(matched) / float64(len(terms))
+		results[i].Score = 0.5*results[i].Score + 0.5*coverage
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return nil
 }
 
 // SearchResult holds a single search result.
@@ -340,12 +608,12 @@ type SearchResult struct {
 
 // Status returns orchestrator status.
 type Status struct {
-	PendingFiles   int            `json:"pending_files"`
-	ProcessedFiles int            `json:"processed_files"`
-	TotalChunks    int            `json:"total_chunks"`
-	TotalVectors   int            `json:"total_vectors"`
-	Workers        []WorkerStatus `json:"workers"`
-	Workflows      []string       `json:"workflows"`
+	PendingFiles   int      `json:"pending_files"`
+	ProcessedFiles int      `json:"processed_files"`
+	TotalChunks    int      `json:"total_chunks"`
+	TotalVectors   int      `json:"total_vectors"`
+	Workers        []Worker `json:"workers"`
+	Workflows      []string `json:"workflows"`
 }
 
 // Status returns current orchestrator status.
@@ -370,14 +638,21 @@ func (o *Orchestrator) Status(ctx context.Context) (*Status, error) {
 	).Scan(&status.TotalVectors)
 
 	// Worker status
+	status.Workers = o.Workers()
+
+	// Routed workflows
+	var routed []string
+	if routes, err := o.ListRoutes(ctx); err == nil {
+		for _, r := range routes {
+			routed = append(routed, r.WorkflowID)
+		}
+	}
 	o.mu.RLock()
-	for _, w := range o.workers {
-		status.Workers = append(status.Workers, w.Status)
+	for _, wfID := range o.workflowMap {
+		routed = append(routed, wfID)
 	}
 	o.mu.RUnlock()
-
-	// Available workflows
-	for _, wfID := range o.workflowMap {
+	for _, wfID := range routed {
 		found := false
 		for _, existing := range status.Workflows {
 			if existing == wfID {
@@ -393,65 +668,26 @@ func (o *Orchestrator) Status(ctx context.Context) (*Status, error) {
 	return status, nil
 }
 
-// SetWorkflowMapping sets the mime type to workflow mapping.
+// SetWorkflowMapping routes a mime type to a workflow for this orchestrator
+// only, ahead of the mime_routes table.
 func (o *Orchestrator) SetWorkflowMapping(mimeType, workflowID string) {
 	o.mu.Lock()
 	defer o.mu.Unlock()
 	o.workflowMap[mimeType] = workflowID
 }
 
-// detectMimeType detects the MIME type of a file.
-func detectMimeType(path string, content []byte) string {
-	// First try by extension
-	ext := filepath.Ext(path)
-	if ext != "" {
-		mimeType := mime.TypeByExtension(ext)
-		if mimeType != "" {
-			// Strip parameters
-			if idx := strings.Index(mimeType, ";"); idx != -1 {
-				mimeType = mimeType[:idx]
-			}
-			return mimeType
-		}
-	}
-
-	// Common extensions not in mime package
-	extMap := map[string]string{
-		".md":   "text/markdown",
-		".go":   "text/x-go",
-		".py":   "text/x-python",
-		".js":   "text/javascript",
-		".ts":   "text/typescript",
-		".rs":   "text/x-rust",
-		".sql":  "text/x-sql",
-		".yaml": "text/yaml",
-		".yml":  "text/yaml",
-		".json": "application/json",
-		".toml": "text/toml",
-	}
-	if mimeType, ok := extMap[strings.ToLower(ext)]; ok {
-		return mimeType
-	}
-
-	// Try content detection for common binary formats
-	if len(content) >= 4 {
-		// PDF
-		if string(content[:4]) == "%PDF" {
-			return "application/pdf"
-		}
-		// ZIP-based (docx, xlsx, etc.)
-		if content[0] == 0x50 && content[1] == 0x4B {
-			if ext == ".docx" {
-				return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
-			}
-			if ext == ".xlsx" {
-				return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
-			}
-			return "application/zip"
-		}
-	}
+// SetEventHandler sets the handler receiving progress events of every run
+// started by the orchestrator. A nil handler disables events.
+func (o *Orchestrator) SetEventHandler(h workflow.EventHandler) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.onEvent = h
+}
 
-	return "application/octet-stream"
+func (o *Orchestrator) eventHandler() workflow.EventHandler {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.onEvent
 }
 
 // GetChunk retrieves a chunk by ID.
@@ -482,16 +718,9 @@ type Chunk struct {
 
 // GetFile retrieves a file by ID.
 func (o *Orchestrator) GetFile(ctx context.Context, fileID string) (*File, error) {
-	var f File
-	err := o.corpusDB.QueryRowContext(ctx, `
-		SELECT id, source_path, mime_type, size, status, imported_at
-		FROM raw_files
-		WHERE id = ?
-	`, fileID).Scan(&f.ID, &f.SourcePath, &f.MimeType, &f.Size, &f.Status, &f.ImportedAt)
-	if err != nil {
-		return nil, err
-	}
-	return &f, nil
+	return scanFile(o.corpusDB.QueryRowContext(ctx,
+		"SELECT "+fileColumns+" FROM raw_files WHERE id = ?", fileID,
+	))
 }
 
 // File represents a raw file.
@@ -502,11 +731,29 @@ type File struct {
 	Size       int64     `json:"size"`
 	Status     string    `json:"status"`
 	ImportedAt time.Time `json:"imported_at"`
+
+	Attempts      int       `json:"attempts,omitempty"` // processing failures
+	LastError     string    `json:"last_error,omitempty"`
+	LastFailedAt  time.Time `json:"last_failed_at,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at,omitempty"` // backoff of a pending file
+}
+
+const fileColumns = `id, source_path, mime_type, size, status, imported_at,
+	attempts, COALESCE(last_error, ''), last_failed_at, next_attempt_at`
+
+func scanFile(row interface{ Scan(...any) error }) (*File, error) {
+	var f File
+	err := row.Scan(&f.ID, &f.SourcePath, &f.MimeType, &f.Size, &f.Status, db.Time(&f.ImportedAt),
+		&f.Attempts, &f.LastError, db.Time(&f.LastFailedAt), db.Time(&f.NextAttemptAt))
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
 }
 
 // ListFiles returns files with optional filtering.
 func (o *Orchestrator) ListFiles(ctx context.Context, status string, limit int) ([]File, error) {
-	query := "SELECT id, source_path, mime_type, size, status, imported_at FROM raw_files"
+	query := "SELECT " + fileColumns + " FROM raw_files"
 	args := []any{}
 
 	if status != "" {
@@ -526,12 +773,122 @@ func (o *Orchestrator) ListFiles(ctx context.Context, status string, limit int)
 
 	var files []File
 	for rows.Next() {
-		var f File
-		if err := rows.Scan(&f.ID, &f.SourcePath, &f.MimeType, &f.Size, &f.Status, &f.ImportedAt); err != nil {
+		f, err := scanFile(rows)
+		if err != nil {
 			continue
 		}
-		files = append(files, f)
+		files = append(files, *f)
 	}
 
 	return files, nil
 }
+
+// Retry resets the failure count of a failed or backed-off file so the next
+// poll processes it. fileID may be a unique prefix of the id.
+func (o *Orchestrator) Retry(ctx context.Context, fileID string) (*File, error) {
+	id, err := o.resolveFileID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := o.corpusDB.ExecContext(ctx, `
+		UPDATE raw_files SET
+			status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = NULL
+		WHERE id = ? AND (status = 'failed' OR (status = 'pending' AND attempts > 0))
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("file %s has not failed", id)
+	}
+	o.audit(ctx, "retry", id, "{}")
+
+	return o.GetFile(ctx, id)
+}
+
+// Rechunk queues a file the corpus holds segments of so it is chunked again
+// from them: its next run reuses the segments of the same extractor version
+// instead of extracting the file again. The chunks of earlier runs are kept
+// unless the merge policy of the workflow replaces them. fileID may be a
+// unique prefix of the id. It returns the file and its number of segments.
+func (o *Orchestrator) Rechunk(ctx context.Context, fileID string) (*File, int, error) {
+	id, err := o.resolveFileID(ctx, fileID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var segments int
+	if err := o.corpusDB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM extracted_segments WHERE file_id = ?", id,
+	).Scan(&segments); err != nil {
+		return nil, 0, err
+	}
+	if segments == 0 {
+		return nil, 0, fmt.Errorf("file %s has no stored segments", id)
+	}
+
+	res, err := o.corpusDB.ExecContext(ctx, `
+		UPDATE raw_files SET
+			status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = NULL
+		WHERE id = ? AND deleted_at IS NULL AND superseded_by IS NULL
+	`, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, 0, fmt.Errorf("file %s is deleted or superseded", id)
+	}
+	o.audit(ctx, "rechunk", id, fmt.Sprintf(`{"segments":%d}`, segments))
+
+	f, err := o.GetFile(ctx, id)
+	return f, segments, err
+}
+
+// resolveFileID returns the id of the file fileID is a unique prefix of.
+func (o *Orchestrator) resolveFileID(ctx context.Context, fileID string) (string, error) {
+	var ids []string
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT id FROM raw_files WHERE id LIKE ? || '%' LIMIT 2", fileID,
+	)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("file %s not found", fileID)
+	case 2:
+		return "", fmt.Errorf("file id %s is ambiguous", fileID)
+	}
+	return ids[0], nil
+}
+
+// ResolveFiles returns the files a reference designates: the file it is a
+// unique id prefix of, or every version of the document it names by id or
+// source path.
+func (o *Orchestrator) ResolveFiles(ctx context.Context, ref string) ([]string, error) {
+	if doc, err := o.History(ctx, ref); err == nil {
+		fileIDs := make([]string, 0, len(doc.Versions))
+		for _, v := range doc.Versions {
+			if !slices.Contains(fileIDs, v.FileID) {
+				fileIDs = append(fileIDs, v.FileID)
+			}
+		}
+		return fileIDs, nil
+	}
+
+	id, err := o.resolveFileID(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("no file or document matches %s", ref)
+	}
+	return []string{id}, nil
+}
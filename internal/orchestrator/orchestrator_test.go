@@ -0,0 +1,32 @@
+package orchestrator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"goraglite/internal/db"
+	"goraglite/internal/workflow"
+)
+
+// newTestOrchestrator opens the databases of cfg.DataDir, loads the
+// built-in workflows and returns an orchestrator over them.
+func newTestOrchestrator(t *testing.T, cfg Config) *Orchestrator {
+	t.Helper()
+	corpusDB, err := db.OpenCorpus(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { corpusDB.Close() })
+	workflowsDB, err := db.OpenWorkflows(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { workflowsDB.Close() })
+	if err := workflow.NewLoader(workflowsDB).LoadBuiltins(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := workflow.NewEngine(corpusDB, workflowsDB, filepath.Join(cfg.DataDir, "runs"))
+	return New(corpusDB, workflowsDB, engine, cfg)
+}
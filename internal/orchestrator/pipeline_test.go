@@ -0,0 +1,157 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goraglite/internal/extract"
+	"goraglite/internal/merger"
+)
+
+// newTestPipeline returns an orchestrator with the built-in extractors and
+// the merger of its data directory.
+func newTestPipeline(t *testing.T) (*Orchestrator, *merger.Merger) {
+	t.Helper()
+	o := newTestOrchestrator(t, DefaultConfig(t.TempDir()))
+	extract.NewDefaultRegistry().RegisterAll(o.engine)
+	m, err := merger.New(o.corpusDB, merger.DefaultConfig(o.dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, m
+}
+
+// processAndMerge runs the workflows of the pending files and merges their
+// runs, which must all merge.
+func processAndMerge(t *testing.T, o *Orchestrator, m *merger.Merger) {
+	t.Helper()
+	ctx := context.Background()
+	if err := o.ProcessPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	results, err := m.Drain(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		t.Fatal("no run queued")
+	}
+	for _, r := range results {
+		if r.Status != merger.MergeMerged {
+			t.Fatalf("run %s of %s: %s %s", r.RunID, r.WorkflowID, r.Status, r.Error)
+		}
+	}
+}
+
+const testMarkdown = "# Title\n\nSome paragraph of markdown text here.\n\n## Section\n\nAnother paragraph with more words in it.\n"
+
+// TestIngestToMerge ingests a file, runs its workflow and merges the run:
+// the file is vectorized and its chunks trace back to it.
+func TestIngestToMerge(t *testing.T) {
+	ctx := context.Background()
+	o, m := newTestPipeline(t)
+
+	src := filepath.Join(t.TempDir(), "doc.md")
+	if err := os.WriteFile(src, []byte(testMarkdown), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileID, err := o.Ingest(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processAndMerge(t, o, m)
+
+	var status string
+	var chunks, traced int
+	if err := o.corpusDB.QueryRowContext(ctx, `
+		SELECT r.status,
+			(SELECT COUNT(*) FROM chunks c WHERE c.file_id = r.id AND c.tombstoned_at IS NULL),
+			(SELECT COUNT(*) FROM chunks c JOIN chunk_lineage l ON l.chunk_id = c.id
+			 WHERE c.file_id = r.id AND json_extract(l.source_chain, '$[0]') = r.id)
+		FROM raw_files r WHERE r.id = ?
+	`, fileID).Scan(&status, &chunks, &traced); err != nil {
+		t.Fatal(err)
+	}
+	if status != "vectorized" || chunks == 0 || traced != chunks {
+		t.Errorf("file %s, %d chunks, %d traced to the file", status, chunks, traced)
+	}
+}
+
+// TestWatchVersions follows a watched file through a modification and a
+// deletion: the new version replaces the chunks of the old one, and the
+// deletion tombstones the chunks of the new one.
+func TestWatchVersions(t *testing.T) {
+	ctx := context.Background()
+	o, m := newTestPipeline(t)
+	root := t.TempDir()
+	path := filepath.Join(root, "doc.md")
+	cfg := DefaultWatchConfig(root)
+	cfg.Debounce = 0
+
+	// live returns the number of live chunks of the versions of the document
+	live := func() map[int]int {
+		t.Helper()
+		rows, err := o.corpusDB.QueryContext(ctx, `
+			SELECT v.version, COUNT(c.id) FROM document_versions v
+			LEFT JOIN chunks c ON c.file_id = v.file_id AND c.tombstoned_at IS NULL
+			GROUP BY v.version
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		counts := make(map[int]int)
+		for rows.Next() {
+			var version, n int
+			if err := rows.Scan(&version, &n); err != nil {
+				t.Fatal(err)
+			}
+			counts[version] = n
+		}
+		return counts
+	}
+	scan := func(want func(*WatchReport) int) {
+		t.Helper()
+		report, err := o.ScanWatched(ctx, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want(report) != 1 {
+			t.Fatalf("scan: %+v", *report)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(testMarkdown), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	scan(func(r *WatchReport) int { return r.Added })
+	processAndMerge(t, o, m)
+	if got := live(); len(got) != 1 || got[1] == 0 {
+		t.Fatalf("after ingest: live chunks by version %v", got)
+	}
+
+	if err := os.WriteFile(path, []byte(testMarkdown+"\n## Added\n\nA paragraph added by the second version.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	scan(func(r *WatchReport) int { return r.Modified })
+	processAndMerge(t, o, m)
+	if got := live(); got[1] != 0 || got[2] == 0 {
+		t.Fatalf("after modification: live chunks by version %v", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	scan(func(r *WatchReport) int { return r.Deleted })
+	if got := live(); got[2] == 0 {
+		t.Fatalf("deletion tombstoned before the merger ran: %v", got)
+	}
+	if _, err := m.ApplyTombstones(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := live(); got[1] != 0 || got[2] != 0 {
+		t.Errorf("after deletion: live chunks by version %v", got)
+	}
+}
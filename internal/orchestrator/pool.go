@@ -0,0 +1,180 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goraglite/internal/workflow"
+)
+
+// runBatchSize is the number of files handed to one workflow run. Batches of
+// the same workflow run concurrently on different workers.
+const runBatchSize = 10
+
+// runJob is a batch of files processed by one workflow run.
+type runJob struct {
+	workflowID string
+	fileIDs    []string
+}
+
+// runJobs executes jobs on up to maxWorkers concurrent workers, starting
+// with first. next is called each time a worker is free and returns the
+// job it takes, or false when there is none left. Each run has its own
+// database, so runs never share state. When ctx is cancelled no new job is
+// started, but the runs in flight are completed and queued.
+func (o *Orchestrator) runJobs(ctx context.Context, first runJob, next func() (runJob, bool)) {
+	n := max(o.maxWorkers, 1)
+
+	queue := make(chan runJob)
+	free := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		w := o.worker(fmt.Sprintf("worker-%d", i))
+		free <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				o.execute(ctx, w, job)
+				free <- struct{}{}
+			}
+		}()
+	}
+
+	// A job is picked only once a worker is free to run it
+	job := &first
+dispatch:
+	for {
+		select {
+		case <-free:
+		case <-ctx.Done():
+			break dispatch
+		}
+		if job == nil {
+			planned, ok := next()
+			if !ok {
+				break
+			}
+			job = &planned
+		}
+		queue <- *job
+		job = nil
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		o.mu.Lock()
+		for _, w := range o.workers {
+			w.Status = WorkerStopped
+		}
+		o.mu.Unlock()
+	}
+}
+
+// execute runs one job on a worker and moves the run database to the merge
+// queue. Failed runs are queued too so the merger records them.
+func (o *Orchestrator) execute(ctx context.Context, w *Worker, job runJob) {
+	o.mu.Lock()
+	w.Status, w.Workflow, w.CurrentRun, w.StartedAt = WorkerBusy, job.workflowID, "", time.Now()
+	o.mu.Unlock()
+
+	defer func() {
+		o.mu.Lock()
+		w.Status, w.Workflow, w.CurrentRun, w.StartedAt = WorkerIdle, "", "", time.Time{}
+		w.RunsDone++
+		o.mu.Unlock()
+	}()
+
+	handler := o.eventHandler()
+	cfg := workflow.RunConfig{
+		BatchSize: runBatchSize,
+		Parameters: map[string]string{
+			"file_ids": strings.Join(job.fileIDs, ","),
+		},
+		WorkerID: w.ID,
+		OnEvent: func(ev workflow.Event) {
+			if ev.Type == workflow.EventRunStarted {
+				o.mu.Lock()
+				w.CurrentRun = ev.RunID
+				o.mu.Unlock()
+			}
+			if handler != nil {
+				handler(ev)
+			}
+		},
+	}
+
+	// Cancellation only stops dispatching: a started run is finished
+	run, err := o.engine.Run(context.WithoutCancel(ctx), job.workflowID, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "workflow %s failed: %v\n", job.workflowID, err)
+		reason := fmt.Sprintf("workflow %s: %v", job.workflowID, err)
+		if _, ferr := o.corpusDB.RecordFileFailure(context.WithoutCancel(ctx), "orchestrator", job.fileIDs, reason); ferr != nil {
+			fmt.Fprintf(os.Stderr, "record failure: %v\n", ferr)
+		}
+		if run == nil || run.DBPath == "" {
+			return
+		}
+	}
+
+	// Move run db to queue
+	queuePath := filepath.Join(o.dataDir, "queue", "pending", filepath.Base(run.DBPath))
+	if err := os.Rename(run.DBPath, queuePath); err != nil {
+		fmt.Fprintf(os.Stderr, "move run to queue: %v\n", err)
+	}
+}
+
+// worker returns the worker with the given ID, registering it when new.
+func (o *Orchestrator) worker(id string) *Worker {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	w, ok := o.workers[id]
+	if !ok {
+		w = &Worker{ID: id}
+		o.workers[id] = w
+	}
+	w.Status = WorkerIdle
+	return w
+}
+
+// Workers returns a snapshot of the worker states, ordered by ID.
+func (o *Orchestrator) Workers() []Worker {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	workers := make([]Worker, 0, len(o.workers))
+	for _, w := range o.workers {
+		workers = append(workers, *w)
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+	return workers
+}
+
+// Start polls for pending files every PollInterval and processes them on the
+// worker pool until ctx is cancelled. In-flight runs are finished and queued
+// before Start returns.
+func (o *Orchestrator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.ProcessPending(ctx); err != nil && ctx.Err() == nil {
+			// Log error but continue
+			fmt.Fprintf(os.Stderr, "process pending: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// QueuedFile is a file of the processing queue.
+type QueuedFile struct {
+	ID         string    `json:"id"`
+	MimeType   string    `json:"mime_type"`
+	Collection string    `json:"collection,omitempty"`
+	Priority   int       `json:"priority"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// Queue returns the first files of the processing queue, highest priority
+// first, oldest first within a priority. Files already dispatched to a run
+// are not listed. A limit of 0 returns them all.
+func (o *Orchestrator) Queue(ctx context.Context, limit int) ([]QueuedFile, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := o.corpusDB.QueryContext(ctx, `
+		SELECT id, mime_type, COALESCE(collection, ''), priority, imported_at
+		FROM processing_queue
+		WHERE id NOT IN (SELECT value FROM json_each(?))
+		ORDER BY priority DESC, imported_at, id
+		LIMIT ?
+	`, o.dispatchedJSON(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []QueuedFile
+	for rows.Next() {
+		var f QueuedFile
+		if err := rows.Scan(&f.ID, &f.MimeType, &f.Collection, &f.Priority, db.Time(&f.ImportedAt)); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// dispatchedJSON returns the dispatched files as a JSON array.
+func (o *Orchestrator) dispatchedJSON() string {
+	o.mu.RLock()
+	ids := make([]string, 0, len(o.dispatched))
+	for id := range o.dispatched {
+		ids = append(ids, id)
+	}
+	o.mu.RUnlock()
+
+	list, _ := json.Marshal(ids)
+	return string(list)
+}
+
+// queueGroup is the queued files of a MIME type at a priority.
+type queueGroup struct {
+	mimeType string
+	priority int
+	oldest   string
+}
+
+// nextJob takes the next batch of the processing queue: files at the
+// highest priority, of the workflow served least recently among those with
+// files at that priority, oldest first. The files are marked dispatched so
+// the next call skips them. Files no workflow is routed for fail.
+func (o *Orchestrator) nextJob(ctx context.Context, routes []Route) (runJob, bool, error) {
+	dispatched := o.dispatchedJSON()
+
+	rows, err := o.corpusDB.QueryContext(ctx, `
+		SELECT mime_type, priority, MIN(imported_at) FROM processing_queue
+		WHERE id NOT IN (SELECT value FROM json_each(?))
+		GROUP BY mime_type, priority
+		ORDER BY priority DESC
+	`, dispatched)
+	if err != nil {
+		return runJob{}, false, err
+	}
+	var groups []queueGroup
+	for rows.Next() {
+		var g queueGroup
+		if err := rows.Scan(&g.mimeType, &g.priority, &g.oldest); err != nil {
+			rows.Close()
+			return runJob{}, false, err
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return runJob{}, false, err
+	}
+
+	// Workflows with files at the highest routable priority
+	type candidate struct {
+		mimeTypes []string
+		oldest    string
+	}
+	candidates := make(map[string]*candidate)
+	top := 0
+	for _, g := range groups {
+		workflowID, ok := o.resolveWorkflow(routes, g.mimeType)
+		if !ok {
+			// Retrying cannot help until a route is added
+			if err := o.failUnroutable(ctx, g.mimeType, dispatched); err != nil {
+				return runJob{}, false, err
+			}
+			continue
+		}
+		if len(candidates) > 0 && g.priority < top {
+			continue
+		}
+		top = g.priority
+		c, ok := candidates[workflowID]
+		if !ok {
+			c = &candidate{oldest: g.oldest}
+			candidates[workflowID] = c
+		}
+		c.mimeTypes = append(c.mimeTypes, g.mimeType)
+		c.oldest = min(c.oldest, g.oldest)
+	}
+	if len(candidates) == 0 {
+		return runJob{}, false, nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Fairness: the workflow served least recently, then the oldest files
+	workflowID := ""
+	for id, c := range candidates {
+		if workflowID == "" {
+			workflowID = id
+			continue
+		}
+		best := candidates[workflowID]
+		if o.served[id] < o.served[workflowID] ||
+			(o.served[id] == o.served[workflowID] && (c.oldest < best.oldest || c.oldest == best.oldest && id < workflowID)) {
+			workflowID = id
+		}
+	}
+
+	mimeTypes, _ := json.Marshal(candidates[workflowID].mimeTypes)
+	rows, err = o.corpusDB.QueryContext(ctx, `
+		SELECT id FROM processing_queue
+		WHERE priority = ? AND mime_type IN (SELECT value FROM json_each(?))
+			AND id NOT IN (SELECT value FROM json_each(?))
+		ORDER BY imported_at, id
+		LIMIT ?
+	`, top, string(mimeTypes), dispatched, runBatchSize)
+	if err != nil {
+		return runJob{}, false, err
+	}
+	defer rows.Close()
+
+	job := runJob{workflowID: workflowID}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return runJob{}, false, err
+		}
+		job.fileIDs = append(job.fileIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return runJob{}, false, err
+	}
+	if len(job.fileIDs) == 0 {
+		return runJob{}, false, nil
+	}
+
+	now := time.Now()
+	for _, id := range job.fileIDs {
+		o.dispatched[id] = now
+	}
+	o.serveSeq++
+	o.served[workflowID] = o.serveSeq
+	return job, true, nil
+}
+
+// failUnroutable fails the queued files of a MIME type no workflow is
+// routed for.
+func (o *Orchestrator) failUnroutable(ctx context.Context, mimeType, dispatched string) error {
+	rows, err := o.corpusDB.QueryContext(ctx, `
+		SELECT id FROM processing_queue
+		WHERE mime_type = ? AND id NOT IN (SELECT value FROM json_each(?))
+	`, mimeType, dispatched)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("no workflow routed for %s", mimeType)
+	return o.corpusDB.FailFiles(ctx, "orchestrator", ids, reason)
+}
+
+// CollectionPriority is the default priority of the files of a collection.
+type CollectionPriority struct {
+	Collection string    `json:"collection"`
+	Priority   int       `json:"priority"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ListCollectionPriorities returns the collection priorities, highest first.
+func (o *Orchestrator) ListCollectionPriorities(ctx context.Context) ([]CollectionPriority, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT collection, priority, updated_at FROM collection_priorities ORDER BY priority DESC, collection",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var priorities []CollectionPriority
+	for rows.Next() {
+		var p CollectionPriority
+		if err := rows.Scan(&p.Collection, &p.Priority, db.Time(&p.UpdatedAt)); err != nil {
+			return nil, err
+		}
+		priorities = append(priorities, p)
+	}
+	return priorities, rows.Err()
+}
+
+// SetCollectionPriority sets the priority of the files of a collection
+// that were not given one at ingest, queued ones included.
+func (o *Orchestrator) SetCollectionPriority(ctx context.Context, collection string, priority int) error {
+	if collection == "" {
+		return fmt.Errorf("collection name required")
+	}
+	_, err := o.corpusDB.ExecContext(ctx, `
+		INSERT INTO collection_priorities (collection, priority) VALUES (?, ?)
+		ON CONFLICT(collection) DO UPDATE SET priority = excluded.priority, updated_at = datetime('now')
+	`, collection, priority)
+	if err != nil {
+		return fmt.Errorf("set priority of %s: %w", collection, err)
+	}
+	o.audit(ctx, "set_collection_priority", collection, fmt.Sprintf(`{"priority":%d}`, priority))
+	return nil
+}
+
+// DeleteCollectionPriority removes the priority of a collection.
+func (o *Orchestrator) DeleteCollectionPriority(ctx context.Context, collection string) error {
+	res, err := o.corpusDB.ExecContext(ctx, "DELETE FROM collection_priorities WHERE collection = ?", collection)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("collection %s has no priority", collection)
+	}
+	o.audit(ctx, "delete_collection_priority", collection, "{}")
+	return nil
+}
+
+// SetFilePriority sets the priority of a file, overriding the one of its
+// collection. fileID may be a unique prefix of the id.
+func (o *Orchestrator) SetFilePriority(ctx context.Context, fileID string, priority int) (string, error) {
+	id, err := o.resolveFileID(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := o.corpusDB.ExecContext(ctx, "UPDATE raw_files SET priority = ? WHERE id = ?", priority, id); err != nil {
+		return "", err
+	}
+	o.audit(ctx, "set_file_priority", id, fmt.Sprintf(`{"priority":%d}`, priority))
+	return id, nil
+}
@@ -0,0 +1,95 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestNextJob checks the order batches are taken from the processing
+// queue: priority first, then the workflow served least recently, then the
+// oldest files.
+func TestNextJob(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOrchestrator(t, DefaultConfig(t.TempDir()))
+
+	insert := func(id, mimeType, collection, importedAt, nextAttempt string) {
+		t.Helper()
+		_, err := o.corpusDB.ExecContext(ctx, `
+			INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum, collection, imported_at, next_attempt_at)
+			VALUES (?1, '/' || ?1, ?2, 1, ?1, ?1, NULLIF(?3, ''), ?4, NULLIF(?5, ''))
+		`, id, mimeType, collection, importedAt, nextAttempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var markdown []string
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("m%02d", i)
+		markdown = append(markdown, id)
+		insert(id, "text/markdown", "", fmt.Sprintf("2026-01-01 00:%02d:00", i), "")
+	}
+	for _, id := range []string{"g0", "g1", "g2"} {
+		insert(id, "text/x-go", "", "2026-01-02 00:00:00", "")
+	}
+	insert("p0", "application/pdf", "urgent", "2026-01-03 00:00:00", "")
+	insert("b0", "text/markdown", "", "2025-12-31 00:00:00", "2999-01-01 00:00:00") // backing off
+	insert("u0", "application/x-unknown", "", "2026-01-01 00:00:00", "")
+	if err := o.SetCollectionPriority(ctx, "urgent", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := o.Queue(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 17 || queue[0].ID != "p0" || queue[0].Priority != 5 || queue[1].ID != "m00" {
+		t.Fatalf("queue = %+v", queue)
+	}
+
+	routes := []Route{
+		{Pattern: "text/markdown", WorkflowID: "markdown"},
+		{Pattern: "text/x-go", WorkflowID: "go"},
+		{Pattern: "application/pdf", WorkflowID: "pdf"},
+	}
+
+	next := func() runJob {
+		t.Helper()
+		job, ok, err := o.nextJob(ctx, routes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return runJob{}
+		}
+		return job
+	}
+	expect := func(step int, workflowID string, fileIDs ...string) {
+		t.Helper()
+		job := next()
+		if job.workflowID != workflowID || !reflect.DeepEqual(job.fileIDs, fileIDs) {
+			t.Fatalf("job %d = %s %v, want %s %v", step, job.workflowID, job.fileIDs, workflowID, fileIDs)
+		}
+	}
+
+	expect(1, "pdf", "p0")
+	expect(2, "markdown", markdown[:runBatchSize]...)
+	// A file queued at a higher priority goes ahead of the other workflows
+	if _, err := o.SetFilePriority(ctx, "m11", 9); err != nil {
+		t.Fatal(err)
+	}
+	expect(3, "markdown", "m11")
+	// Otherwise the workflow served least recently goes first
+	expect(4, "go", "g0", "g1", "g2")
+	expect(5, "markdown", "m10")
+	expect(6, "")
+
+	var status string
+	if err := o.corpusDB.QueryRowContext(ctx, "SELECT status FROM raw_files WHERE id = 'u0'").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" {
+		t.Errorf("unroutable file is %s, want failed", status)
+	}
+}
@@ -0,0 +1,115 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"goraglite/internal/db"
+	"goraglite/internal/workflow"
+)
+
+// Route sends files of a MIME type, or of MIME types matching a glob, to a
+// workflow.
+type Route struct {
+	Pattern    string    `json:"pattern"`
+	WorkflowID string    `json:"workflow_id"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ListRoutes returns the routes of the mime_routes table.
+func (o *Orchestrator) ListRoutes(ctx context.Context) ([]Route, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT pattern, workflow_id, updated_at FROM mime_routes ORDER BY pattern",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var routes []Route
+	for rows.Next() {
+		var r Route
+		if err := rows.Scan(&r.Pattern, &r.WorkflowID, db.Time(&r.UpdatedAt)); err != nil {
+			return nil, err
+		}
+		routes = append(routes, r)
+	}
+	return routes, rows.Err()
+}
+
+// SetRoute routes a MIME type or glob to a workflow.
+func (o *Orchestrator) SetRoute(ctx context.Context, pattern, workflowID string) error {
+	if _, err := path.Match(pattern, ""); err != nil || !strings.Contains(pattern, "/") {
+		return fmt.Errorf("invalid mime pattern %q", pattern)
+	}
+
+	workflows, err := workflow.NewLoader(o.workflowsDB).ListWorkflows(ctx)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, wf := range workflows {
+		if wf.ID == workflowID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("workflow %s %w", workflowID, workflow.ErrNotFound)
+	}
+
+	_, err = o.corpusDB.ExecContext(ctx, `
+		INSERT INTO mime_routes (pattern, workflow_id) VALUES (?, ?)
+		ON CONFLICT(pattern) DO UPDATE SET workflow_id = excluded.workflow_id, updated_at = datetime('now')
+	`, pattern, workflowID)
+	if err != nil {
+		return fmt.Errorf("set route %s: %w", pattern, err)
+	}
+	o.audit(ctx, "set_route", pattern, fmt.Sprintf(`{"workflow_id":%q}`, workflowID))
+	return nil
+}
+
+// DeleteRoute removes a route.
+func (o *Orchestrator) DeleteRoute(ctx context.Context, pattern string) error {
+	res, err := o.corpusDB.ExecContext(ctx, "DELETE FROM mime_routes WHERE pattern = ?", pattern)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("route %s not found", pattern)
+	}
+	o.audit(ctx, "delete_route", pattern, "{}")
+	return nil
+}
+
+// routeMimeType returns the workflow of a MIME type: an exact route first,
+// then the matching glob with the most literal characters.
+func routeMimeType(routes []Route, mimeType string) (string, bool) {
+	best, bestScore := "", -1
+	for _, r := range routes {
+		if r.Pattern == mimeType {
+			return r.WorkflowID, true
+		}
+		if ok, _ := path.Match(r.Pattern, mimeType); ok {
+			if score := len(r.Pattern) - strings.Count(r.Pattern, "*"); score > bestScore {
+				best, bestScore = r.WorkflowID, score
+			}
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// resolveWorkflow returns the workflow of a MIME type. Mappings set with
+// SetWorkflowMapping take precedence over the routing table.
+func (o *Orchestrator) resolveWorkflow(routes []Route, mimeType string) (string, bool) {
+	o.mu.RLock()
+	workflowID, ok := o.workflowMap[mimeType]
+	o.mu.RUnlock()
+	if ok {
+		return workflowID, true
+	}
+	return routeMimeType(routes, mimeType)
+}
@@ -0,0 +1,95 @@
+package orchestrator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"goraglite/internal/db"
+	"goraglite/internal/vector"
+	"goraglite/internal/workflow"
+)
+
+// TestSearch runs the built-in search workflow against a small corpus.
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	corpusDB, err := db.OpenCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corpusDB.Close()
+	workflowsDB, err := db.OpenWorkflows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workflowsDB.Close()
+	if err := workflow.NewLoader(workflowsDB).LoadBuiltins(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The collection looks numeric: it must still be compared as text
+	for _, stmt := range []string{
+		`INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum, status, collection)
+		 VALUES ('f1', '/docs/merger.md', 'text/markdown', 100, 'f1', 'f1', 'chunked', '007'),
+		        ('f2', '/docs/other.md', 'text/markdown', 100, 'f2', 'f2', 'chunked', '7')`,
+		`INSERT INTO chunks (id, file_id, content, token_count, chunk_type, hash, position, collection)
+		 VALUES ('c1', 'f1', 'The merger applies run outputs. The merger is the only writer.', 15, 'semantic', 'h1', 0, '007'),
+		        ('c2', 'f1', 'Runs wait in the queue until the merger picks them up.', 13, 'semantic', 'h2', 1, '007'),
+		        ('c3', 'f1', 'Workers extract and chunk pending files.', 10, 'semantic', 'h3', 2, '007'),
+		        ('c4', 'f2', 'The merger of another collection.', 8, 'semantic', 'h4', 0, '7')`,
+	} {
+		if _, err := corpusDB.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("seed corpus: %v", err)
+		}
+	}
+	for chunkID, vec := range map[string]vector.Vector{"c1": {1, 0, 0}, "c2": {1, 1, 0}, "c3": {0, 0, 1}} {
+		if _, err := corpusDB.ExecContext(ctx, `
+			INSERT INTO chunk_vectors (chunk_id, layer, vector, dimensions, model_version)
+			VALUES (?, 'structure', ?, 3, 'test')
+		`, chunkID, vec.Bytes()); err != nil {
+			t.Fatalf("seed vectors: %v", err)
+		}
+	}
+
+	runsDir := filepath.Join(dir, "runs")
+	if err := os.MkdirAll(runsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	engine := workflow.NewEngine(corpusDB, workflowsDB, runsDir)
+	orch := New(corpusDB, workflowsDB, engine, DefaultConfig(dir))
+
+	results, err := orch.Search(ctx, "merger", SearchOptions{Collection: "007"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want c1 and c2: %+v", len(results), results)
+	}
+	if results[0].ChunkID != "c1" || results[1].ChunkID != "c2" {
+		t.Errorf("results = %s, %s; want c1, c2", results[0].ChunkID, results[1].ChunkID)
+	}
+	if results[0].Score < results[1].Score || results[0].Score > 1 {
+		t.Errorf("scores = %v, %v", results[0].Score, results[1].Score)
+	}
+	if results[0].FileID != "f1" || results[0].Snippet == "" || results[0].LayerScores == "" {
+		t.Errorf("result = %+v", results[0])
+	}
+
+	// Search runs leave no run database behind
+	entries, err := os.ReadDir(runsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".db" {
+			t.Errorf("run database %s left in %s", e.Name(), runsDir)
+		}
+	}
+
+	if _, err := orch.Search(ctx, "?!", SearchOptions{}); err == nil {
+		t.Error("search without terms succeeded")
+	}
+}
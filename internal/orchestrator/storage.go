@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"goraglite/internal/storage"
+)
+
+// storageRoot returns the root of the external storage.
+func (o *Orchestrator) storageRoot() string {
+	return filepath.Join(o.dataDir, "storage")
+}
+
+// compresses reports whether new objects of a MIME type are gzipped: the
+// storage_compression config is gzip and the type is not compressed already.
+func (o *Orchestrator) compresses(ctx context.Context, mimeType string) bool {
+	return o.StorageCompression(ctx) == storage.Gzip && storage.Compressible(mimeType)
+}
+
+// StorageCompression returns the compression of new objects: gzip or none.
+func (o *Orchestrator) StorageCompression(ctx context.Context) string {
+	if mode, _ := o.corpusDB.GetConfig(ctx, "storage_compression"); mode == storage.Gzip {
+		return mode
+	}
+	return "none"
+}
+
+// SetStorageCompression sets the compression of new objects. Objects
+// already stored keep their encoding until CompressStorage.
+func (o *Orchestrator) SetStorageCompression(ctx context.Context, mode string) error {
+	if mode != storage.Gzip && mode != "none" {
+		return fmt.Errorf("invalid storage compression %q (gzip or none)", mode)
+	}
+	if err := o.corpusDB.SetConfig(ctx, "storage_compression", mode); err != nil {
+		return err
+	}
+	o.audit(ctx, "set_storage_compression", "storage", fmt.Sprintf(`{"mode":%q}`, mode))
+	return nil
+}
+
+// writeObject stores the content of r as the object of a checksum, gzipped
+// when compression applies to the MIME type and makes the object smaller.
+// It returns the encoding and path of the object.
+func (o *Orchestrator) writeObject(ctx context.Context, r io.ReadSeeker, checksum, mimeType string, size int64) (string, string, error) {
+	if o.compresses(ctx, mimeType) {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return "", "", err
+		}
+		path := storage.Path(o.storageRoot(), checksum, storage.Gzip)
+		stored, err := storage.Write(path, storage.Gzip, r)
+		if err != nil {
+			return "", "", err
+		}
+		if stored < size {
+			return storage.Gzip, path, nil
+		}
+		os.Remove(path)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	path := storage.Path(o.storageRoot(), checksum, storage.Identity)
+	_, err := storage.Write(path, storage.Identity, r)
+	return storage.Identity, path, err
+}
+
+// StorageStats sums the objects of the external storage by encoding.
+type StorageStats struct {
+	Encoding string `json:"encoding"`
+	Files    int    `json:"files"`
+	Size     int64  `json:"size"`   // original bytes
+	Stored   int64  `json:"stored"` // bytes on disk
+}
+
+// StorageUsage returns the storage usage by encoding.
+func (o *Orchestrator) StorageUsage(ctx context.Context) ([]StorageStats, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT encoding, external_path, size FROM raw_files ORDER BY encoding",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []StorageStats
+	for rows.Next() {
+		var encoding, path string
+		var size int64
+		if err := rows.Scan(&encoding, &path, &size); err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 || stats[len(stats)-1].Encoding != encoding {
+			stats = append(stats, StorageStats{Encoding: encoding})
+		}
+		s := &stats[len(stats)-1]
+		s.Files++
+		s.Size += size
+		if info, err := os.Stat(path); err == nil {
+			s.Stored += info.Size()
+		}
+	}
+	return stats, rows.Err()
+}
+
+// CompressReport is the result of CompressStorage.
+type CompressReport struct {
+	Compressed int      `json:"compressed"`
+	Kept       int      `json:"kept"` // compressible but no smaller gzipped
+	Before     int64    `json:"before"`
+	After      int64    `json:"after"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// CompressStorage gzips the uncompressed objects of compressible MIME
+// types. Each compressed object is verified against the file checksum
+// before the file is switched to it and the original removed. Objects that
+// do not get smaller are kept as they are.
+func (o *Orchestrator) CompressStorage(ctx context.Context) (*CompressReport, error) {
+	type object struct{ id, mimeType, path, checksum string }
+
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT id, mime_type, external_path, checksum FROM raw_files WHERE encoding = 'identity' ORDER BY imported_at",
+	)
+	if err != nil {
+		return nil, err
+	}
+	var objects []object
+	for rows.Next() {
+		var obj object
+		if err := rows.Scan(&obj.id, &obj.mimeType, &obj.path, &obj.checksum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if storage.Compressible(obj.mimeType) {
+			objects = append(objects, obj)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &CompressReport{}
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		before, after, err := o.compressObject(ctx, obj.id, obj.path, obj.checksum)
+		switch {
+		case err != nil:
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", obj.id[:12], err))
+		case after == 0:
+			report.Kept++
+		default:
+			report.Compressed++
+			report.Before += before
+			report.After += after
+		}
+	}
+
+	if report.Compressed > 0 {
+		o.audit(ctx, "storage_compress", "storage", fmt.Sprintf(`{"compressed":%d,"before":%d,"after":%d}`,
+			report.Compressed, report.Before, report.After))
+	}
+	return report, nil
+}
+
+// compressObject gzips the object of a file and switches the file to it.
+// It returns the sizes before and after, or an after of 0 when gzip does
+// not make the object smaller.
+func (o *Orchestrator) compressObject(ctx context.Context, fileID, path, checksum string) (int64, int64, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	gzPath := storage.Path(o.storageRoot(), checksum, storage.Gzip)
+	stored, err := storage.Write(gzPath, storage.Gzip, src)
+	if err != nil {
+		return 0, 0, err
+	}
+	if stored >= info.Size() {
+		os.Remove(gzPath)
+		return 0, 0, nil
+	}
+
+	// Check the round trip before the original goes
+	if sum, err := hashObject(gzPath, storage.Gzip); err != nil || sum != checksum {
+		os.Remove(gzPath)
+		if err == nil {
+			err = fmt.Errorf("object does not match its checksum, run fsck")
+		}
+		return 0, 0, err
+	}
+
+	_, err = o.corpusDB.ExecContext(ctx,
+		"UPDATE raw_files SET external_path = ?, encoding = 'gzip' WHERE id = ? AND encoding = 'identity'",
+		gzPath, fileID,
+	)
+	if err != nil {
+		os.Remove(gzPath)
+		return 0, 0, err
+	}
+	src.Close()
+	os.Remove(path)
+	return info.Size(), stored, nil
+}
+
+// hashObject returns the hex SHA256 of the decoded content of an object.
+func hashObject(path, encoding string) (string, error) {
+	r, err := storage.Open(path, encoding)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
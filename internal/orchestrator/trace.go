@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"goraglite/internal/db"
+)
+
+// Trace is the lineage of a chunk, from the raw file to the final _output row.
+type Trace struct {
+	Chunk    *Chunk         `json:"chunk"`
+	File     *File          `json:"file"`
+	Segments []TraceSegment `json:"segments,omitempty"` // extracted segments of the chunk's units
+	RunID    string         `json:"run_id"`
+	RunDB    string         `json:"run_db,omitempty"` // empty once the run has been garbage collected
+	Steps    []TraceEntry   `json:"steps"`
+}
+
+// TraceSegment is an extracted segment a chunk was built from.
+type TraceSegment struct {
+	ID               string   `json:"id"`
+	Extractor        string   `json:"extractor"`
+	ExtractorVersion string   `json:"extractor_version"`
+	SegmentType      string   `json:"segment_type"`
+	Page             *int     `json:"page,omitempty"`
+	Position         int      `json:"position"`
+	BBox             string   `json:"bbox,omitempty"` // JSON
+	Confidence       *float64 `json:"confidence,omitempty"`
+}
+
+// Pages returns the pages the chunk comes from, in order.
+func (t *Trace) Pages() []int {
+	var pages []int
+	for _, s := range t.Segments {
+		if s.Page != nil && !slices.Contains(pages, *s.Page) {
+			pages = append(pages, *s.Page)
+		}
+	}
+	slices.Sort(pages)
+	return pages
+}
+
+// TraceEntry is one link of a chunk's lineage.
+type TraceEntry struct {
+	Ref     string         `json:"ref"`   // raw lineage reference ("step_5_chunks#12")
+	Table   string         `json:"table"` // "_input" for the originating row
+	RowID   int64          `json:"row_id,omitempty"`
+	Content string         `json:"content,omitempty"`
+	Row     map[string]any `json:"row,omitempty"` // full intermediate row while the run db exists
+}
+
+// Trace resolves the lineage of a chunk. chunkID may be a unique prefix.
+// Intermediate rows are read back from the run database when it is still
+// available in the queue; otherwise only the references are returned.
+func (o *Orchestrator) Trace(ctx context.Context, chunkID string) (*Trace, error) {
+	fullID, err := o.resolveChunkID(ctx, chunkID)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := o.GetChunk(ctx, fullID)
+	if err != nil {
+		return nil, fmt.Errorf("get chunk: %w", err)
+	}
+	file, err := o.GetFile(ctx, chunk.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("get file: %w", err)
+	}
+
+	t := &Trace{Chunk: chunk, File: file}
+	if t.Segments, err = o.chunkSegments(ctx, fullID); err != nil {
+		return nil, fmt.Errorf("get segments: %w", err)
+	}
+
+	var chainJSON string
+	err = o.corpusDB.QueryRowContext(ctx,
+		"SELECT run_id, source_chain FROM chunk_lineage WHERE chunk_id = ?", fullID,
+	).Scan(&t.RunID, &chainJSON)
+	if err == sql.ErrNoRows {
+		return t, nil // Merged before lineage was recorded
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get lineage: %w", err)
+	}
+
+	var chain []string
+	if err := json.Unmarshal([]byte(chainJSON), &chain); err != nil {
+		return nil, fmt.Errorf("parse lineage: %w", err)
+	}
+
+	var runDB *db.DB
+	if path := o.findRunDB(t.RunID); path != "" {
+		if runDB, err = db.Open(db.DefaultConfig(path, db.DBTypeRun)); err == nil {
+			t.RunDB = path
+			defer runDB.Close()
+		}
+	}
+
+	for _, ref := range chain {
+		entry := TraceEntry{Ref: ref, Table: "_input"}
+		if idx := strings.LastIndex(ref, "#"); idx != -1 {
+			entry.Table = ref[:idx]
+			entry.RowID, _ = strconv.ParseInt(ref[idx+1:], 10, 64)
+		}
+		if runDB != nil && entry.RowID > 0 {
+			entry.Row = readTraceRow(ctx, runDB, entry.Table, entry.RowID)
+			if content, ok := entry.Row["content"].(string); ok {
+				entry.Content = content
+			}
+		}
+		t.Steps = append(t.Steps, entry)
+	}
+
+	return t, nil
+}
+
+// chunkSegments returns the extracted segments of the parsed units of a
+// chunk, in document order.
+func (o *Orchestrator) chunkSegments(ctx context.Context, chunkID string) ([]TraceSegment, error) {
+	rows, err := o.corpusDB.QueryContext(ctx, `
+		SELECT DISTINCT s.id, s.extractor, s.extractor_version, s.segment_type, s.page, s.position,
+			COALESCE(s.bbox, ''), s.confidence
+		FROM chunks c
+		JOIN json_each(CASE WHEN json_valid(c.unit_ids) THEN c.unit_ids END) u
+		JOIN parsed_units p ON p.id = u.value
+		JOIN extracted_segments s ON s.id = p.segment_id
+		WHERE c.id = ?
+		ORDER BY s.position
+	`, chunkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []TraceSegment
+	for rows.Next() {
+		var s TraceSegment
+		var page sql.NullInt64
+		var confidence sql.NullFloat64
+		if err := rows.Scan(&s.ID, &s.Extractor, &s.ExtractorVersion, &s.SegmentType, &page, &s.Position,
+			&s.BBox, &confidence); err != nil {
+			return nil, err
+		}
+		if page.Valid {
+			n := int(page.Int64)
+			s.Page = &n
+		}
+		if confidence.Valid {
+			s.Confidence = &confidence.Float64
+		}
+		segments = append(segments, s)
+	}
+	return segments, rows.Err()
+}
+
+// resolveChunkID expands a chunk ID prefix to the full ID.
+func (o *Orchestrator) resolveChunkID(ctx context.Context, prefix string) (string, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT id FROM chunks WHERE id >= ? AND id < ? || char(0x10FFFF) LIMIT 2", prefix, prefix,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("chunk %s not found", prefix)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("chunk prefix %s is ambiguous", prefix)
+	}
+}
+
+// findRunDB locates a run database in the merge queue directories.
+func (o *Orchestrator) findRunDB(runID string) string {
+	for _, dir := range []string{"done", "pending", "failed"} {
+		path := filepath.Join(o.dataDir, "queue", dir, runID+".db")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readTraceRow reads one intermediate row as a column -> value map.
+func readTraceRow(ctx context.Context, runDB *db.DB, table string, rowID int64) map[string]any {
+	rows, err := runDB.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE rowid = ?", table), rowID)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil || !rows.Next() {
+		return nil
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil
+	}
+
+	row := make(map[string]any, len(cols))
+	for i, col := range cols {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		row[col] = values[i]
+	}
+	return row
+}
@@ -0,0 +1,144 @@
+package orchestrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// Document is a file identity followed across content versions.
+type Document struct {
+	ID             string            `json:"id"`
+	SourcePath     string            `json:"source_path"`
+	CurrentVersion int               `json:"current_version,omitempty"` // 0 until a version is merged
+	Versions       []DocumentVersion `json:"versions"`
+}
+
+// DocumentVersion is one content version of a document.
+type DocumentVersion struct {
+	Version    int       `json:"version"`
+	FileID     string    `json:"file_id"`
+	Size       int64     `json:"size"`
+	Status     string    `json:"status"` // raw_files.status
+	Chunks     int       `json:"chunks"`
+	IngestedAt time.Time `json:"ingested_at"`
+	MergedAt   time.Time `json:"merged_at,omitempty"`
+	RetiredAt  time.Time `json:"retired_at,omitempty"`
+}
+
+// versionDocument records fileID as the latest version of a document.
+// The previous content is marked superseded; its chunks stay searchable
+// until the merger merges the new version and retires the old one.
+func (o *Orchestrator) versionDocument(ctx context.Context, docID, sourcePath, fileID string) error {
+	return o.corpusDB.Transaction(ctx, func(tx *sql.Tx) error {
+		var latestVersion int
+		var latestFile string
+		err := tx.QueryRowContext(ctx, `
+			SELECT version, file_id FROM document_versions
+			WHERE document_id = ?
+			ORDER BY version DESC LIMIT 1
+		`, docID).Scan(&latestVersion, &latestFile)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if latestFile == fileID {
+			return nil // same content ingested again
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO documents (id, source_path) VALUES (?, ?)
+			ON CONFLICT(id) DO UPDATE SET source_path = excluded.source_path, updated_at = datetime('now')
+		`, docID, sourcePath)
+		if err != nil {
+			return err
+		}
+
+		// Content that was already processed (a revert) counts as merged
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO document_versions (document_id, version, file_id, merged_at)
+			SELECT ?, ?, id, CASE WHEN status = 'vectorized' THEN datetime('now') END
+			FROM raw_files WHERE id = ?
+		`, docID, latestVersion+1, fileID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE raw_files SET superseded_by = NULL WHERE id = ?", fileID); err != nil {
+			return err
+		}
+		if latestFile == "" {
+			return nil
+		}
+
+		// Content still latest for another document is not superseded
+		res, err := tx.ExecContext(ctx, `
+			UPDATE raw_files SET superseded_by = ?
+			WHERE id = ? AND NOT EXISTS (
+				SELECT 1 FROM document_versions v
+				WHERE v.file_id = raw_files.id
+					AND v.version = (SELECT MAX(version) FROM document_versions WHERE document_id = v.document_id)
+			)
+		`, fileID, latestFile)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO audit_log (actor, action, target, details)
+				VALUES ('orchestrator', 'supersede', ?, json_object('document', ?, 'version', ?, 'superseded_by', ?))
+			`, latestFile, docID, latestVersion+1, fileID)
+		}
+		return err
+	})
+}
+
+// History returns a document and its versions, oldest first. ref is a
+// document id or a source path.
+func (o *Orchestrator) History(ctx context.Context, ref string) (*Document, error) {
+	absRef, _ := filepath.Abs(ref)
+
+	var doc Document
+	var current sql.NullInt64
+	err := o.corpusDB.QueryRowContext(ctx, `
+		SELECT id, source_path, current_version FROM documents
+		WHERE id IN (?, ?) OR source_path IN (?, ?)
+		ORDER BY id = ? DESC, updated_at DESC
+		LIMIT 1
+	`, ref, absRef, ref, absRef, ref).Scan(&doc.ID, &doc.SourcePath, &current)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("document %s not found", ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.CurrentVersion = int(current.Int64)
+
+	rows, err := o.corpusDB.QueryContext(ctx, `
+		SELECT v.version, v.file_id, r.size, r.status,
+			(SELECT COUNT(*) FROM chunks c WHERE c.file_id = v.file_id),
+			v.ingested_at, v.merged_at, v.retired_at
+		FROM document_versions v
+		JOIN raw_files r ON r.id = v.file_id
+		WHERE v.document_id = ?
+		ORDER BY v.version
+	`, doc.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v DocumentVersion
+		if err := rows.Scan(&v.Version, &v.FileID, &v.Size, &v.Status, &v.Chunks,
+			db.Time(&v.IngestedAt), db.Time(&v.MergedAt), db.Time(&v.RetiredAt)); err != nil {
+			return nil, err
+		}
+		doc.Versions = append(doc.Versions, v)
+	}
+
+	return &doc, rows.Err()
+}
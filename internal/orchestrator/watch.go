@@ -0,0 +1,277 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WatchConfig configures directory watching.
+type WatchConfig struct {
+	Roots    []string
+	Interval time.Duration // time between scans
+	Debounce time.Duration // a file must be unmodified this long before it is ingested
+}
+
+// DefaultWatchConfig returns sensible defaults.
+func DefaultWatchConfig(roots ...string) WatchConfig {
+	return WatchConfig{
+		Roots:    roots,
+		Interval: 5 * time.Second,
+		Debounce: 2 * time.Second,
+	}
+}
+
+// WatchReport counts the changes found by one scan.
+type WatchReport struct {
+	Added     int `json:"added"`
+	Modified  int `json:"modified"`
+	Deleted   int `json:"deleted"`
+	Unchanged int `json:"unchanged"`
+	Deferred  int `json:"deferred"` // changed too recently, picked up by a later scan
+	Errors    int `json:"errors"`
+}
+
+// Changed reports whether the scan changed the corpus.
+func (r *WatchReport) Changed() bool {
+	return r.Added+r.Modified+r.Deleted > 0
+}
+
+// watchEntry is the last known stat of a watched file.
+type watchEntry struct {
+	size   int64
+	mtime  int64
+	fileID string
+}
+
+// Watch scans the watched roots every Interval until ctx is cancelled.
+// onScan, when set, receives the report of each scan.
+func (o *Orchestrator) Watch(ctx context.Context, cfg WatchConfig, onScan func(*WatchReport)) error {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := o.ScanWatched(ctx, cfg)
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "watch scan: %v\n", err)
+		}
+		if report != nil && onScan != nil {
+			onScan(report)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScanWatched compares the watched roots with the persisted watch state:
+// new and modified files are ingested as new document versions, and files
+// that disappeared are marked deleted so the merger tombstones their chunks.
+// Files whose size and mtime did not change are not read.
+func (o *Orchestrator) ScanWatched(ctx context.Context, cfg WatchConfig) (*WatchReport, error) {
+	report := &WatchReport{}
+	for _, root := range cfg.Roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return report, fmt.Errorf("watch root %s: %w", root, err)
+		}
+		if err := o.scanRoot(ctx, abs, cfg.Debounce, report); err != nil {
+			return report, fmt.Errorf("watch %s: %w", abs, err)
+		}
+	}
+	return report, nil
+}
+
+func (o *Orchestrator) scanRoot(ctx context.Context, root string, debounce time.Duration, report *WatchReport) error {
+	state, err := o.loadWatchState(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	dataDir, _ := filepath.Abs(o.dataDir)
+	ignore := newIgnoreMatcher(root)
+	seen := make(map[string]bool)
+	var unreadable []string // known files under these paths are kept
+	now := time.Now()
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// One unreadable entry must not stop the scan of the others
+			report.Errors++
+			fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+			unreadable = append(unreadable, path)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Ignored files that were watched before count as deleted
+		if path != root && ignore.ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			// Never ingest our own storage
+			if path == dataDir {
+				return filepath.SkipDir
+			}
+			ignore.load(path)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed while walking
+		}
+		seen[path] = true
+
+		prev, known := state[path]
+		mtime := info.ModTime().UnixNano()
+		if known && prev.size == info.Size() && prev.mtime == mtime {
+			report.Unchanged++
+			return nil
+		}
+		// Still being written: wait for the debounce window
+		if now.Sub(info.ModTime()) < debounce {
+			report.Deferred++
+			return nil
+		}
+
+		fileID, err := o.Ingest(ctx, path)
+		if err != nil {
+			report.Errors++
+			fmt.Fprintf(os.Stderr, "watch: ingest %s: %v\n", path, err)
+			return nil
+		}
+		if err := o.recordWatched(ctx, root, path, info.Size(), mtime, fileID); err != nil {
+			return err
+		}
+
+		switch {
+		case !known:
+			report.Added++
+		case prev.fileID != fileID:
+			report.Modified++
+		default:
+			report.Unchanged++ // touched, same content
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for path, entry := range state {
+		if seen[path] || under(path, unreadable) {
+			continue
+		}
+		if err := o.forgetWatched(ctx, path, entry.fileID); err != nil {
+			return err
+		}
+		report.Deleted++
+	}
+
+	return nil
+}
+
+// under reports whether path is one of dirs or inside one of them.
+func under(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadWatchState reads the watch state of a root.
+func (o *Orchestrator) loadWatchState(ctx context.Context, root string) (map[string]watchEntry, error) {
+	rows, err := o.corpusDB.QueryContext(ctx,
+		"SELECT path, size, mtime, file_id FROM watch_state WHERE root = ?", root,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[string]watchEntry)
+	for rows.Next() {
+		var path string
+		var e watchEntry
+		if err := rows.Scan(&path, &e.size, &e.mtime, &e.fileID); err != nil {
+			return nil, err
+		}
+		state[path] = e
+	}
+	return state, rows.Err()
+}
+
+// recordWatched saves the stat of an ingested file. Superseding the
+// previous content of a modified file is left to document versioning.
+func (o *Orchestrator) recordWatched(ctx context.Context, root, path string, size, mtime int64, fileID string) error {
+	_, err := o.corpusDB.ExecContext(ctx, `
+		INSERT INTO watch_state (path, root, size, mtime, file_id, updated_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now'))
+		ON CONFLICT(path) DO UPDATE SET
+			root = excluded.root, size = excluded.size, mtime = excluded.mtime,
+			file_id = excluded.file_id, updated_at = excluded.updated_at
+	`, path, root, size, mtime, fileID)
+	if err != nil {
+		return fmt.Errorf("record watch state: %w", err)
+	}
+
+	// The content is present again (new, restored or reverted)
+	if _, err := o.corpusDB.ExecContext(ctx,
+		"UPDATE raw_files SET deleted_at = NULL WHERE id = ?", fileID,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+// forgetWatched drops a file that disappeared from its root and marks its
+// content deleted unless another watched path still holds it.
+func (o *Orchestrator) forgetWatched(ctx context.Context, path, fileID string) error {
+	if _, err := o.corpusDB.ExecContext(ctx, "DELETE FROM watch_state WHERE path = ?", path); err != nil {
+		return fmt.Errorf("forget watch state: %w", err)
+	}
+
+	res, err := o.corpusDB.ExecContext(ctx, `
+		UPDATE raw_files SET deleted_at = datetime('now')
+		WHERE id = ? AND deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM watch_state WHERE file_id = ?)
+	`, fileID, fileID)
+	if err != nil {
+		return fmt.Errorf("mark deleted: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		o.audit(ctx, "source_deleted", fileID, fmt.Sprintf(`{"path":%q}`, path))
+	}
+	return nil
+}
+
+// audit writes an orchestrator entry in the audit log.
+func (o *Orchestrator) audit(ctx context.Context, action, target, details string) {
+	o.corpusDB.ExecContext(ctx, `
+		INSERT INTO audit_log (actor, action, target, details)
+		VALUES ('orchestrator', ?, ?, ?)
+	`, action, target, details)
+}
@@ -0,0 +1,147 @@
+// Package storage reads and writes the objects of the external raw file
+// storage. Objects are stored as is or gzip-compressed; raw_files.encoding
+// records which, and readers get the original content either way.
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Object encodings.
+const (
+	Identity = "identity"
+	Gzip     = "gzip"
+)
+
+// Path returns the object path of a checksum under the storage root:
+// {root}/raw/{hash[0:2]}/{hash}, with a .gz suffix when compressed.
+func Path(root, checksum, encoding string) string {
+	path := filepath.Join(root, "raw", checksum[:2], checksum)
+	if encoding == Gzip {
+		path += ".gz"
+	}
+	return path
+}
+
+// compressedTypes are formats that carry their own compression.
+var compressedTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/vnd.rar":          true,
+	"application/x-rar-compressed": true,
+	"application/java-archive":     true,
+	"application/epub+zip":         true,
+	"application/pdf":              true,
+}
+
+// Compressible reports whether gzip is worth trying on a MIME type: formats
+// compressed already, office packages and media are not.
+func Compressible(mimeType string) bool {
+	switch {
+	case compressedTypes[mimeType]:
+		return false
+	case strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return false
+	case strings.HasPrefix(mimeType, "image/"):
+		return mimeType == "image/svg+xml" || mimeType == "image/bmp" || mimeType == "image/tiff"
+	case strings.HasPrefix(mimeType, "audio/"), strings.HasPrefix(mimeType, "video/"):
+		return false
+	}
+	return true
+}
+
+// Open opens an object and decodes it.
+func Open(path, encoding string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch encoding {
+	case Identity, "":
+		return file, nil
+	case Gzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("gzip %s: %w", path, err)
+		}
+		return &gzipObject{Reader: gz, file: file}, nil
+	}
+	file.Close()
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// ReadFile returns the decoded content of an object.
+func ReadFile(path, encoding string) ([]byte, error) {
+	r, err := Open(path, encoding)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Write stores the content of r at path with an encoding and returns the
+// size of the object. The object is written to a temporary file renamed
+// into place, so a failed write leaves no partial object.
+func Write(path, encoding string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	switch encoding {
+	case Identity, "":
+		_, err = io.Copy(tmp, r)
+	case Gzip:
+		gz := gzip.NewWriter(tmp)
+		if _, err = io.Copy(gz, r); err == nil {
+			err = gz.Close()
+		}
+	default:
+		err = fmt.Errorf("unknown encoding %q", encoding)
+	}
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// gzipObject closes the file under a gzip reader with it.
+type gzipObject struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipObject) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
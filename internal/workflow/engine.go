@@ -1,16 +1,26 @@
 package workflow
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/google/uuid"
+	"modernc.org/sqlite"
 
 	"goraglite/internal/db"
+	"goraglite/internal/storage"
 )
 
 // Engine executes workflows.
@@ -21,6 +31,7 @@ type Engine struct {
 	runsDir     string
 	extractors  map[string]Extractor
 	vectorizers map[string]Vectorizer
+	operations  map[Operation]OperationHandler
 }
 
 // Extractor is implemented by external extractors (PDF, DOCX, etc.)
@@ -30,16 +41,17 @@ type Extractor interface {
 	Extract(ctx context.Context, content []byte, config json.RawMessage) ([]ExtractedSegment, error)
 }
 
-// ExtractedSegment is the output of an extractor.
+// ExtractedSegment is the output of an extractor. An empty ID is derived from
+// the file, the extractor and the position of the segment.
 type ExtractedSegment struct {
-	ID           string  `json:"id"`
-	FileID       string  `json:"file_id"`
-	SegmentType  string  `json:"segment_type"`
-	Content      string  `json:"content"`
-	Page         *int    `json:"page,omitempty"`
-	Position     int     `json:"position"`
-	BBox         string  `json:"bbox,omitempty"`
-	Confidence   float64 `json:"confidence,omitempty"`
+	ID          string   `json:"id"`
+	FileID      string   `json:"file_id"`
+	SegmentType string   `json:"segment_type"`
+	Content     string   `json:"content"`
+	Page        *int     `json:"page,omitempty"`
+	Position    int      `json:"position"`
+	BBox        string   `json:"bbox,omitempty"`
+	Confidence  *float64 `json:"confidence,omitempty"`
 }
 
 // Vectorizer creates vectors from content or features.
@@ -56,6 +68,15 @@ type VectorizerInput struct {
 	Config   json.RawMessage    `json:"config"`
 }
 
+// OperationHandler is implemented by custom operations registered from Go.
+// A handler decodes its own config from Step.Config, rejects invalid steps
+// before the run starts, and materialises Step.Output in the run database.
+type OperationHandler interface {
+	Operation() Operation
+	Validate(step *Step) error
+	Execute(ctx context.Context, runDB *db.DB, step *Step, source string) error
+}
+
 // NewEngine creates a new workflow engine.
 func NewEngine(corpusDB, workflowsDB *db.DB, runsDir string) *Engine {
 	return &Engine{
@@ -64,6 +85,7 @@ func NewEngine(corpusDB, workflowsDB *db.DB, runsDir string) *Engine {
 		runsDir:     runsDir,
 		extractors:  make(map[string]Extractor),
 		vectorizers: make(map[string]Vectorizer),
+		operations:  make(map[Operation]OperationHandler),
 	}
 }
 
@@ -77,6 +99,39 @@ func (e *Engine) RegisterVectorizer(vec Vectorizer) {
 	e.vectorizers[vec.Name()] = vec
 }
 
+// RegisterOperation registers a custom operation usable from workflow steps by name.
+// Built-in operations cannot be overridden.
+func (e *Engine) RegisterOperation(h OperationHandler) error {
+	op := h.Operation()
+	if op == "" {
+		return fmt.Errorf("operation handler has no name")
+	}
+	if op.IsBuiltin() {
+		return fmt.Errorf("operation %s is built-in", op)
+	}
+	e.operations[op] = h
+	return nil
+}
+
+// ValidateWorkflow checks that every step uses a known operation and that
+// custom operations accept their step configuration.
+func (e *Engine) ValidateWorkflow(w *Workflow) error {
+	for i := range w.Steps {
+		step := &w.Steps[i]
+		if step.Operation.IsBuiltin() {
+			continue
+		}
+		h, ok := e.operations[step.Operation]
+		if !ok {
+			return fmt.Errorf("step %d (%s): unknown operation: %s", step.StepOrder, step.StepName, step.Operation)
+		}
+		if err := h.Validate(step); err != nil {
+			return fmt.Errorf("step %d (%s): %w", step.StepOrder, step.StepName, err)
+		}
+	}
+	return nil
+}
+
 // LoadWorkflow loads a workflow definition from the database.
 func (e *Engine) LoadWorkflow(ctx context.Context, workflowID string) (*Workflow, error) {
 	var w Workflow
@@ -91,7 +146,7 @@ func (e *Engine) LoadWorkflow(ctx context.Context, workflowID string) (*Workflow
 	`, workflowID).Scan(
 		&w.ID, &w.Name, &w.Version, &w.Description,
 		&inputSchema, &outputSchema, &w.Status,
-		&w.CreatedAt, &w.UpdatedAt,
+		db.Time(&w.CreatedAt), db.Time(&w.UpdatedAt),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("load workflow %s: %w", workflowID, err)
@@ -149,6 +204,17 @@ func (e *Engine) Run(ctx context.Context, workflowID string, cfg RunConfig) (*Ru
 	if err != nil {
 		return nil, err
 	}
+	if err := e.ValidateWorkflow(workflow); err != nil {
+		return nil, fmt.Errorf("validate workflow %s: %w", workflowID, err)
+	}
+	types, err := workflow.ParamTypes()
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", workflowID, err)
+	}
+	params, err := bindParams(cfg.Parameters, types)
+	if err != nil {
+		return nil, fmt.Errorf("workflow %s: %w", workflowID, err)
+	}
 
 	// Create run
 	run := &Run{
@@ -157,9 +223,12 @@ func (e *Engine) Run(ctx context.Context, workflowID string, cfg RunConfig) (*Ru
 		WorkflowVersion: workflow.Version,
 		StartedAt:       time.Now(),
 		Status:          RunStatusRunning,
-		WorkerID:        "worker-1", // TODO: from config
+		WorkerID:        cfg.WorkerID,
 		Config:          cfg,
 	}
+	if run.WorkerID == "" {
+		run.WorkerID = "worker-1"
+	}
 
 	// Create run database
 	runDB, err := db.CreateRun(e.runsDir, run.ID)
@@ -180,28 +249,61 @@ func (e *Engine) Run(ctx context.Context, workflowID string, cfg RunConfig) (*Ru
 	}
 	defer runDB.Detach(ctx, "corpus")
 
+	// Snapshot input files
+	if err := e.snapshotInput(ctx, runDB, cfg); err != nil {
+		return nil, fmt.Errorf("snapshot input: %w", err)
+	}
+	if err := e.snapshotQuery(ctx, runDB, cfg); err != nil {
+		return nil, fmt.Errorf("snapshot query: %w", err)
+	}
+
+	events := &runEvents{run: run, totalSteps: len(workflow.Steps), handler: cfg.OnEvent}
+	events.emit(Event{Type: EventRunStarted})
+
+	// fail marks the run failed and reports the error before returning it.
+	fail := func(step *Step, err error) (*Run, error) {
+		run.Status = RunStatusFailed
+		run.FinishedAt = time.Now()
+		e.updateRunStatus(ctx, runDB, run)
+		ev := Event{Type: EventError, Error: err.Error()}
+		if step != nil {
+			ev.StepOrder, ev.StepName = step.StepOrder, step.StepName
+		}
+		events.emit(ev)
+		events.emit(Event{Type: EventRunFinished, Status: run.Status, Duration: run.FinishedAt.Sub(run.StartedAt), Error: err.Error()})
+		return run, err
+	}
+
 	// Execute steps
 	var lastStepOutput string
 	for i, step := range workflow.Steps {
-		execution, err := e.executeStep(ctx, runDB, run, &step, lastStepOutput, i > 0)
+		events.emit(Event{Type: EventStepStarted, StepOrder: step.StepOrder, StepName: step.StepName})
+
+		stepCtx := withStepScope(ctx, events, &step, params)
+		execution, err := e.executeStep(stepCtx, runDB, run, &step, lastStepOutput, i > 0)
 		if err != nil {
-			run.Status = RunStatusFailed
-			e.updateRunStatus(ctx, runDB, run)
-			return run, fmt.Errorf("step %d (%s): %w", step.StepOrder, step.StepName, err)
+			return fail(&step, fmt.Errorf("step %d (%s): %w", step.StepOrder, step.StepName, err))
 		}
 
 		// Log execution
 		if err := e.logStepExecution(ctx, runDB, execution); err != nil {
-			return nil, fmt.Errorf("log step execution: %w", err)
+			return fail(&step, fmt.Errorf("log step execution: %w", err))
 		}
 
+		events.emit(Event{
+			Type:      EventStepFinished,
+			StepOrder: step.StepOrder,
+			StepName:  step.StepName,
+			RowsIn:    execution.RowsIn,
+			RowsOut:   execution.RowsOut,
+			Duration:  execution.FinishedAt.Sub(execution.StartedAt),
+		})
+
 		// Handle empty results
 		if execution.RowsOut == 0 {
 			switch step.OnEmpty {
 			case OnEmptyFail:
-				run.Status = RunStatusFailed
-				e.updateRunStatus(ctx, runDB, run)
-				return run, fmt.Errorf("step %d produced no results", step.StepOrder)
+				return fail(&step, fmt.Errorf("step %d produced no results", step.StepOrder))
 			case OnEmptySkipRemaining:
 				break
 			}
@@ -214,6 +316,7 @@ func (e *Engine) Run(ctx context.Context, workflowID string, cfg RunConfig) (*Ru
 	run.Status = RunStatusCompleted
 	run.FinishedAt = time.Now()
 	e.updateRunStatus(ctx, runDB, run)
+	events.emit(Event{Type: EventRunFinished, Status: run.Status, Duration: run.FinishedAt.Sub(run.StartedAt)})
 
 	return run, nil
 }
@@ -244,6 +347,67 @@ func (e *Engine) initRunMeta(ctx context.Context, runDB *db.DB, run *Run, workfl
 	return nil
 }
 
+// snapshotInput creates the _input table from the corpus raw files, seeding
+// the lineage of each row with the file id.
+// The file_ids parameter restricts the snapshot; otherwise all pending files are taken.
+func (e *Engine) snapshotInput(ctx context.Context, runDB *db.DB, cfg RunConfig) error {
+	selectInput := "CREATE TABLE _input AS SELECT *, json_array(id) AS " + lineageColumn + " FROM corpus.raw_files"
+	query := selectInput + " WHERE status = 'pending'"
+	var args []any
+	if ids := cfg.Parameters["file_ids"]; ids != "" {
+		query = selectInput + " WHERE id IN (SELECT value FROM json_each(?))"
+		list, _ := json.Marshal(strings.Split(ids, ","))
+		args = append(args, string(list))
+	}
+	_, err := runDB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// bindParams converts the run parameters to named arguments, so step SQL
+// can reference them as :name. Parameters the input schema declares as
+// integer, number or boolean are bound as numbers (LIMIT :top_k,
+// score >= :min_score); all others are bound as text.
+func bindParams(params, types map[string]string) ([]any, error) {
+	args := make([]any, 0, len(params))
+	for name, value := range params {
+		var arg any = value
+		switch types[name] {
+		case "integer":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %q is not an integer", name, value)
+			}
+			arg = n
+		case "number":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, fmt.Errorf("parameter %s: %q is not a number", name, value)
+			}
+			arg = f
+		case "boolean":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %q is not a boolean", name, value)
+			}
+			arg = 0
+			if b {
+				arg = 1
+			}
+		}
+		args = append(args, sql.Named(name, arg))
+	}
+	return args, nil
+}
+
+// stepParams returns the bound run parameters of the step running in ctx.
+func stepParams(ctx context.Context) []any {
+	scope, ok := ctx.Value(stepScopeKey{}).(*stepScope)
+	if !ok {
+		return nil
+	}
+	return scope.params
+}
+
 // executeStep executes a single workflow step.
 func (e *Engine) executeStep(ctx context.Context, runDB *db.DB, run *Run, step *Step, prevOutput string, hasPrev bool) (*StepExecution, error) {
 	exec := &StepExecution{
@@ -286,18 +450,37 @@ func (e *Engine) executeStep(ctx context.Context, runDB *db.DB, run *Run, step *
 		err = e.executeVectorize(ctx, runDB, step, source)
 	case OpExternal:
 		err = e.executeExternal(ctx, runDB, step, source)
+	case OpParse:
+		err = e.executeParse(ctx, runDB, step, source)
 	default:
-		err = fmt.Errorf("unknown operation: %s", step.Operation)
+		if h, ok := e.operations[step.Operation]; ok {
+			err = h.Execute(ctx, runDB, step, source)
+		} else {
+			err = fmt.Errorf("unknown operation: %s", step.Operation)
+		}
 	}
 
 	exec.FinishedAt = time.Now()
 	exec.DurationMs = exec.FinishedAt.Sub(exec.StartedAt).Milliseconds()
+	if notes := stepNotes(ctx); len(notes) > 0 {
+		if b, err := json.Marshal(notes); err == nil {
+			exec.Notes = string(b)
+		}
+	}
 
 	if err != nil {
 		exec.Error = err.Error()
 		return exec, err
 	}
 
+	// Carry row lineage forward (vectors follow their chunk instead)
+	if step.Operation != OpVectorize {
+		if err := e.extendLineage(ctx, runDB, step); err != nil {
+			exec.Error = err.Error()
+			return exec, fmt.Errorf("lineage: %w", err)
+		}
+	}
+
 	// Count output rows
 	exec.RowsOut, _ = runDB.RowCount(ctx, step.Output)
 
@@ -322,23 +505,68 @@ func (e *Engine) executeFilter(ctx context.Context, runDB *db.DB, step *Step, so
 		WHERE %s
 	`, step.Output, source, predicate)
 
-	_, err := runDB.ExecContext(ctx, query)
+	_, err := runDB.ExecContext(ctx, query, stepParams(ctx)...)
 	return err
 }
 
-// executeProject executes a project operation (SELECT columns).
+// executeProject executes a project operation (SELECT columns). Projecting
+// into an output table of the run schema (_output, ...) inserts into it.
 func (e *Engine) executeProject(ctx context.Context, runDB *db.DB, step *Step, source string) error {
 	columns := step.Predicate
 	if columns == "" || columns == "*" {
 		columns = "*"
+	} else if !strings.Contains(columns, lineageColumn) {
+		// Explicit projections keep the lineage column implicitly
+		if cols, err := runDB.Columns(ctx, source); err == nil && containsString(cols, lineageColumn) {
+			columns += ", " + lineageColumn
+		}
 	}
 
-	query := fmt.Sprintf(`
-		CREATE TABLE %s AS
-		SELECT %s FROM %s
-	`, step.Output, columns, source)
+	exists, err := runDB.TableExists(ctx, step.Output)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		query := fmt.Sprintf(`
+			CREATE TABLE %s AS
+			SELECT %s FROM %s
+		`, step.Output, columns, source)
+		_, err := runDB.ExecContext(ctx, query, stepParams(ctx)...)
+		return err
+	}
 
-	_, err := runDB.ExecContext(ctx, query)
+	// The run schema creates the output tables: the projection fills the
+	// columns they declare and drops the others
+	staged := step.Output + "_projected"
+	if _, err := runDB.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE %s AS SELECT %s FROM %s", staged, columns, source,
+	), stepParams(ctx)...); err != nil {
+		return err
+	}
+	defer runDB.ExecContext(ctx, "DROP TABLE IF EXISTS "+staged)
+
+	outputCols, err := runDB.Columns(ctx, step.Output)
+	if err != nil {
+		return err
+	}
+	stagedCols, err := runDB.Columns(ctx, staged)
+	if err != nil {
+		return err
+	}
+	var common []string
+	for _, c := range outputCols {
+		if containsString(stagedCols, c) {
+			common = append(common, c)
+		}
+	}
+	if len(common) == 0 {
+		return fmt.Errorf("projection has none of the columns of %s", step.Output)
+	}
+
+	list := strings.Join(common, ", ")
+	_, err = runDB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (%s) SELECT %s FROM %s ORDER BY rowid", step.Output, list, list, staged,
+	))
 	return err
 }
 
@@ -351,7 +579,7 @@ func (e *Engine) executeJoin(ctx context.Context, runDB *db.DB, step *Step, sour
 		%s
 	`, step.Output, source, step.Predicate)
 
-	_, err := runDB.ExecContext(ctx, query)
+	_, err := runDB.ExecContext(ctx, query, stepParams(ctx)...)
 	return err
 }
 
@@ -379,7 +607,7 @@ func (e *Engine) executeAggregate(ctx context.Context, runDB *db.DB, step *Step,
 			SELECT *, %s FROM %s
 		`, step.Output, strings.Join(featureCols, ", "), source)
 
-		_, err := runDB.ExecContext(ctx, query)
+		_, err := runDB.ExecContext(ctx, query, stepParams(ctx)...)
 		return err
 	}
 
@@ -389,7 +617,7 @@ func (e *Engine) executeAggregate(ctx context.Context, runDB *db.DB, step *Step,
 		SELECT %s FROM %s
 	`, step.Output, step.Predicate, source)
 
-	_, err := runDB.ExecContext(ctx, query)
+	_, err := runDB.ExecContext(ctx, query, stepParams(ctx)...)
 	return err
 }
 
@@ -402,6 +630,22 @@ func (e *Engine) executeWindow(ctx context.Context, runDB *db.DB, step *Step, so
 		}
 	}
 
+	// A referenced strategy takes precedence over the inline values
+	if cfg.StrategyID != "" {
+		strategy, err := NewLoader(e.workflowsDB).GetStrategy(ctx, cfg.StrategyID)
+		if err != nil {
+			return fmt.Errorf("load strategy %s: %w", cfg.StrategyID, err)
+		}
+		if !strategy.Active {
+			return fmt.Errorf("strategy %s is not active", cfg.StrategyID)
+		}
+		if err := strategy.Apply(&cfg); err != nil {
+			return fmt.Errorf("strategy %s: %w", cfg.StrategyID, err)
+		}
+		SetStepNote(ctx, "strategy_id", strategy.ID)
+		SetStepNote(ctx, "strategy_version", strategy.Version)
+	}
+
 	// Set defaults
 	if cfg.MaxTokens == 0 {
 		cfg.MaxTokens = 512
@@ -410,22 +654,34 @@ func (e *Engine) executeWindow(ctx context.Context, runDB *db.DB, step *Step, so
 		cfg.MinTokens = 50
 	}
 
-	// For now, simple chunking based on token count
-	// Real implementation would use the strategy
-	query := fmt.Sprintf(`
-		CREATE TABLE %s AS
-		SELECT
-			*,
-			ROW_NUMBER() OVER (ORDER BY position) as chunk_position
-		FROM %s
-		WHERE approx_tokens BETWEEN %d AND %d
-	`, step.Output, source, cfg.MinTokens, cfg.MaxTokens)
+	return e.executeWindowChunks(ctx, runDB, step, source, cfg)
+}
 
-	_, err := runDB.ExecContext(ctx, query)
-	return err
+func init() {
+	// sha256_hex(a, ...) hashes its arguments as text, for hash steps. The
+	// arguments are separated by a unit separator; NULL hashes as ''.
+	sqlite.MustRegisterDeterministicScalarFunction("sha256_hex", -1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		h := sha256.New()
+		for i, arg := range args {
+			if i > 0 {
+				h.Write([]byte{0x1f})
+			}
+			switch v := arg.(type) {
+			case nil:
+			case []byte:
+				h.Write(v)
+			case string:
+				h.Write([]byte(v))
+			default:
+				fmt.Fprint(h, v)
+			}
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	})
 }
 
-// executeHash executes a hash operation.
+// executeHash executes a hash operation: the output column holds the
+// SHA-256 of the configured columns (content by default).
 func (e *Engine) executeHash(ctx context.Context, runDB *db.DB, step *Step, source string) error {
 	var cfg HashConfig
 	if step.Config != nil {
@@ -437,20 +693,24 @@ func (e *Engine) executeHash(ctx context.Context, runDB *db.DB, step *Step, sour
 	if cfg.OutputColumn == "" {
 		cfg.OutputColumn = "hash"
 	}
-
-	// SQLite doesn't have native hashing, so we use a simple approach
-	// Real implementation would use a custom function
-	// Note: cfg.Columns would be used with a proper hashing extension
-	_ = cfg.Columns // Mark as used for future implementation
+	if cfg.Algorithm != "" && cfg.Algorithm != "sha256" {
+		return fmt.Errorf("unsupported hash algorithm %q", cfg.Algorithm)
+	}
+	if len(cfg.Columns) == 0 {
+		cfg.Columns = []string{"content"}
+	}
+	args := make([]string, len(cfg.Columns))
+	for i, c := range cfg.Columns {
+		args[i] = `"` + strings.ReplaceAll(c, `"`, `""`) + `"`
+	}
 
 	query := fmt.Sprintf(`
 		CREATE TABLE %s AS
 		SELECT
 			*,
-			hex(zeroblob(32)) AS %s
+			sha256_hex(%s) AS %s
 		FROM %s
-	`, step.Output, cfg.OutputColumn, source)
-	// Note: Real implementation needs proper hashing via SQLite extension
+	`, step.Output, strings.Join(args, ", "), cfg.OutputColumn, source)
 
 	_, err := runDB.ExecContext(ctx, query)
 	return err
@@ -465,6 +725,25 @@ func (e *Engine) executeVectorize(ctx context.Context, runDB *db.DB, step *Step,
 		}
 	}
 
+	// A referenced config takes precedence over the inline values it sets
+	if cfg.ConfigID != "" {
+		vc, err := NewLoader(e.workflowsDB).GetVectorConfig(ctx, cfg.ConfigID)
+		if err != nil {
+			return fmt.Errorf("load vectorization config %s: %w", cfg.ConfigID, err)
+		}
+		if !vc.Active {
+			return fmt.Errorf("vectorization config %s is not active", cfg.ConfigID)
+		}
+		if err := vc.Apply(&cfg); err != nil {
+			return fmt.Errorf("vectorization config %s: %w", cfg.ConfigID, err)
+		}
+		SetStepNote(ctx, "vectorization_config_id", vc.ID)
+		SetStepNote(ctx, "vectorization_config_version", vc.Version)
+	}
+
+	total, _ := runDB.RowCount(ctx, source)
+	ReportProgress(ctx, 0, total)
+
 	// Create output table for vectors
 	// Real implementation would call the registered vectorizer
 	query := fmt.Sprintf(`
@@ -478,8 +757,12 @@ func (e *Engine) executeVectorize(ctx context.Context, runDB *db.DB, step *Step,
 		FROM %s
 	`, step.Output, cfg.Layer, cfg.Dimensions, cfg.Dimensions, cfg.ModelVersion, source)
 
-	_, err := runDB.ExecContext(ctx, query)
-	return err
+	if _, err := runDB.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	ReportProgress(ctx, total, total)
+	return nil
 }
 
 // executeExternal executes an external extraction.
@@ -492,7 +775,25 @@ func (e *Engine) executeExternal(ctx context.Context, runDB *db.DB, step *Step,
 	}
 
 	extractor, ok := e.extractors[cfg.Extractor]
-	if !ok {
+	name, version := cfg.Extractor, cfg.ExtractorVersion
+	if ok {
+		name, version = extractor.Name(), extractor.Version()
+	}
+
+	// Files the corpus holds segments of from the same extractor version are
+	// not extracted again, so they can be chunked anew from their segments
+	stored := make(map[string]bool)
+	if !cfg.Reextract {
+		ids, err := storedSegmentFiles(ctx, runDB, source, name, version)
+		if err != nil {
+			return fmt.Errorf("read stored segments: %w", err)
+		}
+		for _, id := range ids {
+			stored[id] = true
+		}
+	}
+
+	if !ok && len(stored) == 0 {
 		// No extractor registered, create empty output
 		query := fmt.Sprintf(`
 			CREATE TABLE %s AS
@@ -502,47 +803,189 @@ func (e *Engine) executeExternal(ctx context.Context, runDB *db.DB, step *Step,
 		return err
 	}
 
-	// Query source for content
-	rows, err := runDB.QueryContext(ctx, fmt.Sprintf("SELECT id, content FROM %s", source))
+	total, _ := runDB.RowCount(ctx, source)
+
+	// Segments inherit the lineage of the row they were extracted from
+	cols, _ := runDB.Columns(ctx, source)
+	chainExpr := "json_array(id)"
+	if containsString(cols, lineageColumn) {
+		chainExpr = "COALESCE(" + lineageColumn + ", json_array(id))"
+	}
+
+	// Raw file rows carry the path of their stored object, not the content
+	contentExpr, encodingExpr := "content", "NULL"
+	fromStorage := !containsString(cols, "content") && containsString(cols, "external_path")
+	if fromStorage {
+		contentExpr, encodingExpr = "external_path", "'"+storage.Identity+"'"
+		if containsString(cols, "encoding") {
+			encodingExpr = "encoding"
+		}
+	}
+
+	mimeExpr := "NULL"
+	if containsString(cols, "mime_type") {
+		mimeExpr = "mime_type"
+	}
+
+	// Read source rows up front: the run database has a single connection,
+	// so inserts cannot be interleaved with an open cursor.
+	type sourceRow struct {
+		id       string
+		content  []byte
+		encoding sql.NullString
+		mimeType sql.NullString
+		chain    string
+	}
+	var sourceRows []sourceRow
+	rows, err := runDB.QueryContext(ctx, fmt.Sprintf("SELECT id, %s, %s, %s, %s FROM %s", contentExpr, encodingExpr, mimeExpr, chainExpr, source))
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	for rows.Next() {
+		var r sourceRow
+		if err := rows.Scan(&r.id, &r.content, &r.encoding, &r.mimeType, &r.chain); err != nil {
+			ReportError(ctx, fmt.Errorf("scan row %d: %w", len(sourceRows)+1, err))
+			continue
+		}
+		sourceRows = append(sourceRows, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
 	// Create output table
-	colDefs := "id TEXT, file_id TEXT, segment_type TEXT, content TEXT, page INTEGER, position INTEGER"
+	colDefs := "id TEXT, file_id TEXT, extractor TEXT, extractor_version TEXT, segment_type TEXT, content TEXT, page INTEGER, position INTEGER, bbox TEXT, confidence REAL, " + lineageColumn + " TEXT"
 	_, err = runDB.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", step.Output, colDefs))
 	if err != nil {
 		return err
 	}
 
 	// Process each row
-	for rows.Next() {
-		var id string
-		var content []byte
-		if err := rows.Scan(&id, &content); err != nil {
+	for done, r := range sourceRows {
+		ReportProgress(ctx, int64(done+1), total)
+
+		if stored[r.id] {
+			_, err := runDB.ExecContext(ctx, fmt.Sprintf(`
+				INSERT INTO %s (id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence, %s)
+				SELECT id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence, ?
+				FROM corpus.extracted_segments
+				WHERE file_id = ? AND extractor = ? AND extractor_version = ?
+				ORDER BY position
+			`, step.Output, lineageColumn), r.chain, r.id, name, version)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok {
+			ReportError(ctx, fmt.Errorf("extract %s: no extractor %s registered", r.id, cfg.Extractor))
 			continue
 		}
 
+		content := r.content
+		if fromStorage {
+			content, err = storage.ReadFile(string(r.content), r.encoding.String)
+			if err != nil {
+				ReportError(ctx, fmt.Errorf("read %s: %w", r.id, err))
+				continue
+			}
+		}
+		if !r.mimeType.Valid || textMIME(r.mimeType.String) {
+			content = utf8Text(content)
+		}
+
 		segments, err := extractor.Extract(ctx, content, step.Config)
 		if err != nil {
+			ReportError(ctx, fmt.Errorf("extract %s: %w", r.id, err))
 			continue
 		}
 
 		for i, seg := range segments {
-			seg.FileID = id
+			seg.FileID = r.id
 			seg.Position = i
+			if seg.ID == "" {
+				hash := sha256.Sum256([]byte(r.id + "#" + name + "#" + version + "#" + strconv.Itoa(i)))
+				seg.ID = hex.EncodeToString(hash[:16])
+			}
 			_, err := runDB.ExecContext(ctx, fmt.Sprintf(`
-				INSERT INTO %s (id, file_id, segment_type, content, page, position)
-				VALUES (?, ?, ?, ?, ?, ?)
-			`, step.Output), seg.ID, seg.FileID, seg.SegmentType, seg.Content, seg.Page, seg.Position)
+				INSERT INTO %s (id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence, %s)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?)
+			`, step.Output, lineageColumn), seg.ID, seg.FileID, name, version,
+				seg.SegmentType, seg.Content, seg.Page, seg.Position, seg.BBox, seg.Confidence, r.chain)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	return nil
+	// Segments go to the corpus even when no parse step follows
+	_, err = runDB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR IGNORE INTO _output_segments
+			(id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence)
+		SELECT id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence
+		FROM %s ORDER BY rowid
+	`, step.Output))
+	return err
+}
+
+// utf8Text returns content with text in UTF-8, as extractors expect:
+// UTF-16 content, recognized by its byte order mark, is decoded and a UTF-8
+// byte order mark is dropped. Other content is returned as is.
+func utf8Text(content []byte) []byte {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(content, []byte{0xff, 0xfe}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(content, []byte{0xfe, 0xff}):
+		order = binary.BigEndian
+	default:
+		return bytes.TrimPrefix(content, []byte{0xef, 0xbb, 0xbf})
+	}
+	units := make([]uint16, (len(content)-2)/2)
+	for i := range units {
+		units[i] = order.Uint16(content[2+2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// textMIME reports whether a MIME type is routed as text: text/* and the
+// textual application and image types. Binary documents (PDF, office
+// files, archives) are handed to extractors as is.
+func textMIME(mimeType string) bool {
+	switch {
+	case strings.HasPrefix(mimeType, "text/"),
+		strings.HasSuffix(mimeType, "+xml"), strings.HasSuffix(mimeType, "+json"):
+		return true
+	}
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript", "application/x-sh", "application/rtf":
+		return true
+	}
+	return false
+}
+
+// storedSegmentFiles returns the files of source the corpus holds segments
+// of from an extractor version.
+func storedSegmentFiles(ctx context.Context, runDB *db.DB, source, extractor, version string) ([]string, error) {
+	rows, err := runDB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT DISTINCT file_id FROM corpus.extracted_segments
+		WHERE extractor = ? AND extractor_version = ? AND file_id IN (SELECT id FROM %s)
+	`, source), extractor, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 // logStepExecution logs a step execution to the run database.
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"goraglite/internal/db"
+)
+
+func TestUTF8Text(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"utf-16le", []byte{0xff, 0xfe, 'h', 0, 0xe9, 0, '!', 0}, "hé!"},
+		{"utf-16be", []byte{0xfe, 0xff, 0, 'h', 0, 0xe9, 0, '!'}, "hé!"},
+		{"utf-16le surrogate pair", []byte{0xff, 0xfe, 0x3d, 0xd8, 0x00, 0xde}, "\U0001F600"},
+		{"utf-8 bom", []byte("\xef\xbb\xbfhé"), "hé"},
+		{"utf-8", []byte("hé"), "hé"},
+		{"binary", []byte("%PDF-1.7\x00\xff"), "%PDF-1.7\x00\xff"},
+	} {
+		if got := utf8Text(tc.content); !bytes.Equal(got, []byte(tc.want)) {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// recordingExtractor records the content it is handed.
+type recordingExtractor struct {
+	got *[][]byte
+}
+
+func (recordingExtractor) Name() string    { return "record" }
+func (recordingExtractor) Version() string { return "1" }
+
+func (r recordingExtractor) Extract(ctx context.Context, content []byte, config json.RawMessage) ([]ExtractedSegment, error) {
+	*r.got = append(*r.got, content)
+	return nil, nil
+}
+
+// TestExternalTextDecoding checks that only text inputs are decoded to UTF-8
+// before extraction.
+func TestExternalTextDecoding(t *testing.T) {
+	ctx := context.Background()
+	runDB, err := db.CreateRun(t.TempDir(), "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runDB.Close()
+
+	utf16 := []byte{0xff, 0xfe, 'h', 0, 'i', 0}
+	if _, err := runDB.ExecContext(ctx, `CREATE TABLE src (id TEXT, content BLOB, mime_type TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []struct {
+		id, mimeType string
+		content      []byte
+	}{
+		{"text", "text/plain", utf16},
+		{"binary", "application/zip", utf16},
+	} {
+		if _, err := runDB.ExecContext(ctx, "INSERT INTO src VALUES (?, ?, ?)", row.id, row.content, row.mimeType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewEngine(nil, nil, "")
+	var got [][]byte
+	ext := recordingExtractor{got: &got}
+	e.RegisterExtractor(ext)
+	step := &Step{Output: "segments", Config: json.RawMessage(`{"extractor": "record", "reextract": true}`)}
+	if err := e.executeExternal(ctx, runDB, step, "src"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("extractor called %d times, want 2", len(got))
+	}
+	if string(got[0]) != "hi" {
+		t.Errorf("text input handed as %q, want UTF-8", got[0])
+	}
+	if !bytes.Equal(got[1], utf16) {
+		t.Errorf("binary input handed as %q, want its bytes", got[1])
+	}
+}
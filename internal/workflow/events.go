@@ -0,0 +1,130 @@
+package workflow
+
+import (
+	"context"
+	"time"
+)
+
+// EventType identifies a run progress event.
+type EventType string
+
+const (
+	EventRunStarted    EventType = "run_started"
+	EventStepStarted   EventType = "step_started"
+	EventStepFinished  EventType = "step_finished"
+	EventBatchProgress EventType = "batch_progress"
+	EventRunFinished   EventType = "run_finished"
+	EventError         EventType = "error"
+)
+
+// Event reports the progress of a run.
+// Events are delivered synchronously, in order, on the goroutine executing the run.
+type Event struct {
+	Type       EventType     `json:"type"`
+	Time       time.Time     `json:"time"`
+	RunID      string        `json:"run_id"`
+	WorkflowID string        `json:"workflow_id"`
+	WorkerID   string        `json:"worker_id,omitempty"`
+	TotalSteps int           `json:"total_steps,omitempty"`
+	StepOrder  int           `json:"step_order,omitempty"`
+	StepName   string        `json:"step_name,omitempty"`
+	RowsIn     int64         `json:"rows_in,omitempty"`
+	RowsOut    int64         `json:"rows_out,omitempty"`
+	Done       int64         `json:"done,omitempty"`  // batch progress: rows processed so far
+	Total      int64         `json:"total,omitempty"` // batch progress: rows to process
+	Duration   time.Duration `json:"duration,omitempty"`
+	Status     RunStatus     `json:"status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// EventHandler receives run progress events.
+type EventHandler func(Event)
+
+// runEvents stamps and forwards events for a single run.
+type runEvents struct {
+	run        *Run
+	totalSteps int
+	handler    EventHandler
+}
+
+func (r *runEvents) emit(ev Event) {
+	if r == nil || r.handler == nil {
+		return
+	}
+	ev.Time = time.Now()
+	ev.RunID = r.run.ID
+	ev.WorkflowID = r.run.WorkflowID
+	ev.WorkerID = r.run.WorkerID
+	if ev.TotalSteps == 0 {
+		ev.TotalSteps = r.totalSteps
+	}
+	r.handler(ev)
+}
+
+// stepScope is carried in the context while a step executes so that
+// operations can report batch progress without knowing about the run.
+type stepScope struct {
+	events *runEvents
+	step   *Step
+	notes  map[string]any // recorded in _step_executions.notes
+	params []any          // run parameters, bound as :name in step SQL
+}
+
+type stepScopeKey struct{}
+
+func withStepScope(ctx context.Context, events *runEvents, step *Step, params []any) context.Context {
+	return context.WithValue(ctx, stepScopeKey{}, &stepScope{events: events, step: step, params: params})
+}
+
+// ReportProgress emits a batch progress event for the step running in ctx.
+// Custom operations call it from Execute; it is a no-op outside a run.
+func ReportProgress(ctx context.Context, done, total int64) {
+	scope, ok := ctx.Value(stepScopeKey{}).(*stepScope)
+	if !ok {
+		return
+	}
+	scope.events.emit(Event{
+		Type:      EventBatchProgress,
+		StepOrder: scope.step.StepOrder,
+		StepName:  scope.step.StepName,
+		Done:      done,
+		Total:     total,
+	})
+}
+
+// ReportError emits a non-fatal error event for the step running in ctx,
+// e.g. a single row an extractor could not handle.
+func ReportError(ctx context.Context, err error) {
+	scope, ok := ctx.Value(stepScopeKey{}).(*stepScope)
+	if !ok || err == nil {
+		return
+	}
+	scope.events.emit(Event{
+		Type:      EventError,
+		StepOrder: scope.step.StepOrder,
+		StepName:  scope.step.StepName,
+		Error:     err.Error(),
+	})
+}
+
+// SetStepNote records a value in the notes of the step running in ctx
+// (e.g. the chunking strategy version it used). It is a no-op outside a run.
+func SetStepNote(ctx context.Context, key string, value any) {
+	scope, ok := ctx.Value(stepScopeKey{}).(*stepScope)
+	if !ok {
+		return
+	}
+	if scope.notes == nil {
+		scope.notes = make(map[string]any)
+	}
+	scope.notes[key] = value
+}
+
+// stepNotes returns the notes recorded for the step running in ctx.
+func stepNotes(ctx context.Context) map[string]any {
+	scope, ok := ctx.Value(stepScopeKey{}).(*stepScope)
+	if !ok {
+		return nil
+	}
+	return scope.notes
+}
@@ -0,0 +1,57 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"goraglite/internal/db"
+)
+
+// lineageColumn holds the JSON lineage of a row: the input id followed by
+// one "table#rowid" reference per step that materialised the row.
+// It ends up in _output._source_chain and is merged into corpus.chunk_lineage.
+const lineageColumn = "_source_chain"
+
+// extendLineage appends the step's own row reference to the lineage of every
+// row in the step output. Rows that did not inherit a lineage (first step,
+// explicit aggregates) start a new one from their id.
+func (e *Engine) extendLineage(ctx context.Context, runDB *db.DB, step *Step) error {
+	cols, err := runDB.Columns(ctx, step.Output)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return nil // Step did not materialise a table
+	}
+
+	if !containsString(cols, lineageColumn) {
+		if _, err := runDB.ExecContext(ctx, fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN %s TEXT", step.Output, lineageColumn,
+		)); err != nil {
+			return err
+		}
+		seed := "json_array()"
+		if containsString(cols, "id") {
+			seed = "json_array(id)"
+		}
+		if _, err := runDB.ExecContext(ctx, fmt.Sprintf(
+			"UPDATE %s SET %s = %s", step.Output, lineageColumn, seed,
+		)); err != nil {
+			return err
+		}
+	}
+
+	_, err = runDB.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET %s = json_insert(COALESCE(%s, json_array()), '$[#]', '%s#' || rowid)
+	`, step.Output, lineageColumn, lineageColumn, step.Output))
+	return err
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
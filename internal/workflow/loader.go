@@ -77,7 +77,7 @@ func (l *Loader) ListWorkflows(ctx context.Context) ([]Workflow, error) {
 	var workflows []Workflow
 	for rows.Next() {
 		var w Workflow
-		err := rows.Scan(&w.ID, &w.Name, &w.Version, &w.Description, &w.Status, &w.CreatedAt)
+		err := rows.Scan(&w.ID, &w.Name, &w.Version, &w.Description, &w.Status, db.Time(&w.CreatedAt))
 		if err != nil {
 			return nil, err
 		}
@@ -126,7 +126,7 @@ func (l *Loader) FindWorkflowsByTag(ctx context.Context, tag string) ([]Workflow
 	var workflows []Workflow
 	for rows.Next() {
 		var w Workflow
-		err := rows.Scan(&w.ID, &w.Name, &w.Version, &w.Description, &w.Status, &w.CreatedAt)
+		err := rows.Scan(&w.ID, &w.Name, &w.Version, &w.Description, &w.Status, db.Time(&w.CreatedAt))
 		if err != nil {
 			return nil, err
 		}
@@ -163,6 +163,12 @@ func (l *Loader) DeleteWorkflow(ctx context.Context, workflowID string) error {
 		if _, err := tx.ExecContext(ctx, "DELETE FROM workflow_steps WHERE workflow_id = ?", workflowID); err != nil {
 			return err
 		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM workflow_metrics WHERE workflow_id = ?", workflowID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM workflow_step_metrics WHERE workflow_id = ?", workflowID); err != nil {
+			return err
+		}
 		if _, err := tx.ExecContext(ctx, "DELETE FROM workflows WHERE id = ?", workflowID); err != nil {
 			return err
 		}
@@ -0,0 +1,161 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// VersionMetrics summarizes the runs of one workflow version.
+type VersionMetrics struct {
+	WorkflowID  string        `json:"workflow_id"`
+	Version     int           `json:"version"`
+	Runs        int           `json:"runs"`
+	SuccessRate float64       `json:"success_rate"`
+	AvgDuration time.Duration `json:"avg_duration"`
+	AvgRowsOut  float64       `json:"avg_rows_out"` // rows produced by the last step
+}
+
+// StepMetrics summarizes the executions of one step across completed runs.
+type StepMetrics struct {
+	StepOrder   int           `json:"step_order"`
+	StepName    string        `json:"step_name"`
+	Runs        int           `json:"runs"`
+	AvgDuration time.Duration `json:"avg_duration"`
+	AvgRowsIn   float64       `json:"avg_rows_in"`
+	AvgRowsOut  float64       `json:"avg_rows_out"`
+}
+
+// RollupMetrics recomputes workflow_metrics and workflow_step_metrics from
+// the run_history and step_history recorded by the merger in corpus.db.
+func (l *Loader) RollupMetrics(ctx context.Context, corpusPath string) error {
+	if err := l.db.Attach(ctx, corpusPath, "corpus"); err != nil {
+		return err
+	}
+	defer l.db.Detach(ctx, "corpus")
+
+	return l.db.Transaction(ctx, func(tx *sql.Tx) error {
+		statements := []string{
+			`DELETE FROM workflow_metrics WHERE metric_name IN ('success_rate', 'avg_duration', 'avg_rows_out')`,
+			`DELETE FROM workflow_step_metrics`,
+
+			`INSERT INTO workflow_metrics (workflow_id, workflow_version, metric_name, metric_value, sample_size)
+			SELECT r.workflow_id, r.workflow_version, 'success_rate', AVG(r.status = 'completed'), COUNT(*)
+			FROM corpus.run_history r
+			JOIN workflows w ON w.id = r.workflow_id
+			WHERE r.status IN ('completed', 'failed')
+			GROUP BY r.workflow_id, r.workflow_version`,
+
+			// Wall-clock duration, falling back to the sum of step durations
+			`INSERT INTO workflow_metrics (workflow_id, workflow_version, metric_name, metric_value, sample_size)
+			SELECT workflow_id, workflow_version, 'avg_duration', AVG(duration_ms), COUNT(duration_ms)
+			FROM (
+				SELECT r.workflow_id, r.workflow_version, COALESCE(
+					(julianday(r.finished_at) - julianday(r.started_at)) * 86400000.0,
+					(SELECT SUM(s.duration_ms) FROM corpus.step_history s WHERE s.run_id = r.run_id)
+				) AS duration_ms
+				FROM corpus.run_history r
+				JOIN workflows w ON w.id = r.workflow_id
+				WHERE r.status = 'completed'
+			)
+			GROUP BY workflow_id, workflow_version
+			HAVING COUNT(duration_ms) > 0`,
+
+			`INSERT INTO workflow_metrics (workflow_id, workflow_version, metric_name, metric_value, sample_size)
+			SELECT r.workflow_id, r.workflow_version, 'avg_rows_out', AVG(s.rows_out), COUNT(*)
+			FROM corpus.run_history r
+			JOIN workflows w ON w.id = r.workflow_id
+			JOIN corpus.step_history s ON s.run_id = r.run_id
+				AND s.step_order = (SELECT MAX(step_order) FROM corpus.step_history WHERE run_id = r.run_id)
+			WHERE r.status = 'completed'
+			GROUP BY r.workflow_id, r.workflow_version`,
+
+			`INSERT INTO workflow_step_metrics
+			(workflow_id, workflow_version, step_order, step_name, avg_duration_ms, avg_rows_in, avg_rows_out, sample_size)
+			SELECT r.workflow_id, r.workflow_version, s.step_order, MAX(s.step_name),
+				AVG(s.duration_ms), AVG(s.rows_in), AVG(s.rows_out), COUNT(*)
+			FROM corpus.step_history s
+			JOIN corpus.run_history r ON r.run_id = s.run_id
+			JOIN workflows w ON w.id = r.workflow_id
+			WHERE r.status = 'completed'
+			GROUP BY r.workflow_id, r.workflow_version, s.step_order`,
+		}
+
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("rollup metrics: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetMetrics returns the rolled-up metrics of a workflow, newest version first.
+func (l *Loader) GetMetrics(ctx context.Context, workflowID string) ([]VersionMetrics, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT workflow_version, metric_name, metric_value, sample_size
+		FROM workflow_metrics
+		WHERE workflow_id = ?
+		ORDER BY workflow_version DESC
+	`, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []VersionMetrics
+	for rows.Next() {
+		var version, samples int
+		var name string
+		var value float64
+		if err := rows.Scan(&version, &name, &value, &samples); err != nil {
+			return nil, err
+		}
+
+		if len(metrics) == 0 || metrics[len(metrics)-1].Version != version {
+			metrics = append(metrics, VersionMetrics{WorkflowID: workflowID, Version: version})
+		}
+		m := &metrics[len(metrics)-1]
+
+		switch name {
+		case "success_rate":
+			m.SuccessRate = value
+			m.Runs = samples
+		case "avg_duration":
+			m.AvgDuration = time.Duration(value * float64(time.Millisecond))
+		case "avg_rows_out":
+			m.AvgRowsOut = value
+		}
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetStepMetrics returns the per-step metrics of a workflow version.
+func (l *Loader) GetStepMetrics(ctx context.Context, workflowID string, version int) ([]StepMetrics, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT step_order, step_name, sample_size,
+			COALESCE(avg_duration_ms, 0), COALESCE(avg_rows_in, 0), COALESCE(avg_rows_out, 0)
+		FROM workflow_step_metrics
+		WHERE workflow_id = ? AND workflow_version = ?
+		ORDER BY step_order
+	`, workflowID, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []StepMetrics
+	for rows.Next() {
+		var s StepMetrics
+		var durationMs float64
+		if err := rows.Scan(&s.StepOrder, &s.StepName, &s.Runs, &durationMs, &s.AvgRowsIn, &s.AvgRowsOut); err != nil {
+			return nil, err
+		}
+		s.AvgDuration = time.Duration(durationMs * float64(time.Millisecond))
+		steps = append(steps, s)
+	}
+
+	return steps, rows.Err()
+}
@@ -0,0 +1,374 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"goraglite/internal/db"
+)
+
+// unitTypes are the unit types accepted by corpus.parsed_units.
+var unitTypes = map[string]bool{
+	"paragraph": true, "heading": true, "list_item": true,
+	"cell": true, "code_block": true, "sentence": true,
+}
+
+// parseRuleConfig is the config column of a parse_rules row.
+type parseRuleConfig struct {
+	Level      int  `json:"level,omitempty"`
+	LevelGroup int  `json:"level_group,omitempty"`
+	PerLine    bool `json:"per_line,omitempty"`
+}
+
+// ActiveParseRules returns the active rules of the parse_rules table, highest
+// priority first. When ids is not empty only those rules are returned.
+func (l *Loader) ActiveParseRules(ctx context.Context, ids []string) ([]ParseRule, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT id, pattern, target_type, output_type, priority, config
+		FROM parse_rules
+		WHERE active = 1
+		ORDER BY priority DESC, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []ParseRule
+	for rows.Next() {
+		var r ParseRule
+		var config sql.NullString
+		if err := rows.Scan(&r.ID, &r.Pattern, &r.TargetType, &r.Type, &r.Priority, &config); err != nil {
+			return nil, err
+		}
+		if len(ids) > 0 && !containsString(ids, r.ID) {
+			continue
+		}
+		// Rule config carries level, level_group and per_line only
+		if config.Valid && config.String != "" {
+			var rc parseRuleConfig
+			if err := json.Unmarshal([]byte(config.String), &rc); err != nil {
+				return nil, fmt.Errorf("parse rule %s config: %w", r.ID, err)
+			}
+			r.Level, r.LevelGroup, r.PerLine = rc.Level, rc.LevelGroup, rc.PerLine
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// compiledRule is a parse rule with its pattern compiled.
+type compiledRule struct {
+	ParseRule
+	re *regexp.Regexp
+}
+
+func compileParseRules(rules []ParseRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, r := range rules {
+		name := r.ID
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if !unitTypes[r.Type] {
+			return nil, fmt.Errorf("parse rule %s: unknown unit type %q", name, r.Type)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("parse rule %s: %w", name, err)
+		}
+		compiled = append(compiled, compiledRule{ParseRule: r, re: re})
+	}
+	return compiled, nil
+}
+
+// level returns the heading level of a matched block.
+func (r *compiledRule) level(block string) int {
+	if r.LevelGroup > 0 {
+		if m := r.re.FindStringSubmatch(block); len(m) > r.LevelGroup && m[r.LevelGroup] != "" {
+			return len(m[r.LevelGroup])
+		}
+	}
+	if r.Level > 0 {
+		return r.Level
+	}
+	return 1
+}
+
+// parseSegment is a source row of a parse step.
+type parseSegment struct {
+	id, fileID, segmentType, content string
+	extractor, extractorVersion      string
+	page                             sql.NullInt64
+	position                         int
+	bbox                             sql.NullString
+	confidence                       sql.NullFloat64
+	chain                            string
+}
+
+// parsedUnit is a unit produced from a segment.
+type parsedUnit struct {
+	unitType string
+	level    int
+	content  string
+}
+
+// headingRef is an open heading while building the hierarchy.
+type headingRef struct {
+	id    string
+	level int
+}
+
+// executeParse executes a parse operation: segments are split into units
+// (heading, paragraph, list_item, code_block...) with parse rules, and
+// headings give the units their parent. The units are also appended to
+// _output_units, and their segments to _output_segments, for the merger.
+func (e *Engine) executeParse(ctx context.Context, runDB *db.DB, step *Step, source string) error {
+	var cfg ParseConfig
+	if err := step.DecodeConfig(&cfg); err != nil {
+		return err
+	}
+
+	rules := cfg.ParseRules
+	if len(rules) == 0 {
+		var err error
+		if rules, err = NewLoader(e.workflowsDB).ActiveParseRules(ctx, cfg.RuleIDs); err != nil {
+			return fmt.Errorf("load parse rules: %w", err)
+		}
+	}
+	compiled, err := compileParseRules(rules)
+	if err != nil {
+		return err
+	}
+
+	segments, err := readParseSegments(ctx, runDB, source)
+	if err != nil {
+		return err
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE %s (
+			id TEXT, segment_id TEXT, file_id TEXT, segment_type TEXT, unit_type TEXT,
+			level INTEGER, content TEXT, tokens INTEGER, parent_id TEXT, page INTEGER,
+			position INTEGER, segment_position INTEGER, unit_ids TEXT, %s TEXT
+		)`, step.Output, lineageColumn),
+		`CREATE TABLE IF NOT EXISTS _output_units (
+			id TEXT PRIMARY KEY, segment_id TEXT NOT NULL, unit_type TEXT NOT NULL, level INTEGER,
+			content TEXT NOT NULL, tokens INTEGER, parent_id TEXT, position INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS _output_segments (
+			id TEXT PRIMARY KEY, file_id TEXT NOT NULL, extractor TEXT NOT NULL, extractor_version TEXT NOT NULL,
+			segment_type TEXT NOT NULL, content TEXT NOT NULL, page INTEGER, position INTEGER NOT NULL,
+			bbox TEXT, confidence REAL
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := runDB.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	var headings []headingRef
+	var fileID string
+	var filePos int
+
+	for done, seg := range segments {
+		ReportProgress(ctx, int64(done+1), int64(len(segments)))
+
+		// Hierarchy and positions are per file
+		if seg.fileID != fileID {
+			fileID, headings, filePos = seg.fileID, nil, 0
+		}
+		if len(strings.TrimSpace(seg.content)) < cfg.MinContentLength {
+			continue
+		}
+
+		_, err := runDB.ExecContext(ctx, `
+			INSERT OR IGNORE INTO _output_segments
+				(id, file_id, extractor, extractor_version, segment_type, content, page, position, bbox, confidence)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, seg.id, seg.fileID, seg.extractor, seg.extractorVersion, seg.segmentType, seg.content, seg.page, seg.position,
+			seg.bbox, seg.confidence)
+		if err != nil {
+			return err
+		}
+
+		for i, u := range splitUnits(seg, compiled) {
+			hash := sha256.Sum256([]byte(seg.id + "#" + strconv.Itoa(i)))
+			unitID := hex.EncodeToString(hash[:16])
+
+			var parentID sql.NullString
+			level := u.level
+			if u.unitType == "heading" {
+				for len(headings) > 0 && headings[len(headings)-1].level >= u.level {
+					headings = headings[:len(headings)-1]
+				}
+				if len(headings) > 0 {
+					parentID = sql.NullString{String: headings[len(headings)-1].id, Valid: true}
+				}
+				headings = append(headings, headingRef{id: unitID, level: u.level})
+			} else if len(headings) > 0 {
+				parent := headings[len(headings)-1]
+				parentID = sql.NullString{String: parent.id, Valid: true}
+				level = parent.level + 1
+			}
+
+			_, err := runDB.ExecContext(ctx, fmt.Sprintf(`
+				INSERT INTO %s (id, segment_id, file_id, segment_type, unit_type, level, content, tokens,
+					parent_id, page, position, segment_position, unit_ids, %s)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, json_array(?), ?)
+			`, step.Output, lineageColumn), unitID, seg.id, seg.fileID, seg.segmentType, u.unitType, level,
+				u.content, len(u.content)/4, parentID, seg.page, filePos, i, unitID, seg.chain)
+			if err != nil {
+				return err
+			}
+			filePos++
+		}
+	}
+
+	_, err = runDB.ExecContext(ctx, fmt.Sprintf(`
+		INSERT OR IGNORE INTO _output_units (id, segment_id, unit_type, level, content, tokens, parent_id, position)
+		SELECT id, segment_id, unit_type, level, content, tokens, parent_id, segment_position
+		FROM %s ORDER BY rowid
+	`, step.Output))
+	return err
+}
+
+// readParseSegments reads the segments of a parse step. Only id and content
+// are required; missing columns get neutral defaults.
+func readParseSegments(ctx context.Context, runDB *db.DB, source string) ([]parseSegment, error) {
+	cols, err := runDB.Columns(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	col := func(name, fallback string) string {
+		if containsString(cols, name) {
+			return name
+		}
+		return fallback
+	}
+	chainExpr := "json_array(id)"
+	if containsString(cols, lineageColumn) {
+		chainExpr = "COALESCE(" + lineageColumn + ", json_array(id))"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, %s, %s, content, %s, %s, %s, %s, %s, %s, %s
+		FROM %s
+		ORDER BY %s, %s
+	`, col("file_id", "id"), col("segment_type", "'text'"), col("extractor", "'unknown'"),
+		col("extractor_version", "''"), col("page", "NULL"), col("position", "rowid"),
+		col("bbox", "NULL"), col("confidence", "NULL"), chainExpr,
+		source, col("file_id", "id"), col("position", "rowid"))
+
+	rows, err := runDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []parseSegment
+	for rows.Next() {
+		var s parseSegment
+		var extractor, version sql.NullString
+		if err := rows.Scan(&s.id, &s.fileID, &s.segmentType, &s.content, &extractor, &version,
+			&s.page, &s.position, &s.bbox, &s.confidence, &s.chain); err != nil {
+			return nil, err
+		}
+		s.extractor, s.extractorVersion = extractor.String, version.String
+		if s.extractor == "" {
+			s.extractor = "unknown"
+		}
+		segments = append(segments, s)
+	}
+
+	return segments, rows.Err()
+}
+
+// splitUnits splits a segment into blocks (blank-line separated, fenced code
+// kept whole) and types each block with the first matching rule.
+func splitUnits(seg parseSegment, rules []compiledRule) []parsedUnit {
+	defaultType := "paragraph"
+	if seg.segmentType == "code" {
+		defaultType = "code_block"
+	}
+
+	var units []parsedUnit
+	for _, block := range splitBlocks(seg.content) {
+		var rule *compiledRule
+		for i := range rules {
+			r := &rules[i]
+			if r.TargetType != "" && r.TargetType != "*" && r.TargetType != seg.segmentType {
+				continue
+			}
+			firstLine, _, _ := strings.Cut(block, "\n")
+			if (r.PerLine && r.re.MatchString(firstLine)) || (!r.PerLine && r.re.MatchString(block)) {
+				rule = r
+				break
+			}
+		}
+
+		switch {
+		case rule == nil:
+			units = append(units, parsedUnit{unitType: defaultType, content: block})
+		case rule.PerLine:
+			// Matching lines start a unit, other lines continue the previous one
+			for _, line := range strings.Split(block, "\n") {
+				if rule.re.MatchString(line) || len(units) == 0 || units[len(units)-1].unitType != rule.Type {
+					units = append(units, parsedUnit{unitType: rule.Type, content: line})
+					continue
+				}
+				units[len(units)-1].content += "\n" + line
+			}
+		case rule.Type == "heading":
+			units = append(units, parsedUnit{unitType: rule.Type, level: rule.level(block), content: block})
+		default:
+			units = append(units, parsedUnit{unitType: rule.Type, content: block})
+		}
+	}
+	return units
+}
+
+// splitBlocks splits text at blank lines, keeping fenced code blocks whole.
+func splitBlocks(text string) []string {
+	var blocks []string
+	var current []string
+	inFence := false
+
+	flush := func() {
+		if block := strings.TrimSpace(strings.Join(current, "\n")); block != "" {
+			blocks = append(blocks, block)
+		}
+		current = current[:0]
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if !inFence {
+				flush()
+			}
+			inFence = !inFence
+			current = append(current, line)
+			if !inFence {
+				flush()
+			}
+			continue
+		}
+		if !inFence && strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return blocks
+}
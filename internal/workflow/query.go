@@ -0,0 +1,55 @@
+package workflow
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"modernc.org/sqlite"
+
+	"goraglite/internal/db"
+	"goraglite/internal/vector"
+)
+
+func init() {
+	// cosine_similarity(a, b) compares two serialized vectors in step SQL;
+	// vectors of different dimensions, or missing ones, score NULL.
+	sqlite.MustRegisterDeterministicScalarFunction("cosine_similarity", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		a, okA := args[0].([]byte)
+		b, okB := args[1].([]byte)
+		if !okA || !okB {
+			return nil, nil
+		}
+		va, vb := vector.FromBytes(a), vector.FromBytes(b)
+		if len(va) == 0 || len(va) != len(vb) {
+			return nil, nil
+		}
+		return float64(va.CosineSimilarity(vb)), nil
+	})
+}
+
+// snapshotQuery creates the _query_input table of a search run from the
+// query parameter: the query text and its FTS5 expression, each query term
+// quoted and OR-ed. Runs without a query parameter get no _query_input.
+func (e *Engine) snapshotQuery(ctx context.Context, runDB *db.DB, cfg RunConfig) error {
+	query, ok := cfg.Parameters["query"]
+	if !ok {
+		return nil
+	}
+	terms := vector.Tokenize(query)
+	if len(terms) == 0 {
+		return fmt.Errorf("query %q has no searchable terms", query)
+	}
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"`
+	}
+
+	if _, err := runDB.ExecContext(ctx, "CREATE TABLE _query_input (query_text TEXT NOT NULL, fts_query TEXT NOT NULL)"); err != nil {
+		return err
+	}
+	_, err := runDB.ExecContext(ctx, "INSERT INTO _query_input (query_text, fts_query) VALUES (?, ?)",
+		query, strings.Join(quoted, " OR "))
+	return err
+}
@@ -0,0 +1,106 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goraglite/internal/db"
+)
+
+// paragraphExtractor stands in for the code extractor: one segment per
+// blank-line separated block.
+type paragraphExtractor struct{}
+
+func (paragraphExtractor) Name() string    { return "code" }
+func (paragraphExtractor) Version() string { return "1.0.0" }
+
+func (paragraphExtractor) Extract(ctx context.Context, content []byte, config json.RawMessage) ([]ExtractedSegment, error) {
+	var segments []ExtractedSegment
+	for _, block := range strings.Split(string(content), "\n\n") {
+		if block = strings.TrimSpace(block); block != "" {
+			segments = append(segments, ExtractedSegment{SegmentType: "code", Content: block})
+		}
+	}
+	return segments, nil
+}
+
+// TestRunFillsOutput runs a built-in chunking workflow on a stored file: the
+// finalize step fills the _output table of the run schema and every chunk
+// traces back to the file.
+func TestRunFillsOutput(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	corpusDB, err := db.OpenCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corpusDB.Close()
+	workflowsDB, err := db.OpenWorkflows(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer workflowsDB.Close()
+	if err := NewLoader(workflowsDB).LoadBuiltins(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "First paragraph of plain text, long enough to be kept.\n\nSecond paragraph of plain text, also long enough."
+	path := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := corpusDB.ExecContext(ctx, `
+		INSERT INTO raw_files (id, source_path, mime_type, size, external_path, checksum)
+		VALUES ('f1', ?, 'text/plain', ?, ?, 'f1')
+	`, path, len(content), path); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := NewEngine(corpusDB, workflowsDB, dir)
+	engine.RegisterExtractor(paragraphExtractor{})
+	run, err := engine.Run(ctx, "text_chunking_v1", RunConfig{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	runDB, err := db.Open(db.DefaultConfig(run.DBPath, db.DBTypeRun))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runDB.Close()
+
+	rows, err := runDB.QueryContext(ctx, `SELECT file_id, content, chunk_type, hash, _source_chain FROM _output`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var fileID, chunkContent, chunkType, hash, chain string
+		if err := rows.Scan(&fileID, &chunkContent, &chunkType, &hash, &chain); err != nil {
+			t.Fatal(err)
+		}
+		n++
+		sum := sha256.Sum256([]byte(chunkContent))
+		if fileID != "f1" || chunkType != ChunkSemantic || hash != hex.EncodeToString(sum[:]) || !strings.Contains(chunkContent, "First paragraph") {
+			t.Errorf("chunk: file %q, type %q, hash %q, content %q", fileID, chunkType, hash, chunkContent)
+		}
+		var links []string
+		if err := json.Unmarshal([]byte(chain), &links); err != nil || len(links) < 2 || links[0] != "f1" {
+			t.Errorf("_source_chain %s does not start at the file", chain)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d chunks in _output, want 1", n)
+	}
+}
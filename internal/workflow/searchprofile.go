@@ -0,0 +1,185 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SearchLayers are the vector layers a search profile can score on.
+var SearchLayers = []string{"structure", "lexical", "contextual"}
+
+// SearchProfile is a named search setup from the search_configs table.
+// Its values are passed to the search workflow as run parameters.
+type SearchProfile struct {
+	ID           string             `json:"id"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description,omitempty"`
+	Layers       []string           `json:"layers"`
+	LayerWeights map[string]float64 `json:"layer_weights"`
+	TopK         int                `json:"top_k"`
+	MinScore     float64            `json:"min_score"`
+	Rerank       bool               `json:"rerank"`
+	Config       json.RawMessage    `json:"config,omitempty"`
+	Active       bool               `json:"active"`
+}
+
+// Parameters returns the run parameters of the search workflow: top_k,
+// min_score, rerank, layers and one w_<layer> weight per layer. Layers the
+// profile does not use get a zero weight.
+func (p *SearchProfile) Parameters() map[string]string {
+	params := map[string]string{
+		"profile":   p.ID,
+		"top_k":     strconv.Itoa(p.TopK),
+		"min_score": strconv.FormatFloat(p.MinScore, 'f', -1, 64),
+		"rerank":    strconv.FormatBool(p.Rerank),
+		"layers":    strings.Join(p.Layers, ","),
+	}
+	for _, layer := range SearchLayers {
+		weight := 0.0
+		if containsString(p.Layers, layer) {
+			weight = p.LayerWeights[layer]
+		}
+		params["w_"+layer] = strconv.FormatFloat(weight, 'f', -1, 64)
+	}
+	return params
+}
+
+// Validate checks the layers and weights of the profile. A profile without
+// weights gets equal weights across its layers.
+func (p *SearchProfile) Validate() error {
+	if len(p.Layers) == 0 {
+		return fmt.Errorf("search profile %s: no layers", p.ID)
+	}
+	for _, layer := range p.Layers {
+		if !containsString(SearchLayers, layer) {
+			return fmt.Errorf("search profile %s: unknown layer %q", p.ID, layer)
+		}
+	}
+	for layer := range p.LayerWeights {
+		if !containsString(p.Layers, layer) {
+			return fmt.Errorf("search profile %s: weight for unused layer %q", p.ID, layer)
+		}
+	}
+	if len(p.LayerWeights) == 0 {
+		p.LayerWeights = make(map[string]float64, len(p.Layers))
+		for _, layer := range p.Layers {
+			p.LayerWeights[layer] = 1 / float64(len(p.Layers))
+		}
+	}
+	if p.TopK <= 0 {
+		return fmt.Errorf("search profile %s: top_k must be positive", p.ID)
+	}
+	return nil
+}
+
+const searchProfileColumns = `id, name, COALESCE(description, ''), layers, layer_weights,
+	top_k, min_score, rerank_enabled, config, active`
+
+func scanSearchProfile(row interface{ Scan(...any) error }) (*SearchProfile, error) {
+	var p SearchProfile
+	var layers, weights string
+	var config sql.NullString
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &layers, &weights,
+		&p.TopK, &p.MinScore, &p.Rerank, &config, &p.Active)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(layers), &p.Layers); err != nil {
+		return nil, fmt.Errorf("search profile %s layers: %w", p.ID, err)
+	}
+	if err := json.Unmarshal([]byte(weights), &p.LayerWeights); err != nil {
+		return nil, fmt.Errorf("search profile %s layer_weights: %w", p.ID, err)
+	}
+	if config.Valid && config.String != "" {
+		p.Config = json.RawMessage(config.String)
+	}
+	return &p, nil
+}
+
+// ListSearchProfiles returns all search profiles.
+func (l *Loader) ListSearchProfiles(ctx context.Context) ([]SearchProfile, error) {
+	rows, err := l.db.QueryContext(ctx, "SELECT "+searchProfileColumns+" FROM search_configs ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []SearchProfile
+	for rows.Next() {
+		p, err := scanSearchProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+
+	return profiles, rows.Err()
+}
+
+// GetSearchProfile returns a search profile by ID.
+func (l *Loader) GetSearchProfile(ctx context.Context, id string) (*SearchProfile, error) {
+	p, err := scanSearchProfile(l.db.QueryRowContext(ctx,
+		"SELECT "+searchProfileColumns+" FROM search_configs WHERE id = ?", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("search profile %s %w", id, ErrNotFound)
+	}
+	return p, err
+}
+
+// AddSearchProfile creates a new search profile.
+func (l *Loader) AddSearchProfile(ctx context.Context, p *SearchProfile) error {
+	if p.Name == "" {
+		p.Name = p.ID
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	layers, weights, config := p.encode()
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO search_configs
+		(id, name, description, layers, layer_weights, top_k, min_score, rerank_enabled, config, active)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?)
+	`, p.ID, p.Name, p.Description, layers, weights, p.TopK, p.MinScore, p.Rerank, config, p.Active)
+	if err != nil {
+		return fmt.Errorf("add search profile %s: %w", p.ID, err)
+	}
+	return nil
+}
+
+// UpdateSearchProfile saves a modified search profile.
+func (l *Loader) UpdateSearchProfile(ctx context.Context, p *SearchProfile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	layers, weights, config := p.encode()
+	res, err := l.db.ExecContext(ctx, `
+		UPDATE search_configs SET
+			name = ?, description = NULLIF(?, ''), layers = ?, layer_weights = ?,
+			top_k = ?, min_score = ?, rerank_enabled = ?, config = ?, active = ?
+		WHERE id = ?
+	`, p.Name, p.Description, layers, weights, p.TopK, p.MinScore, p.Rerank, config, p.Active, p.ID)
+	if err != nil {
+		return fmt.Errorf("update search profile %s: %w", p.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("search profile %s %w", p.ID, ErrNotFound)
+	}
+	return nil
+}
+
+// encode returns the JSON columns of the profile.
+func (p *SearchProfile) encode() (layers, weights string, config sql.NullString) {
+	b, _ := json.Marshal(p.Layers)
+	layers = string(b)
+	b, _ = json.Marshal(p.LayerWeights)
+	weights = string(b)
+	if len(p.Config) > 0 {
+		config = sql.NullString{String: string(p.Config), Valid: true}
+	}
+	return layers, weights, config
+}
@@ -0,0 +1,137 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// ErrNotFound is returned when a named strategy or configuration does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ChunkingStrategy is a named, versioned set of window parameters.
+// Window steps reference it with "strategy_id" so chunk sizes can be tuned
+// without reloading workflow SQL.
+type ChunkingStrategy struct {
+	ID              string          `json:"id"`
+	Name            string          `json:"name"`
+	Description     string          `json:"description,omitempty"`
+	ChunkType       string          `json:"chunk_type"` // semantic, fixed_window, sentence, paragraph
+	MaxTokens       int             `json:"max_tokens"`
+	MinTokens       int             `json:"min_tokens"`
+	OverlapTokens   int             `json:"overlap_tokens"`
+	BoundaryPattern string          `json:"boundary_pattern,omitempty"`
+	Config          json.RawMessage `json:"config,omitempty"` // extra WindowConfig fields
+	Active          bool            `json:"active"`
+	Version         int             `json:"version"`
+	UpdatedAt       time.Time       `json:"updated_at,omitempty"`
+}
+
+// Apply overrides the window configuration with the strategy parameters.
+func (s *ChunkingStrategy) Apply(cfg *WindowConfig) error {
+	if len(s.Config) > 0 {
+		if err := json.Unmarshal(s.Config, cfg); err != nil {
+			return fmt.Errorf("parse config: %w", err)
+		}
+	}
+	cfg.Strategy = s.ChunkType
+	cfg.MaxTokens = s.MaxTokens
+	cfg.MinTokens = s.MinTokens
+	cfg.OverlapTokens = s.OverlapTokens
+	if s.BoundaryPattern != "" {
+		cfg.BoundaryPattern = s.BoundaryPattern
+	}
+	return nil
+}
+
+const strategyColumns = `id, name, COALESCE(description, ''), chunk_type, max_tokens, min_tokens,
+	overlap_tokens, COALESCE(boundary_pattern, ''), config, active, version, updated_at`
+
+func scanStrategy(row interface{ Scan(...any) error }) (*ChunkingStrategy, error) {
+	var s ChunkingStrategy
+	var config sql.NullString
+	err := row.Scan(&s.ID, &s.Name, &s.Description, &s.ChunkType, &s.MaxTokens, &s.MinTokens,
+		&s.OverlapTokens, &s.BoundaryPattern, &config, &s.Active, &s.Version, db.Time(&s.UpdatedAt))
+	if err != nil {
+		return nil, err
+	}
+	if config.Valid && config.String != "" {
+		s.Config = json.RawMessage(config.String)
+	}
+	return &s, nil
+}
+
+// ListStrategies returns all chunking strategies.
+func (l *Loader) ListStrategies(ctx context.Context) ([]ChunkingStrategy, error) {
+	rows, err := l.db.QueryContext(ctx, "SELECT "+strategyColumns+" FROM chunking_strategies ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var strategies []ChunkingStrategy
+	for rows.Next() {
+		s, err := scanStrategy(rows)
+		if err != nil {
+			return nil, err
+		}
+		strategies = append(strategies, *s)
+	}
+
+	return strategies, rows.Err()
+}
+
+// GetStrategy returns a chunking strategy by ID.
+func (l *Loader) GetStrategy(ctx context.Context, id string) (*ChunkingStrategy, error) {
+	s, err := scanStrategy(l.db.QueryRowContext(ctx,
+		"SELECT "+strategyColumns+" FROM chunking_strategies WHERE id = ?", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("strategy %s %w", id, ErrNotFound)
+	}
+	return s, err
+}
+
+// AddStrategy creates a new chunking strategy at version 1.
+func (l *Loader) AddStrategy(ctx context.Context, s *ChunkingStrategy) error {
+	if s.Name == "" {
+		s.Name = s.ID
+	}
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO chunking_strategies
+		(id, name, description, chunk_type, max_tokens, min_tokens, overlap_tokens, boundary_pattern, config, active, version, updated_at)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, 1, datetime('now'))
+	`, s.ID, s.Name, s.Description, s.ChunkType, s.MaxTokens, s.MinTokens, s.OverlapTokens,
+		s.BoundaryPattern, string(s.Config), s.Active)
+	if err != nil {
+		return fmt.Errorf("add strategy %s: %w", s.ID, err)
+	}
+	s.Version = 1
+	return nil
+}
+
+// UpdateStrategy saves a modified strategy and bumps its version,
+// so runs can tell which parameters they were chunked with.
+func (l *Loader) UpdateStrategy(ctx context.Context, s *ChunkingStrategy) error {
+	err := l.db.QueryRowContext(ctx, `
+		UPDATE chunking_strategies SET
+			name = ?, description = NULLIF(?, ''), chunk_type = ?, max_tokens = ?, min_tokens = ?,
+			overlap_tokens = ?, boundary_pattern = NULLIF(?, ''), config = NULLIF(?, ''), active = ?,
+			version = version + 1, updated_at = datetime('now')
+		WHERE id = ?
+		RETURNING version
+	`, s.Name, s.Description, s.ChunkType, s.MaxTokens, s.MinTokens, s.OverlapTokens,
+		s.BoundaryPattern, string(s.Config), s.Active, s.ID).Scan(&s.Version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("strategy %s %w", s.ID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("update strategy %s: %w", s.ID, err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ package workflow
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -21,6 +22,21 @@ type Workflow struct {
 	Steps        []Step          `json:"steps"`
 }
 
+// ParamTypes returns the declared types of the run parameters, from the
+// "params" object of the input schema ({"top_k": "integer", ...}).
+func (w *Workflow) ParamTypes() (map[string]string, error) {
+	var schema struct {
+		Params map[string]string `json:"params"`
+	}
+	if len(w.InputSchema) == 0 {
+		return nil, nil
+	}
+	if err := json.Unmarshal(w.InputSchema, &schema); err != nil {
+		return nil, fmt.Errorf("parse input schema: %w", err)
+	}
+	return schema.Params, nil
+}
+
 // Step represents a single step in a workflow.
 type Step struct {
 	WorkflowID   string          `json:"workflow_id"`
@@ -35,6 +51,18 @@ type Step struct {
 	OnEmpty      OnEmptyAction   `json:"on_empty"`
 }
 
+// DecodeConfig unmarshals the step's JSON config into v.
+// A step without config leaves v untouched.
+func (s *Step) DecodeConfig(v any) error {
+	if len(s.Config) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(s.Config, v); err != nil {
+		return fmt.Errorf("parse %s config: %w", s.Operation, err)
+	}
+	return nil
+}
+
 // Operation represents the type of operation a step performs.
 type Operation string
 
@@ -48,10 +76,22 @@ const (
 	OpHash      Operation = "hash"
 	OpVectorize Operation = "vectorize"
 	OpExternal  Operation = "external"
+	OpParse     Operation = "parse"
 	OpFork      Operation = "fork"
 	OpMerge     Operation = "merge"
 )
 
+// IsBuiltin reports whether the operation is implemented by the engine itself.
+// Any other operation must be registered with Engine.RegisterOperation.
+func (op Operation) IsBuiltin() bool {
+	switch op {
+	case OpFilter, OpProject, OpJoin, OpAggregate, OpDiff, OpWindow,
+		OpHash, OpVectorize, OpExternal, OpParse, OpFork, OpMerge:
+		return true
+	}
+	return false
+}
+
 // OnEmptyAction specifies what to do when a step produces no results.
 type OnEmptyAction string
 
@@ -89,12 +129,16 @@ const (
 
 // RunConfig holds run-specific configuration.
 type RunConfig struct {
-	BatchSize   int               `json:"batch_size,omitempty"`
-	Timeout     time.Duration     `json:"timeout,omitempty"`
-	Parameters  map[string]string `json:"parameters,omitempty"`
-	Debug       bool              `json:"debug,omitempty"`
-	KeepTables  bool              `json:"keep_tables,omitempty"`
-	SampleSize  int               `json:"sample_size,omitempty"`
+	BatchSize  int               `json:"batch_size,omitempty"`
+	Timeout    time.Duration     `json:"timeout,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"` // bound as :name in step SQL
+	Debug      bool              `json:"debug,omitempty"`
+	KeepTables bool              `json:"keep_tables,omitempty"`
+	SampleSize int               `json:"sample_size,omitempty"`
+	WorkerID   string            `json:"worker_id,omitempty"` // recorded in _run_meta, "worker-1" when empty
+
+	// OnEvent receives progress events while the run executes. Optional.
+	OnEvent EventHandler `json:"-"`
 }
 
 // StepExecution records the execution of a single step.
@@ -115,17 +159,17 @@ type StepExecution struct {
 
 // Delta represents the change between two steps.
 type Delta struct {
-	StepFrom    int     `json:"step_from"`
-	StepTo      int     `json:"step_to"`
-	RowsBefore  int64   `json:"rows_before"`
-	RowsAfter   int64   `json:"rows_after"`
-	RowsLost    int64   `json:"rows_lost"`
-	RowsGained  int64   `json:"rows_gained"`
-	DeltaType   string  `json:"delta_type"` // reduction, expansion, transformation
-	DeltaScore  float64 `json:"delta_score"`
-	JaccardIdx  float64 `json:"jaccard_index"`
-	SampleLost  string  `json:"sample_lost,omitempty"`  // JSON sample
-	SampleGain  string  `json:"sample_gained,omitempty"`
+	StepFrom   int     `json:"step_from"`
+	StepTo     int     `json:"step_to"`
+	RowsBefore int64   `json:"rows_before"`
+	RowsAfter  int64   `json:"rows_after"`
+	RowsLost   int64   `json:"rows_lost"`
+	RowsGained int64   `json:"rows_gained"`
+	DeltaType  string  `json:"delta_type"` // reduction, expansion, transformation
+	DeltaScore float64 `json:"delta_score"`
+	JaccardIdx float64 `json:"jaccard_index"`
+	SampleLost string  `json:"sample_lost,omitempty"` // JSON sample
+	SampleGain string  `json:"sample_gained,omitempty"`
 }
 
 // FilterConfig holds configuration for filter operations.
@@ -155,14 +199,16 @@ type AggregateConfig struct {
 
 // WindowConfig holds configuration for window operations.
 type WindowConfig struct {
-	Description           string   `json:"description,omitempty"`
-	Strategy              string   `json:"strategy"` // semantic, fixed_window, sentence
-	MaxTokens             int      `json:"max_tokens"`
-	MinTokens             int      `json:"min_tokens"`
-	OverlapTokens         int      `json:"overlap_tokens"`
-	BoundaryMarkers       []string `json:"boundary_markers,omitempty"`
-	GroupBy               string   `json:"group_by,omitempty"`
-	PreferCompleteSentences bool   `json:"prefer_complete_sentences,omitempty"`
+	Description             string   `json:"description,omitempty"`
+	StrategyID              string   `json:"strategy_id,omitempty"` // chunking_strategies.id, overrides the fields below
+	Strategy                string   `json:"strategy"`              // semantic, fixed_window, sentence
+	MaxTokens               int      `json:"max_tokens"`
+	MinTokens               int      `json:"min_tokens"`
+	OverlapTokens           int      `json:"overlap_tokens"`
+	BoundaryMarkers         []string `json:"boundary_markers,omitempty"`
+	BoundaryPattern         string   `json:"boundary_pattern,omitempty"` // regex
+	GroupBy                 string   `json:"group_by,omitempty"`
+	PreferCompleteSentences bool     `json:"prefer_complete_sentences,omitempty"`
 }
 
 // HashConfig holds configuration for hash operations.
@@ -175,23 +221,29 @@ type HashConfig struct {
 
 // VectorizeConfig holds configuration for vectorize operations.
 type VectorizeConfig struct {
-	Description  string            `json:"description,omitempty"`
-	Layer        string            `json:"layer"` // structure, lexical, contextual, blend
-	Algorithm    string            `json:"algorithm"` // feature_hash, tfidf, graph_embed, blend
-	Dimensions   int               `json:"dimensions"`
-	Features     []string          `json:"features,omitempty"`
-	Sources      []string          `json:"sources,omitempty"` // for blend
+	Description  string             `json:"description,omitempty"`
+	ConfigID     string             `json:"config_id,omitempty"` // vectorization_configs.id, overrides the fields it sets
+	Layer        string             `json:"layer"`               // structure, lexical, contextual, blend
+	Algorithm    string             `json:"algorithm"`           // feature_hash, tfidf, graph_embed, blend
+	Dimensions   int                `json:"dimensions"`
+	Features     []string           `json:"features,omitempty"`
+	Sources      []string           `json:"sources,omitempty"` // for blend
 	Weights      map[string]float64 `json:"weights,omitempty"`
-	ModelVersion string            `json:"model_version"`
+	ModelVersion string             `json:"model_version"`
+	MinDF        float64            `json:"min_df,omitempty"`      // for tfidf
+	MaxDF        float64            `json:"max_df,omitempty"`      // for tfidf
+	NgramRange   []int              `json:"ngram_range,omitempty"` // for tfidf
+	Relations    []string           `json:"relations,omitempty"`   // for graph_embed
 }
 
 // ExternalConfig holds configuration for external operations.
 type ExternalConfig struct {
-	Description      string            `json:"description,omitempty"`
-	Extractor        string            `json:"extractor"`
-	ExtractorVersion string            `json:"extractor_version"`
-	Options          map[string]any    `json:"options,omitempty"`
-	OutputColumns    []string          `json:"output_columns,omitempty"`
+	Description      string         `json:"description,omitempty"`
+	Extractor        string         `json:"extractor"`
+	ExtractorVersion string         `json:"extractor_version"`
+	Options          map[string]any `json:"options,omitempty"`
+	OutputColumns    []string       `json:"output_columns,omitempty"`
+	Reextract        bool           `json:"reextract,omitempty"` // extract files the corpus holds segments of again
 }
 
 // FeatureSpec defines a feature to extract.
@@ -200,9 +252,24 @@ type FeatureSpec struct {
 	Expr string `json:"expr"` // SQL expression
 }
 
+// ParseConfig holds configuration for parse operations.
+type ParseConfig struct {
+	Description      string      `json:"description,omitempty"`
+	RuleIDs          []string    `json:"rule_ids,omitempty"`    // restrict to these parse_rules (default: all active)
+	ParseRules       []ParseRule `json:"parse_rules,omitempty"` // inline rules, replace the parse_rules table
+	MinContentLength int         `json:"min_content_length,omitempty"`
+}
+
 // ParseRule defines a parsing rule.
+// Rules loaded from the parse_rules table are applied by descending priority;
+// inline rules in the order they are listed.
 type ParseRule struct {
-	Pattern string `json:"pattern"`
-	Type    string `json:"type"`
-	Level   int    `json:"level,omitempty"`
+	ID         string `json:"id,omitempty"`
+	Pattern    string `json:"pattern"`
+	Type       string `json:"type"`                  // parsed unit type produced
+	TargetType string `json:"target_type,omitempty"` // segment type matched ("*" or empty for all)
+	Priority   int    `json:"priority,omitempty"`
+	Level      int    `json:"level,omitempty"`
+	LevelGroup int    `json:"level_group,omitempty"` // level = length of this capture group ("###" -> 3)
+	PerLine    bool   `json:"per_line,omitempty"`    // every matching line is its own unit (lists)
 }
@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"goraglite/internal/db"
+)
+
+// VectorizationConfig is a named, versioned vectorization setup.
+// Vectorize steps reference it with "config_id" so dimensions, weights or
+// model versions change for every workflow at once.
+type VectorizationConfig struct {
+	ID           string             `json:"id"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description,omitempty"`
+	Layer        string             `json:"layer"`     // structure, lexical, contextual, blend
+	Algorithm    string             `json:"algorithm"` // feature_hash, tfidf, graph_embed, blend
+	Dimensions   int                `json:"dimensions"`
+	Features     []string           `json:"features,omitempty"`
+	Weights      map[string]float64 `json:"weights,omitempty"`
+	Config       json.RawMessage    `json:"config,omitempty"` // extra algorithm params
+	ModelVersion string             `json:"model_version"`
+	Active       bool               `json:"active"`
+	Version      int                `json:"version"`
+	UpdatedAt    time.Time          `json:"updated_at,omitempty"`
+}
+
+// vectorConfigParams holds the algorithm parameters of the config column.
+type vectorConfigParams struct {
+	MinDF      float64  `json:"min_df"`
+	MaxDF      float64  `json:"max_df"`
+	NgramRange []int    `json:"ngram_range"`
+	Relations  []string `json:"relations"`
+}
+
+// Apply overrides the vectorize configuration with the values the named
+// config sets. Features and weights left empty in the table keep the
+// step's own values (they are often specific to a workflow).
+func (v *VectorizationConfig) Apply(cfg *VectorizeConfig) error {
+	// Config carries algorithm parameters only
+	if len(v.Config) > 0 {
+		var params vectorConfigParams
+		if err := json.Unmarshal(v.Config, &params); err != nil {
+			return fmt.Errorf("parse config: %w", err)
+		}
+		if params.MinDF != 0 {
+			cfg.MinDF = params.MinDF
+		}
+		if params.MaxDF != 0 {
+			cfg.MaxDF = params.MaxDF
+		}
+		if len(params.NgramRange) > 0 {
+			cfg.NgramRange = params.NgramRange
+		}
+		if len(params.Relations) > 0 {
+			cfg.Relations = params.Relations
+		}
+	}
+	cfg.Layer = v.Layer
+	cfg.Algorithm = v.Algorithm
+	cfg.Dimensions = v.Dimensions
+	cfg.ModelVersion = v.ModelVersion
+	if len(v.Features) > 0 {
+		cfg.Features = v.Features
+	}
+	if len(v.Weights) > 0 {
+		cfg.Weights = v.Weights
+	}
+	return nil
+}
+
+const vectorConfigColumns = `id, name, COALESCE(description, ''), layer, algorithm, dimensions,
+	features, weights, config, model_version, active, version, updated_at`
+
+func scanVectorConfig(row interface{ Scan(...any) error }) (*VectorizationConfig, error) {
+	var v VectorizationConfig
+	var features, weights, config sql.NullString
+	err := row.Scan(&v.ID, &v.Name, &v.Description, &v.Layer, &v.Algorithm, &v.Dimensions,
+		&features, &weights, &config, &v.ModelVersion, &v.Active, &v.Version, db.Time(&v.UpdatedAt))
+	if err != nil {
+		return nil, err
+	}
+	if features.Valid && features.String != "" {
+		if err := json.Unmarshal([]byte(features.String), &v.Features); err != nil {
+			return nil, fmt.Errorf("vectorization config %s features: %w", v.ID, err)
+		}
+	}
+	if weights.Valid && weights.String != "" {
+		if err := json.Unmarshal([]byte(weights.String), &v.Weights); err != nil {
+			return nil, fmt.Errorf("vectorization config %s weights: %w", v.ID, err)
+		}
+	}
+	if config.Valid && config.String != "" {
+		v.Config = json.RawMessage(config.String)
+	}
+	return &v, nil
+}
+
+// encode returns the JSON columns of the config, NULL when empty.
+func (v *VectorizationConfig) encode() (features, weights, config sql.NullString) {
+	if len(v.Features) > 0 {
+		b, _ := json.Marshal(v.Features)
+		features = sql.NullString{String: string(b), Valid: true}
+	}
+	if len(v.Weights) > 0 {
+		b, _ := json.Marshal(v.Weights)
+		weights = sql.NullString{String: string(b), Valid: true}
+	}
+	if len(v.Config) > 0 {
+		config = sql.NullString{String: string(v.Config), Valid: true}
+	}
+	return features, weights, config
+}
+
+// ListVectorConfigs returns all vectorization configs.
+func (l *Loader) ListVectorConfigs(ctx context.Context) ([]VectorizationConfig, error) {
+	rows, err := l.db.QueryContext(ctx, "SELECT "+vectorConfigColumns+" FROM vectorization_configs ORDER BY layer, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []VectorizationConfig
+	for rows.Next() {
+		v, err := scanVectorConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *v)
+	}
+
+	return configs, rows.Err()
+}
+
+// GetVectorConfig returns a vectorization config by ID.
+func (l *Loader) GetVectorConfig(ctx context.Context, id string) (*VectorizationConfig, error) {
+	v, err := scanVectorConfig(l.db.QueryRowContext(ctx,
+		"SELECT "+vectorConfigColumns+" FROM vectorization_configs WHERE id = ?", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("vectorization config %s %w", id, ErrNotFound)
+	}
+	return v, err
+}
+
+// AddVectorConfig creates a new vectorization config at version 1.
+func (l *Loader) AddVectorConfig(ctx context.Context, v *VectorizationConfig) error {
+	if v.Name == "" {
+		v.Name = v.ID
+	}
+	features, weights, config := v.encode()
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO vectorization_configs
+		(id, name, description, layer, algorithm, dimensions, features, weights, config, model_version, active, version, updated_at)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, 1, datetime('now'))
+	`, v.ID, v.Name, v.Description, v.Layer, v.Algorithm, v.Dimensions,
+		features, weights, config, v.ModelVersion, v.Active)
+	if err != nil {
+		return fmt.Errorf("add vectorization config %s: %w", v.ID, err)
+	}
+	v.Version = 1
+	return nil
+}
+
+// UpdateVectorConfig saves a modified vectorization config and bumps its version.
+func (l *Loader) UpdateVectorConfig(ctx context.Context, v *VectorizationConfig) error {
+	features, weights, config := v.encode()
+	err := l.db.QueryRowContext(ctx, `
+		UPDATE vectorization_configs SET
+			name = ?, description = NULLIF(?, ''), layer = ?, algorithm = ?, dimensions = ?,
+			features = ?, weights = ?, config = ?, model_version = ?, active = ?,
+			version = version + 1, updated_at = datetime('now')
+		WHERE id = ?
+		RETURNING version
+	`, v.Name, v.Description, v.Layer, v.Algorithm, v.Dimensions,
+		features, weights, config, v.ModelVersion, v.Active, v.ID).Scan(&v.Version)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("vectorization config %s %w", v.ID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("update vectorization config %s: %w", v.ID, err)
+	}
+	return nil
+}
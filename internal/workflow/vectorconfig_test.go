@@ -0,0 +1,34 @@
+package workflow
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// TestVectorConfigApply checks that the config column only sets algorithm
+// parameters: the step keeps its config id, sources and weights.
+func TestVectorConfigApply(t *testing.T) {
+	vc := VectorizationConfig{
+		ID: "go_lexical", Layer: "lexical", Algorithm: "tfidf", Dimensions: 256, ModelVersion: "go_lexical_v1",
+		Config: json.RawMessage(`{"ngram_range": [1, 2], "config_id": "other", "sources": ["x"], "weights": {"x": 1}}`),
+	}
+	cfg := VectorizeConfig{
+		ConfigID: "go_lexical", Sources: []string{"step_7"}, Weights: map[string]float64{"lexical": 0.6},
+	}
+	if err := vc.Apply(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := VectorizeConfig{
+		ConfigID: "go_lexical", Layer: "lexical", Algorithm: "tfidf", Dimensions: 256, ModelVersion: "go_lexical_v1",
+		Sources: []string{"step_7"}, Weights: map[string]float64{"lexical": 0.6}, NgramRange: []int{1, 2},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("got %+v, want %+v", cfg, want)
+	}
+
+	vc.Config = json.RawMessage(`{"ngram_range": "1-2"}`)
+	if err := vc.Apply(&cfg); err == nil {
+		t.Error("invalid config applied without error")
+	}
+}
@@ -0,0 +1,312 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"goraglite/internal/db"
+)
+
+// Chunk types of a window step, as corpus.chunks.chunk_type.
+const (
+	ChunkSemantic    = "semantic"     // source rows, cut at boundaries
+	ChunkFixedWindow = "fixed_window" // source rows, sized windows only
+	ChunkSentence    = "sentence"     // sentences of the source rows
+	ChunkParagraph   = "paragraph"    // blank-line separated blocks of the source rows
+)
+
+// windowRow is a source row of a window step.
+type windowRow struct {
+	id, fileID, content string
+	unitIDs             []string
+	page                sql.NullInt64
+	chain               string
+}
+
+// windowPiece is the smallest text a chunk is built from.
+type windowPiece struct {
+	row     *windowRow
+	content string
+}
+
+// windowChunk is a chunk being built.
+type windowChunk struct {
+	pieces  []windowPiece
+	overlap string // tail of the previous chunk
+}
+
+func (c *windowChunk) content() string {
+	parts := make([]string, 0, len(c.pieces)+1)
+	if c.overlap != "" {
+		parts = append(parts, c.overlap)
+	}
+	for _, p := range c.pieces {
+		parts = append(parts, p.content)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// approxTokens is the token estimate used across workflows (chars/4).
+func approxTokens(s string) int {
+	return len(s) / 4
+}
+
+// executeWindowChunks groups the rows of source into chunks, per file and in
+// order: rows are split according to the chunk type, a piece matching the
+// boundary pattern starts a new chunk once the current one has min_tokens,
+// chunks hold at most max_tokens and start with the last overlap_tokens of
+// the previous chunk of the file.
+func (e *Engine) executeWindowChunks(ctx context.Context, runDB *db.DB, step *Step, source string, cfg WindowConfig) error {
+	var boundary *regexp.Regexp
+	if cfg.BoundaryPattern != "" {
+		var err error
+		if boundary, err = regexp.Compile(cfg.BoundaryPattern); err != nil {
+			return fmt.Errorf("boundary pattern: %w", err)
+		}
+	}
+	switch cfg.Strategy {
+	case "":
+		cfg.Strategy = ChunkSemantic
+	case ChunkSemantic, ChunkFixedWindow, ChunkSentence, ChunkParagraph:
+	default:
+		return fmt.Errorf("unknown chunk type %q", cfg.Strategy)
+	}
+	if cfg.OverlapTokens >= cfg.MaxTokens {
+		return fmt.Errorf("overlap_tokens (%d) must be lower than max_tokens (%d)", cfg.OverlapTokens, cfg.MaxTokens)
+	}
+
+	rows, err := readWindowRows(ctx, runDB, source)
+	if err != nil {
+		return err
+	}
+
+	_, err = runDB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE %s (
+		id TEXT, file_id TEXT, unit_ids TEXT, content TEXT, token_count INTEGER, approx_tokens INTEGER,
+		chunk_type TEXT, overlap_prev INTEGER, overlap_next INTEGER, position INTEGER, chunk_position INTEGER,
+		parent_id TEXT, page INTEGER, %s TEXT
+	)`, step.Output, lineageColumn))
+	if err != nil {
+		return err
+	}
+
+	var fileID string
+	var current *windowChunk
+	position, total := 0, 0
+
+	flush := func() error {
+		if current == nil || len(current.pieces) == 0 {
+			return nil
+		}
+		content := current.content()
+		first := current.pieces[0].row
+		var unitIDs []string
+		for _, p := range current.pieces {
+			for _, id := range p.row.unitIDs {
+				if !containsString(unitIDs, id) {
+					unitIDs = append(unitIDs, id)
+				}
+			}
+		}
+		units, _ := json.Marshal(unitIDs)
+		hash := sha256.Sum256([]byte(first.fileID + "#" + strconv.Itoa(position) + "#" + content))
+		total++
+
+		// The previous chunk of the file overlaps this one
+		if current.overlap != "" {
+			if _, err := runDB.ExecContext(ctx, fmt.Sprintf(
+				"UPDATE %s SET overlap_next = ? WHERE rowid = (SELECT MAX(rowid) FROM %s)", step.Output, step.Output,
+			), approxTokens(current.overlap)); err != nil {
+				return err
+			}
+		}
+
+		_, err := runDB.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s (id, file_id, unit_ids, content, token_count, approx_tokens, chunk_type,
+				overlap_prev, overlap_next, position, chunk_position, page, %s)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?)
+		`, step.Output, lineageColumn), hex.EncodeToString(hash[:16]), first.fileID, string(units), content,
+			approxTokens(content), approxTokens(content), cfg.Strategy, approxTokens(current.overlap),
+			position, total, first.page, first.chain)
+		if err != nil {
+			return err
+		}
+		position++
+
+		// The next chunk of the file starts with the tail of this one
+		current = &windowChunk{}
+		if cfg.OverlapTokens > 0 {
+			current.overlap = tailTokens(content, cfg.OverlapTokens)
+		}
+		return nil
+	}
+
+	for done, row := range rows {
+		ReportProgress(ctx, int64(done+1), int64(len(rows)))
+
+		if row.fileID != fileID {
+			if err := flush(); err != nil {
+				return err
+			}
+			fileID, current, position = row.fileID, &windowChunk{}, 0
+		}
+
+		for _, piece := range splitWindowPieces(row, cfg.Strategy, cfg.MaxTokens) {
+			size := approxTokens(piece.content)
+			used := approxTokens(current.content())
+			atBoundary := cfg.Strategy != ChunkFixedWindow && boundary != nil && boundary.MatchString(piece.content)
+			if len(current.pieces) > 0 && (used+size > cfg.MaxTokens || (atBoundary && used >= cfg.MinTokens)) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			current.pieces = append(current.pieces, piece)
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	SetStepNote(ctx, "chunk_type", cfg.Strategy)
+	SetStepNote(ctx, "chunks", total)
+	return nil
+}
+
+// readWindowRows reads the rows of a window step in file and position
+// order. Only content is required; missing columns get neutral defaults.
+func readWindowRows(ctx context.Context, runDB *db.DB, source string) ([]windowRow, error) {
+	cols, err := runDB.Columns(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	col := func(name, fallback string) string {
+		if containsString(cols, name) {
+			return name
+		}
+		return fallback
+	}
+	id := col("id", "CAST(rowid AS TEXT)")
+	chainExpr := "json_array(" + id + ")"
+	if containsString(cols, lineageColumn) {
+		chainExpr = "COALESCE(" + lineageColumn + ", " + chainExpr + ")"
+	}
+	unitIDs := "json_array(" + id + ")"
+	if containsString(cols, "unit_ids") {
+		unitIDs = "CASE WHEN json_valid(unit_ids) THEN unit_ids ELSE " + unitIDs + " END"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s, %s, COALESCE(content, ''), %s, %s, %s
+		FROM %s
+		ORDER BY %s, %s, rowid
+	`, id, col("file_id", id), unitIDs, col("page", "NULL"), chainExpr,
+		source, col("file_id", id), col("position", "rowid"))
+
+	rows, err := runDB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []windowRow
+	for rows.Next() {
+		var r windowRow
+		var units string
+		if err := rows.Scan(&r.id, &r.fileID, &r.content, &units, &r.page, &r.chain); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(r.content) == "" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(units), &r.unitIDs); err != nil {
+			r.unitIDs = []string{r.id}
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// splitWindowPieces splits a row into the pieces of a chunk type. Pieces
+// longer than maxTokens are cut into windows at word boundaries.
+func splitWindowPieces(row windowRow, chunkType string, maxTokens int) []windowPiece {
+	var texts []string
+	switch chunkType {
+	case ChunkSentence:
+		texts = splitSentences(row.content)
+	case ChunkParagraph:
+		texts = splitBlocks(row.content)
+	default:
+		texts = []string{strings.TrimSpace(row.content)}
+	}
+
+	var pieces []windowPiece
+	for _, text := range texts {
+		for _, w := range splitWindows(text, maxTokens) {
+			pieces = append(pieces, windowPiece{row: &row, content: w})
+		}
+	}
+	return pieces
+}
+
+// splitSentences splits text after sentence punctuation followed by space.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for i := 0; i < len(text)-1; i++ {
+		if strings.IndexByte(".!?", text[i]) != -1 && (text[i+1] == ' ' || text[i+1] == '\n') {
+			if s := strings.TrimSpace(text[start : i+1]); s != "" {
+				sentences = append(sentences, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// splitWindows cuts text into windows of at most maxTokens, at word
+// boundaries.
+func splitWindows(text string, maxTokens int) []string {
+	if approxTokens(text) <= maxTokens {
+		return []string{text}
+	}
+	var windows []string
+	var current []string
+	size := 0
+	for _, word := range strings.Fields(text) {
+		if size > 0 && (size+1+len(word))/4 > maxTokens {
+			windows = append(windows, strings.Join(current, " "))
+			current, size = nil, 0
+		}
+		if size > 0 {
+			size++
+		}
+		current = append(current, word)
+		size += len(word)
+	}
+	if len(current) > 0 {
+		windows = append(windows, strings.Join(current, " "))
+	}
+	return windows
+}
+
+// tailTokens returns the last tokens of text, starting at a word.
+func tailTokens(text string, tokens int) string {
+	n := tokens * 4
+	if len(text) <= n {
+		return text
+	}
+	tail := text[len(text)-n:]
+	if i := strings.IndexAny(tail, " \n"); i != -1 {
+		tail = tail[i+1:]
+	}
+	return strings.TrimSpace(tail)
+}
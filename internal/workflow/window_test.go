@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"goraglite/internal/db"
+)
+
+// windowRun creates a run database holding the given units of one file and
+// chunks them with cfg.
+func windowRun(t *testing.T, cfg WindowConfig, units ...string) []map[string]any {
+	t.Helper()
+	ctx := context.Background()
+	runDB, err := db.Open(db.DefaultConfig(filepath.Join(t.TempDir(), "run.db"), db.DBTypeRun))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { runDB.Close() })
+
+	if _, err := runDB.ExecContext(ctx,
+		"CREATE TABLE units (id TEXT, file_id TEXT, content TEXT, position INTEGER, unit_ids TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for i, u := range units {
+		id := "u" + string(rune('a'+i))
+		if _, err := runDB.ExecContext(ctx, "INSERT INTO units VALUES (?, 'f1', ?, ?, json_array(?))", id, u, i, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := &Engine{}
+	if err := e.executeWindowChunks(ctx, runDB, &Step{Output: "chunks"}, "units", cfg); err != nil {
+		t.Fatalf("window: %v", err)
+	}
+
+	rows, err := runDB.QueryContext(ctx,
+		"SELECT content, chunk_type, overlap_prev, overlap_next, unit_ids FROM chunks ORDER BY position")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var chunks []map[string]any
+	for rows.Next() {
+		var content, chunkType, unitIDs string
+		var prev, next int
+		if err := rows.Scan(&content, &chunkType, &prev, &next, &unitIDs); err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, map[string]any{
+			"content": content, "chunk_type": chunkType, "overlap_prev": prev, "overlap_next": next, "unit_ids": unitIDs,
+		})
+	}
+	return chunks
+}
+
+func TestWindowBoundaryPattern(t *testing.T) {
+	chunks := windowRun(t, WindowConfig{Strategy: ChunkSemantic, MaxTokens: 100, BoundaryPattern: `^#`},
+		"# Intro", "first paragraph of the introduction", "# Usage", "how to use it")
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2: %v", len(chunks), chunks)
+	}
+	if !strings.HasPrefix(chunks[1]["content"].(string), "# Usage") {
+		t.Errorf("second chunk does not start at the boundary: %q", chunks[1]["content"])
+	}
+	if chunks[0]["unit_ids"] != `["ua","ub"]` || chunks[1]["chunk_type"] != ChunkSemantic {
+		t.Errorf("unexpected first chunk: %v", chunks[0])
+	}
+}
+
+func TestWindowOverlap(t *testing.T) {
+	unit := strings.Repeat("word ", 16) // 20 tokens
+	chunks := windowRun(t, WindowConfig{Strategy: ChunkFixedWindow, MaxTokens: 30, OverlapTokens: 5},
+		unit, unit, unit)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	if chunks[0]["overlap_prev"] != 0 || chunks[0]["overlap_next"] == 0 {
+		t.Errorf("first chunk overlaps: %v", chunks[0])
+	}
+	if chunks[1]["overlap_prev"] != chunks[0]["overlap_next"] {
+		t.Errorf("overlap_prev %v != previous overlap_next %v", chunks[1]["overlap_prev"], chunks[0]["overlap_next"])
+	}
+	if !strings.HasPrefix(chunks[1]["content"].(string), "word") || chunks[2]["overlap_next"] != 0 {
+		t.Errorf("unexpected overlap: %v", chunks[1:])
+	}
+}
+
+func TestWindowSentences(t *testing.T) {
+	chunks := windowRun(t, WindowConfig{Strategy: ChunkSentence, MaxTokens: 8},
+		"The first sentence is here. The second one follows! A third?")
+	if len(chunks) != 2 || chunks[0]["content"] != "The first sentence is here." {
+		t.Errorf("got %v", chunks)
+	}
+}
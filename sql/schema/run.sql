@@ -91,6 +91,32 @@ CREATE TABLE IF NOT EXISTS _deltas (
 -- Output Tables (résultats finaux à merger)
 -- ============================================================================
 
+-- _output_segments : segments extraits (couche 1), conservés pour re-chunker sans ré-extraire
+CREATE TABLE IF NOT EXISTS _output_segments (
+    id TEXT PRIMARY KEY,
+    file_id TEXT NOT NULL,
+    extractor TEXT NOT NULL,
+    extractor_version TEXT NOT NULL,
+    segment_type TEXT NOT NULL,
+    content TEXT NOT NULL,
+    page INTEGER,                           -- numéro de page si applicable
+    position INTEGER NOT NULL,
+    bbox TEXT,                              -- JSON {x, y, width, height} si applicable
+    confidence REAL                         -- score de confiance OCR/parsing
+);
+
+-- _output_units : unités parsées (couche 2), référencées par _output.unit_ids
+CREATE TABLE IF NOT EXISTS _output_units (
+    id TEXT PRIMARY KEY,
+    segment_id TEXT NOT NULL,
+    unit_type TEXT NOT NULL,
+    level INTEGER,
+    content TEXT NOT NULL,
+    tokens INTEGER,
+    parent_id TEXT,
+    position INTEGER NOT NULL
+);
+
 -- _output : chunks finaux
 CREATE TABLE IF NOT EXISTS _output (
     id TEXT PRIMARY KEY,
@@ -33,20 +33,12 @@ CREATE TABLE IF NOT EXISTS workflow_steps (
     workflow_id TEXT NOT NULL,
     step_order INTEGER NOT NULL,            -- position dans la séquence (1, 2, 3...)
     step_name TEXT NOT NULL,                -- nom lisible
-    operation TEXT NOT NULL                 -- opération atomique
-        CHECK (operation IN (
-            'filter',       -- WHERE clause
-            'project',      -- SELECT colonnes
-            'join',         -- JOIN tables
-            'aggregate',    -- GROUP BY
-            'diff',         -- calcul delta
-            'window',       -- fenêtrage SQL
-            'hash',         -- feature hashing
-            'vectorize',    -- génération vecteur
-            'external',     -- appel extracteur externe
-            'fork',         -- split en N branches
-            'merge'         -- union de branches
-        )),
+    operation TEXT NOT NULL,                -- opération atomique
+        -- built-in : filter (WHERE), project (SELECT colonnes), join, aggregate (GROUP BY),
+        -- diff (calcul delta), window (fenêtrage SQL), hash, vectorize, external (extracteur),
+        -- parse (parse_rules -> parsed_units),
+        -- fork (split en N branches), merge (union de branches).
+        -- Tout autre nom doit être enregistré côté Go via Engine.RegisterOperation.
     source TEXT NOT NULL,                   -- table source (step précédent ou table nommée)
     predicate TEXT,                         -- expression SQL (WHERE/SELECT/etc)
     output TEXT NOT NULL,                   -- nom de la table résultat
@@ -109,7 +101,9 @@ CREATE TABLE IF NOT EXISTS chunking_strategies (
     overlap_tokens INTEGER NOT NULL DEFAULT 50,
     boundary_pattern TEXT,                  -- regex pour délimitation
     config TEXT,                            -- JSON params supplémentaires
-    active INTEGER NOT NULL DEFAULT 1
+    active INTEGER NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,     -- incrémentée à chaque modification
+    updated_at TEXT                         -- dernière modification
 );
 
 -- ============================================================================
@@ -129,7 +123,9 @@ CREATE TABLE IF NOT EXISTS vectorization_configs (
     weights TEXT,                           -- JSON weights pour blend
     config TEXT,                            -- JSON params supplémentaires
     model_version TEXT NOT NULL,
-    active INTEGER NOT NULL DEFAULT 1
+    active INTEGER NOT NULL DEFAULT 1,
+    version INTEGER NOT NULL DEFAULT 1,     -- incrémentée à chaque modification
+    updated_at TEXT                         -- dernière modification
 );
 
 -- ============================================================================
@@ -191,6 +187,20 @@ CREATE TABLE IF NOT EXISTS workflow_metrics (
 
 CREATE INDEX IF NOT EXISTS idx_metrics_workflow ON workflow_metrics(workflow_id);
 
+-- Métriques par étape : repérer l'étape qui ralentit ou qui perd des lignes
+CREATE TABLE IF NOT EXISTS workflow_step_metrics (
+    workflow_id TEXT NOT NULL REFERENCES workflows(id),
+    workflow_version INTEGER NOT NULL,
+    step_order INTEGER NOT NULL,
+    step_name TEXT NOT NULL,
+    avg_duration_ms REAL,
+    avg_rows_in REAL,
+    avg_rows_out REAL,
+    sample_size INTEGER NOT NULL,           -- nombre de runs dans le calcul
+    calculated_at TEXT NOT NULL DEFAULT (datetime('now')),
+    PRIMARY KEY (workflow_id, workflow_version, step_order)
+);
+
 -- ============================================================================
 -- Hooks Configuration (événements)
 -- ============================================================================
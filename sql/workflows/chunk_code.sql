@@ -48,19 +48,17 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'step_6_unique', '{}', 0, 'continue'),
 
     ('go_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256,
-       "features": ["line_count", "has_func", "has_struct", "has_interface", "has_error_handling", "has_goroutine", "has_channel", "complexity"],
-       "model_version": "go_structure_v1"}', 0, 'continue'),
+     '{"config_id": "go_structure", "features": ["line_count", "has_func", "has_struct", "has_interface", "has_error_handling", "has_goroutine", "has_channel", "complexity"]}', 0, 'continue'),
 
     ('go_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "ngram_range": [1, 2], "model_version": "go_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "go_lexical"}', 0, 'continue'),
 
     ('go_chunking_v1', 9, 'vectorize_blend', 'vectorize', 'step_6_unique', NULL, 'step_9_vec_blend',
-     '{"layer": "blend", "algorithm": "blend", "dimensions": 256,
-       "sources": ["step_7_vec_struct", "step_8_vec_lex"], "weights": {"structure": 0.4, "lexical": 0.6},
-       "model_version": "go_blend_v1"}', 0, 'continue'),
+     '{"config_id": "go_blend", "sources": ["step_7_vec_struct", "step_8_vec_lex"], "weights": {"structure": 0.4, "lexical": 0.6}}', 0, 'continue'),
 
-    ('go_chunking_v1', 10, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('go_chunking_v1', 10, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('go_chunking_v1', 'go'), ('go_chunking_v1', 'code'), ('go_chunking_v1', 'production');
@@ -111,16 +109,17 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('python_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "py_structure_v1"}', 0, 'continue'),
+     '{"config_id": "py_structure"}', 0, 'continue'),
 
     ('python_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "py_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "py_lexical"}', 0, 'continue'),
 
     ('python_chunking_v1', 9, 'vectorize_blend', 'vectorize', 'step_6_unique', NULL, 'step_9_vec_blend',
-     '{"layer": "blend", "algorithm": "blend", "dimensions": 256,
-       "weights": {"structure": 0.35, "lexical": 0.65}, "model_version": "py_blend_v1"}', 0, 'continue'),
+     '{"config_id": "py_blend", "weights": {"structure": 0.35, "lexical": 0.65}}', 0, 'continue'),
 
-    ('python_chunking_v1', 10, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('python_chunking_v1', 10, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('python_chunking_v1', 'python'), ('python_chunking_v1', 'code'), ('python_chunking_v1', 'production');
@@ -171,16 +170,17 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('javascript_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "js_structure_v1"}', 0, 'continue'),
+     '{"config_id": "js_structure"}', 0, 'continue'),
 
     ('javascript_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "js_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "js_lexical"}', 0, 'continue'),
 
     ('javascript_chunking_v1', 9, 'vectorize_blend', 'vectorize', 'step_6_unique', NULL, 'step_9_vec_blend',
-     '{"layer": "blend", "algorithm": "blend", "dimensions": 256,
-       "weights": {"structure": 0.4, "lexical": 0.6}, "model_version": "js_blend_v1"}', 0, 'continue'),
+     '{"config_id": "js_blend", "weights": {"structure": 0.4, "lexical": 0.6}}', 0, 'continue'),
 
-    ('javascript_chunking_v1', 10, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('javascript_chunking_v1', 10, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('javascript_chunking_v1', 'javascript'), ('javascript_chunking_v1', 'js'), ('javascript_chunking_v1', 'code'), ('javascript_chunking_v1', 'production');
@@ -230,16 +230,17 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('typescript_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "ts_structure_v1"}', 0, 'continue'),
+     '{"config_id": "ts_structure"}', 0, 'continue'),
 
     ('typescript_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "ts_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "ts_lexical"}', 0, 'continue'),
 
     ('typescript_chunking_v1', 9, 'vectorize_blend', 'vectorize', 'step_6_unique', NULL, 'step_9_vec_blend',
-     '{"layer": "blend", "algorithm": "blend", "dimensions": 256,
-       "weights": {"structure": 0.45, "lexical": 0.55}, "model_version": "ts_blend_v1"}', 0, 'continue'),
+     '{"config_id": "ts_blend", "weights": {"structure": 0.45, "lexical": 0.55}}', 0, 'continue'),
 
-    ('typescript_chunking_v1', 10, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('typescript_chunking_v1', 10, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('typescript_chunking_v1', 'typescript'), ('typescript_chunking_v1', 'ts'), ('typescript_chunking_v1', 'code'), ('typescript_chunking_v1', 'production');
@@ -289,12 +290,14 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('bash_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "bash_structure_v1"}', 0, 'continue'),
+     '{"config_id": "bash_structure"}', 0, 'continue'),
 
     ('bash_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "bash_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "bash_lexical"}', 0, 'continue'),
 
-    ('bash_chunking_v1', 9, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('bash_chunking_v1', 9, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('bash_chunking_v1', 'bash'), ('bash_chunking_v1', 'shell'), ('bash_chunking_v1', 'code'), ('bash_chunking_v1', 'production');
@@ -345,12 +348,14 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('sql_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "sql_structure_v1"}', 0, 'continue'),
+     '{"config_id": "sql_structure"}', 0, 'continue'),
 
     ('sql_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "sql_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "sql_lexical"}', 0, 'continue'),
 
-    ('sql_chunking_v1', 9, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('sql_chunking_v1', 9, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('sql_chunking_v1', 'sql'), ('sql_chunking_v1', 'database'), ('sql_chunking_v1', 'code'), ('sql_chunking_v1', 'production');
@@ -400,12 +405,14 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('html_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "html_structure_v1"}', 0, 'continue'),
+     '{"config_id": "html_structure"}', 0, 'continue'),
 
     ('html_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "html_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "html_lexical"}', 0, 'continue'),
 
-    ('html_chunking_v1', 9, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('html_chunking_v1', 9, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('html_chunking_v1', 'html'), ('html_chunking_v1', 'htmx'), ('html_chunking_v1', 'web'), ('html_chunking_v1', 'production');
@@ -455,16 +462,17 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('markdown_chunking_v1', 7, 'vectorize_structure', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_struct',
-     '{"layer": "structure", "algorithm": "feature_hash", "dimensions": 256, "model_version": "md_structure_v1"}', 0, 'continue'),
+     '{"config_id": "md_structure"}', 0, 'continue'),
 
     ('markdown_chunking_v1', 8, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_8_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "md_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "md_lexical"}', 0, 'continue'),
 
     ('markdown_chunking_v1', 9, 'vectorize_blend', 'vectorize', 'step_6_unique', NULL, 'step_9_vec_blend',
-     '{"layer": "blend", "algorithm": "blend", "dimensions": 256,
-       "weights": {"structure": 0.3, "lexical": 0.7}, "model_version": "md_blend_v1"}', 0, 'continue'),
+     '{"config_id": "md_blend", "weights": {"structure": 0.3, "lexical": 0.7}}', 0, 'continue'),
 
-    ('markdown_chunking_v1', 10, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('markdown_chunking_v1', 10, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, content, MAX(length(content) / 4, 1) AS token_count, ''semantic'' AS chunk_type, content_hash AS hash, position',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('markdown_chunking_v1', 'markdown'), ('markdown_chunking_v1', 'md'), ('markdown_chunking_v1', 'documentation'), ('markdown_chunking_v1', 'production');
@@ -489,11 +497,11 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'mime_type = ''text/plain'' AND status = ''pending''',
      'step_1_text', '{}', 0, 'skip_remaining'),
 
-    ('text_chunking_v1', 2, 'parse_paragraphs', 'filter', 'step_1_text',
-     'length(content) > 10', 'step_2_parsed', '{}', 0, 'continue'),
+    ('text_chunking_v1', 2, 'parse_paragraphs', 'external', 'step_1_text', NULL, 'step_2_parsed',
+     '{"extractor": "code", "extractor_version": "1.0.0", "options": {"language": "text"}}', 0, 'continue'),
 
     ('text_chunking_v1', 3, 'chunk_by_tokens', 'window', 'step_2_parsed', NULL, 'step_3_chunks',
-     '{"strategy": "semantic", "max_tokens": 512, "min_tokens": 50, "overlap_tokens": 50}', 0, 'continue'),
+     '{"strategy_id": "semantic_default"}', 0, 'continue'),
 
     ('text_chunking_v1', 4, 'extract_features', 'aggregate', 'step_3_chunks', NULL, 'step_4_features',
      '{"features": [
@@ -510,9 +518,11 @@ INSERT OR REPLACE INTO workflow_steps VALUES
      'content_hash NOT IN (SELECT hash FROM corpus.chunks)', 'step_6_unique', '{}', 0, 'continue'),
 
     ('text_chunking_v1', 7, 'vectorize_lexical', 'vectorize', 'step_6_unique', NULL, 'step_7_vec_lex',
-     '{"layer": "lexical", "algorithm": "tfidf", "dimensions": 256, "model_version": "text_lexical_v1"}', 0, 'continue'),
+     '{"config_id": "text_lexical"}', 0, 'continue'),
 
-    ('text_chunking_v1', 8, 'finalize', 'project', 'step_6_unique', '*', '_output', '{}', 0, 'continue');
+    ('text_chunking_v1', 8, 'finalize', 'project', 'step_6_unique',
+     'id, file_id, unit_ids, content, token_count, chunk_type, overlap_prev, overlap_next, content_hash AS hash, position, parent_id',
+     '_output', '{}', 0, 'continue');
 
 INSERT OR REPLACE INTO workflow_tags (workflow_id, tag) VALUES
     ('text_chunking_v1', 'text'), ('text_chunking_v1', 'plain'), ('text_chunking_v1', 'production');
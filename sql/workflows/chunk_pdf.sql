@@ -66,17 +66,12 @@ VALUES (
     'pdf_chunking_v1',
     3,
     'parse_structure',
-    'filter',
+    'parse',
     'step_2_extracted',
-    'length(content) > 10',  -- ignorer les segments trop courts
+    NULL,
     'step_3_parsed',
     '{
-        "parse_rules": [
-            {"pattern": "^#{1,6}\\s+", "type": "heading"},
-            {"pattern": "^\\s*[-*]\\s+", "type": "list_item"},
-            {"pattern": "^```", "type": "code_block"},
-            {"pattern": "\\n\\n+", "type": "paragraph_break"}
-        ],
+        "description": "Apply active parse_rules, build the heading hierarchy",
         "min_content_length": 10
     }',
     'continue'
@@ -91,7 +86,7 @@ VALUES (
     'count_tokens',
     'project',
     'step_3_parsed',
-    'id, file_id, content, segment_type, page, position, length(content) / 4 as approx_tokens',
+    'id, file_id, content, segment_type, unit_type, level, parent_id, unit_ids, page, position, length(content) / 4 as approx_tokens',
     'step_4_with_tokens',
     '{"description": "Approximate token count (chars/4)"}',
     'continue'
@@ -109,10 +104,7 @@ VALUES (
     NULL,
     'step_5_chunks',
     '{
-        "strategy": "semantic",
-        "max_tokens": 512,
-        "min_tokens": 50,
-        "overlap_tokens": 50,
+        "strategy_id": "semantic_default",
         "boundary_markers": ["heading", "paragraph_break"],
         "prefer_complete_sentences": true
     }',
@@ -190,11 +182,8 @@ VALUES (
     NULL,
     'step_9_structure_vec',
     '{
-        "layer": "structure",
-        "algorithm": "feature_hash",
-        "dimensions": 256,
-        "features": ["token_count", "char_count", "line_count", "word_count", "avg_word_length", "uppercase_ratio", "digit_ratio"],
-        "model_version": "structure_v1"
+        "config_id": "structure_default",
+        "features": ["token_count", "char_count", "line_count", "word_count", "avg_word_length", "uppercase_ratio", "digit_ratio"]
     }',
     'continue'
 );
@@ -211,13 +200,7 @@ VALUES (
     NULL,
     'step_10_lexical_vec',
     '{
-        "layer": "lexical",
-        "algorithm": "tfidf",
-        "dimensions": 256,
-        "min_df": 2,
-        "max_df": 0.8,
-        "ngram_range": [1, 2],
-        "model_version": "lexical_tfidf_v1"
+        "config_id": "lexical_default"
     }',
     'continue'
 );
@@ -234,12 +217,9 @@ VALUES (
     NULL,
     'step_11_blend_vec',
     '{
-        "layer": "blend",
-        "algorithm": "blend",
-        "dimensions": 256,
+        "config_id": "blend_default",
         "sources": ["step_9_structure_vec", "step_10_lexical_vec"],
-        "weights": {"structure": 0.5, "lexical": 0.5},
-        "model_version": "blend_v1"
+        "weights": {"structure": 0.5, "lexical": 0.5}
     }',
     'continue'
 );
@@ -253,7 +233,7 @@ VALUES (
     'finalize_output',
     'project',
     'step_7_unique',
-    '*',
+    'id, file_id, unit_ids, content, token_count, chunk_type, overlap_prev, overlap_next, content_hash AS hash, position, parent_id',
     '_output',
     '{"description": "Copy final chunks to output table"}',
     'continue'
@@ -0,0 +1,26 @@
+-- GoRAGlite v2 - Règles de parsing par défaut
+-- Appliquées par l'opération parse, par priorité décroissante : la première règle
+-- qui correspond au début d'un bloc (séparé par une ligne vide) donne son type à l'unité.
+-- config : level (niveau fixe), level_group (niveau = longueur du groupe capturé),
+--          per_line (chaque ligne correspondante est une unité, ex. listes).
+-- INSERT OR IGNORE : les règles modifiées localement survivent à un nouvel `init`.
+
+INSERT OR IGNORE INTO parse_rules (id, name, description, pattern, target_type, output_type, priority, config)
+VALUES
+    ('code_fence', 'Code fence', 'Bloc de code délimité par ```',
+     '^```', '*', 'code_block', 100, NULL),
+
+    ('markdown_heading', 'Markdown heading', 'Titres # à ######, niveau = nombre de #',
+     '^(#{1,6})\s+', '*', 'heading', 90, '{"level_group": 1}'),
+
+    ('numbered_heading', 'Numbered heading', 'Titres numérotés courts (1. Introduction, 2.3 Résultats)',
+     '^\d+(\.\d+)*\.?\s+\S[^\n]{0,80}$', 'text', 'heading', 80, '{"level": 2}'),
+
+    ('bullet_list', 'Bullet list', 'Éléments de liste à puces',
+     '^\s*[-*+•]\s+', '*', 'list_item', 70, '{"per_line": true}'),
+
+    ('ordered_list', 'Ordered list', 'Éléments de liste numérotée',
+     '^\s*\d+[.)]\s+', '*', 'list_item', 60, '{"per_line": true}'),
+
+    ('table_row', 'Table row', 'Lignes de tableau (segments table)',
+     '\|', 'table', 'cell', 50, '{"per_line": true}');
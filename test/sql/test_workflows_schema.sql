@@ -114,7 +114,7 @@ SELECT CASE WHEN COUNT(*) = 0
        ELSE 'ERROR: Found invalid operations' END as result
 FROM workflow_steps
 WHERE operation NOT IN ('filter', 'project', 'join', 'aggregate', 'diff',
-                        'window', 'hash', 'vectorize', 'external', 'fork', 'merge');
+                        'window', 'hash', 'vectorize', 'external', 'parse', 'fork', 'merge');
 
 -- ============================================================================
 -- SUMMARY